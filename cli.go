@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bililive-downloader/ffmpeg"
 	"bililive-downloader/helper"
+	"bililive-downloader/models"
 	"bililive-downloader/progressbar"
 	"bililive-downloader/version"
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/c2h5oh/datasize"
@@ -13,6 +16,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,9 +24,12 @@ import (
 )
 
 const defaultConcurrency = 2
+const defaultRetries = 3
+const defaultRetryBackoff = time.Second * 2
 const (
 	returnCodeOk int = iota
 	returnCodeError
+	returnCodeInterrupted = 130 // As if killed by SIGINT
 )
 
 var timezone *time.Location
@@ -46,28 +53,280 @@ func extractRecordID(link string) (string, error) {
 	return recordId, nil
 }
 
-// handleDownloadAction handles `download` subcommand. The only error it might return is cli.Exit.
-func handleDownloadAction(c *cli.Context) error {
+// readRecordList reads record links or IDs from given file (`-` for stdin), one per line.
+// Empty lines and comments (starting with `#`) are ignored, as well as duplicated records.
+func readRecordList(filePath string) ([]string, error) {
+	var input io.Reader = os.Stdin
+	if filePath != "-" {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var recordIDs []string
+	scanner := bufio.NewScanner(input)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if idx := strings.Index(line, " #"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		recordID, err := extractRecordID(line)
+		if err != nil {
+			return nil, fmt.Errorf("第%d行：%w", lineNumber, err)
+		}
+		if !helper.ContainsString(recordIDs, recordID) {
+			recordIDs = append(recordIDs, recordID)
+		}
+	}
+
+	return recordIDs, scanner.Err()
+}
+
+// loadRecordMeta fetches record info, liver info and parts list of `p.RecordID` from bilibili API with `client`.
+// Errors are logged, the returned error is suitable for displaying to user.
+func loadRecordMeta(ctx context.Context, client *BiliClient, p *DownloadParam) error {
+	if recordInfo, err := client.fetchRecordInfo(ctx, p.RecordID); err != nil {
+		logger.Error().Err(err).Str("直播回放ID", p.RecordID).Msg("加载回放信息出错")
+		return errors.New("加载回放信息出错")
+	} else {
+		p.Info = recordInfo
+	}
+
+	if liverInfo, err := client.fetchLiverInfo(ctx, p.Info.RoomID); err != nil {
+		logger.Error().Err(err).Str("直播回放ID", p.RecordID).Msg("加载直播间信息出错")
+		return errors.New("加载直播间信息出错")
+	} else {
+		p.Liver = liverInfo
+	}
+
+	if parts, err := client.fetchRecordParts(ctx, p.RecordID, 0); err != nil {
+		logger.Error().Err(err).Str("直播回放ID", p.RecordID).Msg("加载回放分段信息出错")
+		return errors.New("加载回放分段信息出错")
+	} else {
+		p.Parts = parts
+	}
+
+	if p.Quality != "" {
+		selectQuality(ctx, client, p)
+	}
+	return nil
+}
+
+// applyClip keeps only range [`from`, `to`) of the record loaded into `p`, parts overlapping with it are selected for downloading.
+// Zero `from` means start of the record, zero `to` means end of the record.
+func applyClip(p *DownloadParam, from, to time.Time) error {
+	ranges := partTimeRanges(p.Info, p.Parts)
+	if len(ranges) == 0 {
+		return errors.New("直播回放没有任何分段")
+	}
+	if from.IsZero() {
+		from = ranges[0].Start.Time
+	}
+	if to.IsZero() {
+		to = ranges[len(ranges)-1].End.Time
+	}
+
+	clip, selection, err := newClipRange(p.Info, p.Parts, from, to)
+	if err != nil {
+		return err
+	}
+	p.Clip = clip
+	p.DownloadList = selection
+	logger.Info().Str("开始于", helper.JSONTime{Time: clip.From}.String()).Str("结束于", helper.JSONTime{Time: clip.To}.String()).Ints("涉及的分段", selection).Msg("截取直播回放片段")
+	return nil
+}
+
+// selectQuality switches parts list in `p` to the requested quality (`p.Quality`).
+// If the requested quality is not available, default quality is used with a warning.
+func selectQuality(ctx context.Context, client *BiliClient, p *DownloadParam) {
+	quality, err := p.Parts.ResolveQuality(p.Quality)
+	if err != nil {
+		logger.Warn().Err(err).Str("直播回放ID", p.RecordID).Str("请求的画质", p.Quality).Str("使用的画质", p.Parts.Quality()).Msg("请求的画质不可用，使用默认画质")
+		return
+	}
+	if quality.Number == p.Parts.CurrentQualityNumber {
+		logger.Info().Str("直播回放ID", p.RecordID).Str("画质", quality.Name).Send()
+		return
+	}
+
+	parts, err := client.fetchRecordParts(ctx, p.RecordID, quality.Number)
+	if err != nil {
+		logger.Warn().Err(err).Str("直播回放ID", p.RecordID).Str("请求的画质", quality.Name).Str("使用的画质", p.Parts.Quality()).Msg("加载指定画质的分段信息出错，使用默认画质")
+		return
+	}
+	if parts.CurrentQualityNumber != quality.Number {
+		logger.Warn().Str("直播回放ID", p.RecordID).Str("请求的画质", quality.Name).Str("使用的画质", parts.Quality()).Msg("服务器没有提供请求的画质")
+	} else {
+		logger.Info().Str("直播回放ID", p.RecordID).Str("画质", quality.Name).Send()
+	}
+	p.Parts = parts
+}
+
+// rateLimitFromFlags reads download speed limitation from `--limit` option (in MiB/s).
+func rateLimitFromFlags(c *cli.Context) datasize.ByteSize {
+	speedLimit := int64(c.Float64("limit") * float64(datasize.MB))
+	if speedLimit <= 0 {
+		logger.Info().Msg("下载不限速")
+		return 0
+	}
+
+	rateLimit := datasize.ByteSize(speedLimit)
+	logger.Info().Str("限速值", rateLimit.HumanReadable()).Uint64("每秒字节数", rateLimit.Bytes()).Msg("下载限速")
+	return rateLimit
+}
+
+// namingFromFlags reads output directory and naming templates from `--output-dir`, `--dir-template` and `--name-template` options.
+func namingFromFlags(c *cli.Context) (outputDir, dirTemplate, nameTemplate string, err error) {
+	outputDir, dirTemplate, nameTemplate = c.String("output-dir"), c.String("dir-template"), c.String("name-template")
+	if err = validateTemplates(dirTemplate, nameTemplate); err != nil {
+		return
+	}
+	if outputDir != "" {
+		if outputDir, err = filepath.Abs(outputDir); err != nil {
+			return
+		}
+	}
+	logger.Debug().Str("输出目录", outputDir).Str("目录模板", dirTemplate).Str("文件名模板", nameTemplate).Send()
+	return
+}
+
+// apiClientFromFlags creates the API client of current command, with `cookies` and settings from
+// `--proxy`, `--api-proxy`, `--retries` and `--retry-backoff` options. Commands without retry options use the default policy.
+// The HTTP client of media downloads is set up from `--proxy` and `--cdn-proxy` options as well.
+func apiClientFromFlags(c *cli.Context, cookies helper.Cookies) (*BiliClient, error) {
+	apiProxy, cdnProxy := c.String("proxy"), c.String("proxy")
+	if c.String("api-proxy") != "" {
+		apiProxy = c.String("api-proxy")
+	}
+	if c.String("cdn-proxy") != "" {
+		cdnProxy = c.String("cdn-proxy")
+	}
+
+	client := NewBiliClient()
+	client.Cookies = cookies
 	var err error
-	interactive := c.Bool("interactive")
+	if client.HTTPClient, err = helper.NewHTTPClient(apiProxy); err != nil {
+		return nil, err
+	}
+	if cdnClient, err = helper.NewHTTPClient(cdnProxy); err != nil {
+		return nil, err
+	}
+	logger.Debug().Str("API代理", helper.RedactProxy(apiProxy)).Str("下载代理", helper.RedactProxy(cdnProxy)).Msg("代理设置")
 
-	var param DownloadParam
+	if definesFlag(c, "retries") {
+		client.Retry = retryPolicyFromFlags(c)
+	}
+	logger.Debug().Uint("重试次数", client.Retry.Retries).Dur("重试等待时间", client.Retry.Backoff).Msg("重试策略")
+	return client, nil
+}
 
-	if strings.TrimSpace(c.String("record")) == "" && interactive {
-		var recordLink string
-		if recordLink, err = ask("请输入您要下载的B站直播回放链接地址: "); err != nil {
-			return cli.Exit(err, returnCodeError)
+// definesFlag tells whether the command of `c` defines the flag named `name`.
+func definesFlag(c *cli.Context, name string) bool {
+	if c.Command == nil {
+		return false
+	}
+	for _, flag := range c.Command.Flags {
+		if helper.ContainsString(flag.Names(), name) {
+			return true
 		}
+	}
+	return false
+}
 
-		c.Set("record", recordLink)
+// retryPolicyFromFlags creates retry policy from `--retries` and `--retry-backoff` options.
+func retryPolicyFromFlags(c *cli.Context) helper.RetryPolicy {
+	return helper.RetryPolicy{Retries: c.Uint("retries"), Backoff: c.Duration("retry-backoff")}
+}
+
+// remuxerFromFlags validates `--remuxer` option, ffmpeg must be available to be chosen.
+func remuxerFromFlags(c *cli.Context) (string, error) {
+	remuxer := c.String("remuxer")
+	switch remuxer {
+	case remuxerNative:
+	case remuxerFfmpeg:
+		if !ffmpeg.Available() {
+			return "", errors.New("没有找到ffmpeg工具，无法使用ffmpeg解包")
+		}
+	default:
+		return "", fmt.Errorf("不支持的解包方式%s", remuxer)
 	}
+	logger.Debug().Str("解包方式", remuxer).Msg("解包设置")
+	return remuxer, nil
+}
+
+// containerFromFlags validates `--container` option, which is the container format of merged media.
+func containerFromFlags(c *cli.Context) (string, error) {
+	container := c.String("container")
+	if err := ffmpeg.CheckContainer(container); err != nil {
+		return "", err
+	}
+	logger.Debug().Str("容器格式", container).Msg("合并设置")
+	return container, nil
+}
 
-	// Extract parameters from cli context
-	if recordID, err := extractRecordID(c.String("record")); err != nil {
+// selectParts parses user selection of parts, see `helper.ParsePartSelection` for the syntax.
+// `total` is the number of parts the record has.
+func selectParts(selected string, total int) ([]int, error) {
+	selection, err := helper.ParsePartSelection(selected, total)
+	if err != nil {
+		logger.Error().Err(err).Str("输入的选择", selected).Msg("选择要下载的分段出错")
+		return nil, err
+	}
+	return selection, nil
+}
+
+// handleDownloadAction handles `download` subcommand. The only error it might return is cli.Exit.
+func handleDownloadAction(c *cli.Context, client *BiliClient) error {
+	var err error
+	interactive := c.Bool("interactive")
+
+	var param DownloadParam
+	var batchRecordIDs []string
+	param.Retry = retryPolicyFromFlags(c)
+	param.Quality = c.String("quality")
+	if param.Remuxer, err = remuxerFromFlags(c); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if param.Container, err = containerFromFlags(c); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if param.OutputDir, param.DirTemplate, param.NameTemplate, err = namingFromFlags(c); err != nil {
 		return cli.Exit(err.Error(), returnCodeError)
+	}
+
+	if listFile := strings.TrimSpace(c.String("from-file")); listFile != "" {
+		if batchRecordIDs, err = readRecordList(listFile); err != nil {
+			logger.Error().Err(err).Str("列表文件", listFile).Msg("读取直播回放列表出错")
+			return cli.Exit("读取直播回放列表出错", returnCodeError)
+		}
+		if len(batchRecordIDs) == 0 {
+			return cli.Exit("列表文件中没有直播回放", returnCodeError)
+		}
+		logger.Info().Strs("直播回放ID", batchRecordIDs).Msg("批量下载")
 	} else {
-		param.RecordID = recordID
-		logger.Info().Str("直播回放ID", param.RecordID).Send()
+		if strings.TrimSpace(c.String("record")) == "" && interactive {
+			var recordLink string
+			if recordLink, err = ask("请输入您要下载的B站直播回放链接地址: "); err != nil {
+				return cli.Exit(err, returnCodeError)
+			}
+
+			c.Set("record", recordLink)
+		}
+
+		// Extract parameters from cli context
+		if recordID, err := extractRecordID(c.String("record")); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		} else {
+			param.RecordID = recordID
+			logger.Info().Str("直播回放ID", param.RecordID).Send()
+		}
 	}
 
 	// Ask user about concurrency
@@ -91,93 +350,67 @@ func handleDownloadAction(c *cli.Context) error {
 	}
 	param.Concurrency = concurrency
 
-	if recordInfo, err := fetchRecordInfo(param.RecordID); err != nil {
-		logger.Error().Err(err).Msg("加载回放信息出错")
-		return cli.Exit("加载回放信息出错", returnCodeError)
-	} else {
-		param.Info = recordInfo
-	}
-
-	if liverInfo, err := fetchLiverInfo(param.Info.RoomID); err != nil {
-		logger.Error().Err(err).Msg("加载直播间信息出错")
-		return cli.Exit("加载直播间信息出错", returnCodeError)
-	} else {
-		param.Liver = liverInfo
-	}
+	if batchRecordIDs == nil {
+		if err := loadRecordMeta(c.Context, client, &param); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
 
-	if parts, err := fetchRecordParts(param.RecordID); err != nil {
-		logger.Error().Err(err).Msg("加载回放分段信息出错")
-		return cli.Exit("加载回放分段信息出错", returnCodeError)
-	} else {
-		param.Parts = parts
-	}
-
-	// Interactive mode, ask again, for part selection.
-	if interactive {
-		var selectionMessenger strings.Builder
-		selectionMessenger.WriteString(fmt.Sprintf(
-			"%s(UID:%d)《%s》直播时间%s ~ %s，时长%v，画质：%s，总大小%s（共%d部分）\n",
-			param.Liver.UserName,
-			param.Liver.UserID,
-			param.Info.Title,
-			param.Info.Start, param.Info.End, param.Parts.Length,
-			param.Parts.Quality(),
-			param.Parts.Size, len(param.Parts.List),
-		))
-		partStart := param.Info.Start
-		for i, v := range param.Parts.List {
-			// Parse part start from filename
-			fields := strings.SplitN(strings.SplitN(v.FileName(), ".", 2)[0], "-", 2)
-			fileStartTimeStr := fields[len(fields)-1]
-			start, err := time.ParseInLocation("2006-01-02-15-04-05", fileStartTimeStr, timezone)
-			if err == nil {
-				partStart = helper.JSONTime{Time: start}
+		var clipFrom, clipTo time.Time
+		if c.String("from") != "" {
+			if clipFrom, err = parseClipPoint(c.String("from"), param.Info.Start.Time); err != nil {
+				return cli.Exit(err.Error(), returnCodeError)
 			}
-			partEnd := helper.JSONTime{Time: partStart.Add(v.Length.Duration)}
-			selectionMessenger.WriteString(fmt.Sprintf("%d\t%s\t长度%s\t大小%s\t%s ~ %s\n", i+1, v.FileName(), v.Length, v.Size, partStart, partEnd))
-			partStart = partEnd
 		}
-		selectionMessenger.WriteString("要下载哪些分段？请输入分段的序号，用英文逗号分隔（输入all来下载所有分段并合并成单个视频）: ")
-
-		var userSelection string
-		if userSelection, err = ask(selectionMessenger.String()); err != nil {
-			return cli.Exit(err, returnCodeError)
+		if c.String("to") != "" {
+			if clipTo, err = parseClipPoint(c.String("to"), param.Info.Start.Time); err != nil {
+				return cli.Exit(err.Error(), returnCodeError)
+			}
 		}
-		c.Set("select", userSelection)
-	}
-
-	{
-		selected := strings.ToLower(c.String("select"))
-		if selected == "" {
-			selected = "all"
+		clipping := !clipFrom.IsZero() || !clipTo.IsZero()
+		if clipping && c.IsSet("select") {
+			return cli.Exit("不能同时指定要下载的分段和截取的时间范围", returnCodeError)
 		}
 
-		selection := make([]int, 0)
-		if selected == "all" {
-			for i, _ := range param.Parts.List {
-				selection = append(selection, i+1)
+		// Interactive mode, ask again, for part selection.
+		if interactive && !clipping {
+			var selectionMessenger strings.Builder
+			selectionMessenger.WriteString(fmt.Sprintf(
+				"%s(UID:%d)《%s》直播时间%s ~ %s，时长%v，画质：%s，总大小%s（共%d部分）\n",
+				param.Liver.UserName,
+				param.Liver.UserID,
+				param.Info.Title,
+				param.Info.Start, param.Info.End, param.Parts.Length,
+				param.Parts.Quality(),
+				param.Parts.Size, len(param.Parts.List),
+			))
+			ranges := partTimeRanges(param.Info, param.Parts)
+			for i, v := range param.Parts.List {
+				selectionMessenger.WriteString(fmt.Sprintf("%d\t%s\t长度%s\t大小%s\t%s ~ %s\n", i+1, v.FileName(), v.Length, v.Size, ranges[i].Start, ranges[i].End))
 			}
-		} else {
-			for _, v := range strings.Split(selected, ",") {
-				n := strings.TrimSpace(v)
-				number, err := strconv.ParseInt(n, 10, 32)
-				if err != nil {
-					continue
-				}
+			selectionMessenger.WriteString("要下载哪些分段？请输入分段的序号，用英文逗号分隔，支持1-5、10-、!3、first3、last等写法（输入all来下载所有分段并合并成单个视频）: ")
 
-				selection = append(selection, int(number))
+			var userSelection string
+			if userSelection, err = ask(selectionMessenger.String()); err != nil {
+				return cli.Exit(err, returnCodeError)
 			}
+			c.Set("select", userSelection)
 		}
 
-		if len(selection) == 0 {
-			logger.Error().Str("输入的选择", selected).Msg("没有选择要下载的分段")
-			return cli.Exit("没有选择要下载的分段", returnCodeError)
+		if clipping {
+			if err := applyClip(&param, clipFrom, clipTo); err != nil {
+				return cli.Exit(err.Error(), returnCodeError)
+			}
+		} else if param.DownloadList, err = selectParts(c.String("select"), len(param.Parts.List)); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
 		}
-		param.DownloadList = selection
 		logger.Info().Ints("选择的分段", param.DownloadList).Send()
+	} else if c.String("from") != "" || c.String("to") != "" {
+		return cli.Exit("批量下载不支持截取时间范围", returnCodeError)
 	}
 	{
 		param.NoMerge = c.Bool("no-merge")
+		param.Merge = c.Bool("merge")
+		param.FixTimestamps = c.Bool("fix-timestamps")
 		logger.Info().Bool("将合并为完整视频", !param.NoMerge).Send()
 	}
 
@@ -188,26 +421,191 @@ func handleDownloadAction(c *cli.Context) error {
 		}
 		c.Set("limit", userInput)
 	}
-	{
-		limit := c.Float64("limit")
-		speedLimit := int64(limit * float64(datasize.MB))
-		if speedLimit <= 0 {
-			logger.Info().Msg("下载不限速")
-		} else {
-			param.RateLimit = datasize.ByteSize(speedLimit)
-			logger.Info().Str("限速值", param.RateLimit.HumanReadable()).Uint64("每秒字节数", param.RateLimit.Bytes()).Msg("下载限速")
-		}
+	param.RateLimit = rateLimitFromFlags(c)
+
+	initProgressBar()
+
+	if batchRecordIDs != nil {
+		return batchDownload(c.Context, client, batchRecordIDs, param, c.String("select"))
 	}
 
-	// Setup progress bar manager only if we're connected to a TTY
+	return singleDownload(c.Context, param)
+}
+
+// initProgressBar sets up progress bar manager, it only renders if we're connected to a TTY.
+func initProgressBar() {
 	var progressWriter io.Writer = os.Stdout
 	if !helper.IsTTY() {
 		progressWriter = ioutil.Discard
 		logger.Debug().Msg("不在终端中运行，将不显示进度条")
 	}
 	progressbar.Init(progressWriter)
+}
 
-	return cliDownload(param)
+// singleDownload downloads a single record described by `param`. The only error it might return is cli.Exit.
+func singleDownload(ctx context.Context, param DownloadParam) error {
+	if int(param.Concurrency) > len(param.DownloadList) {
+		param.Concurrency = uint(len(param.DownloadList))
+		logger.Info().Uint("下载并发数", param.Concurrency).Msg("自动调整下载并发数")
+	}
+	pool := newDownloadPool(param.Concurrency, param.RateLimit, param.Retry, param.Remuxer)
+	progressbar.Start()
+	err := cliDownload(ctx, pool, param)
+	pool.close()
+	progressbar.Stop()
+
+	if ctx.Err() != nil {
+		return cli.Exit("下载已中断", returnCodeInterrupted)
+	}
+	if err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	return nil
+}
+
+// handleResumeAction handles `resume` subcommand. It continues the download job recorded in job manifest of given record directory.
+// The only error it might return is cli.Exit.
+func handleResumeAction(c *cli.Context, client *BiliClient) error {
+	recordDir, err := filepath.Abs(c.String("dir"))
+	if err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+
+	manifest, err := models.LoadJobManifest(recordDir)
+	if err != nil {
+		logger.Error().Err(err).Str("下载目录", recordDir).Msg("读取下载任务状态出错")
+		return cli.Exit("读取下载任务状态出错", returnCodeError)
+	}
+	if manifest.Finished {
+		logger.Info().Str("下载目录", recordDir).Msg("下载任务已完成，无需继续")
+		return nil
+	}
+
+	param := DownloadParam{
+		RecordID:      manifest.RecordID,
+		DownloadList:  manifest.DownloadList,
+		Concurrency:   manifest.Concurrency,
+		NoMerge:       manifest.NoMerge,
+		Merge:         manifest.Merge,
+		FixTimestamps: manifest.FixTimestamps,
+		Container:     manifest.Container,
+		RateLimit:     manifest.RateLimit,
+		Quality:       manifest.Quality,
+		NameTemplate:  manifest.NameTemplate,
+		Directory:     recordDir,
+		Retry:         retryPolicyFromFlags(c),
+	}
+	if param.Remuxer, err = remuxerFromFlags(c); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if param.Container == "" {
+		// Manifests saved before `--container` was introduced
+		param.Container = ffmpeg.ContainerMP4
+	}
+	if c.IsSet("concurrency") {
+		param.Concurrency = c.Uint("concurrency")
+	}
+	if param.Concurrency == 0 {
+		param.Concurrency = defaultConcurrency
+	}
+	if c.IsSet("limit") {
+		param.RateLimit = rateLimitFromFlags(c)
+	}
+	if c.IsSet("quality") {
+		param.Quality = c.String("quality")
+	}
+	logger.Info().Str("直播回放ID", param.RecordID).Ints("选择的分段", param.DownloadList).Uint("下载并发数", param.Concurrency).Msg("继续下载任务")
+
+	// Part URLs expire after a while, always fetch them again.
+	if err := loadRecordMeta(c.Context, client, &param); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if manifest.ClipFrom != nil && manifest.ClipTo != nil {
+		if err := applyClip(&param, *manifest.ClipFrom, *manifest.ClipTo); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
+	} else if err := helper.CheckPartList(param.DownloadList, len(param.Parts.List)); err != nil {
+		// The manifest may be edited by hand, or the record may have changed since.
+		logger.Error().Err(err).Str("下载目录", recordDir).Msg("下载任务中选择的分段无效")
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+
+	initProgressBar()
+	return singleDownload(c.Context, param)
+}
+
+// batchDownload downloads multiple records (`recordIDs`) with a shared worker pool.
+// Settings other than record ID in `template` are applied to all records, as well as part selection (`selected`).
+// A summary is printed after all records are processed, and cli.Exit is returned if any of them failed.
+func batchDownload(ctx context.Context, client *BiliClient, recordIDs []string, template DownloadParam, selected string) error {
+	failures := make(map[string]error)
+	var params []DownloadParam
+
+	var totalParts int
+	for _, recordID := range recordIDs {
+		param := template
+		param.RecordID = recordID
+		if err := loadRecordMeta(ctx, client, &param); err != nil {
+			failures[recordID] = err
+			continue
+		}
+
+		selection, err := selectParts(selected, len(param.Parts.List))
+		if err != nil {
+			failures[recordID] = err
+			continue
+		}
+		param.DownloadList = selection
+		totalParts += len(selection)
+		logger.Info().Str("直播回放ID", recordID).Str("标题", param.Info.Title).Ints("选择的分段", param.DownloadList).Send()
+		params = append(params, param)
+	}
+
+	if totalParts > 0 {
+		concurrency := template.Concurrency
+		if int(concurrency) > totalParts {
+			concurrency = uint(totalParts)
+			logger.Info().Uint("下载并发数", concurrency).Msg("自动调整下载并发数")
+		}
+
+		pool := newDownloadPool(concurrency, template.RateLimit, template.Retry, template.Remuxer)
+		progressbar.Start()
+
+		var wg sync.WaitGroup
+		var failureGuard sync.Mutex
+		for _, p := range params {
+			wg.Add(1)
+			go func(param DownloadParam) {
+				defer wg.Done()
+				if err := cliDownload(ctx, pool, param); err != nil {
+					failureGuard.Lock()
+					defer failureGuard.Unlock()
+					failures[param.RecordID] = err
+				}
+			}(p)
+		}
+		wg.Wait()
+
+		pool.close()
+		progressbar.Stop()
+	}
+
+	for _, recordID := range recordIDs {
+		if err, failed := failures[recordID]; failed {
+			logger.Error().Err(err).Str("直播回放ID", recordID).Msg("下载失败")
+		} else {
+			logger.Info().Str("直播回放ID", recordID).Msg("下载成功")
+		}
+	}
+	logger.Info().Int("成功", len(recordIDs)-len(failures)).Int("失败", len(failures)).Msg("批量下载完毕")
+
+	if ctx.Err() != nil {
+		return cli.Exit("批量下载已中断", returnCodeInterrupted)
+	}
+	if len(failures) > 0 {
+		return cli.Exit(fmt.Sprintf("%d个直播回放下载失败", len(failures)), returnCodeError)
+	}
+	return nil
 }
 
 // ask asks a question (prints given `msg`), and read user's answer via `os.Stdin`.
@@ -223,15 +621,47 @@ func ask(msg string) (string, error) {
 	return strings.TrimSpace(string(line)), nil
 }
 
-// wrapAction wraps given action. It takes care of `--debug` option, to setup proper logging level.
-func wrapAction(actionFunc cli.ActionFunc) cli.ActionFunc {
+// clientActionFunc is an action talking to bilibili API with `client`.
+type clientActionFunc func(c *cli.Context, client *BiliClient) error
+
+// wrapAction wraps given action. It takes care of `--debug` option, to setup proper logging level,
+// and creates the API client the action talks to bilibili API with.
+func wrapAction(actionFunc clientActionFunc) cli.ActionFunc {
 	return func(c *cli.Context) error {
-		if c.Bool("debug") {
+		// --debug on the command line takes effect before loading config, so that config loading can be debugged
+		debug := c.Bool("debug")
+		if debug {
 			logger = logger.Level(zerolog.DebugLevel)
+		}
+		if err := applyConfig(c); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
+		cookies, err := helper.ParseCookies(c.String("cookies"))
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("无法读取Cookies: %v", err), returnCodeError)
+		}
+		requestCookies = cookies
+		client, err := apiClientFromFlags(c, cookies)
+		if err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
+		if c.Bool("debug") {
+			if !debug {
+				logger = logger.Level(zerolog.DebugLevel)
+			}
 			logger.Debug().Msg("开启DEBUG级别日志，进度条可能被打乱")
 		}
-		return actionFunc(c)
+		return actionFunc(c, client)
+	}
+}
+
+// joinFlags concatenates lists of flags into a new list, so shared lists are never modified by appending.
+func joinFlags(lists ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, list := range lists {
+		flags = append(flags, list...)
 	}
+	return flags
 }
 
 // newCliApp creates a cli.App instance to handle commandline execution.
@@ -240,12 +670,48 @@ func newCliApp() *cli.App {
 		_, _ = fmt.Fprintf(c.App.Writer, "%v 版本 %v, 编译时间 %v\n", c.App.Name, c.App.Version, c.App.Compiled)
 	}
 
+	// Flags shared by multiple commands are defined once, so their usage never differs between commands.
+
+	// Flags for requesting the API.
+	apiFlags := []cli.Flag{
+		&cli.StringFlag{Name: "cookies", Usage: "登录后的`Cookies`，可以是浏览器导出的Netscape格式cookies.txt文件路径，或者SESSDATA的值。"},
+		&cli.StringFlag{Name: "proxy", Usage: "`代理`地址，支持http://、https://和socks5://，direct表示不使用代理。不指定则使用HTTP_PROXY等环境变量。"},
+		&cli.StringFlag{Name: "api-proxy", Usage: "请求API使用的`代理`地址，覆盖--proxy。"},
+	}
+	// Flags for requesting the API and downloading from CDN.
+	networkFlags := joinFlags(apiFlags, []cli.Flag{
+		&cli.StringFlag{Name: "cdn-proxy", Usage: "下载视频使用的`代理`地址，覆盖--proxy。"},
+	})
+	// Flags for transferring and de-capping parts.
+	transferFlags := []cli.Flag{
+		&cli.UintFlag{Name: "retries", Usage: "下载或请求API出错时的`重试次数`，0表示不重试。", Value: defaultRetries},
+		&cli.DurationFlag{Name: "retry-backoff", Usage: "首次重试前的`等待时间`，之后每次重试等待时间翻倍。", Value: defaultRetryBackoff},
+		&cli.StringFlag{Name: "remuxer", Usage: "把FLV`解包`为TS的方式，可选native（内置）或ffmpeg。内置方式不支持的编码会自动改用ffmpeg。", Value: remuxerNative},
+	}
+	// Flags for downloading new records.
+	recordFlags := []cli.Flag{
+		&cli.UintFlag{Name: "concurrency", Usage: "设定`并发数`（可以同时下载几个分段），默认为2。如果您的网络较好，可适当调高。"},
+		&cli.BoolFlag{Name: "no-merge", Usage: "不合并各个视频分段，仅生成m3u8播放列表。如果不指定此选项，并下载所有分段（或使用--merge选择连续的分段），则会合并为单个视频文件。", Value: false},
+		&cli.BoolFlag{Name: "merge", Usage: "选择了连续的多个分段（如--select 3-5）时也合并为单个视频文件。默认只有下载所有分段时才会合并。", Value: false},
+		&cli.BoolFlag{Name: "fix-timestamps", Usage: "合并时重新生成缺失的时间戳，并使视频从0开始。可以修复部分分段时间戳错乱导致的合并问题。", Value: false},
+		&cli.StringFlag{Name: "container", Usage: "合并后视频的`容器格式`，可选mp4、fmp4（分片MP4，合并中断也可以播放）、mkv、ts或flv。", Value: ffmpeg.ContainerMP4},
+		&cli.StringFlag{Name: "output-dir", Usage: "`输出目录`，默认为当前目录。"},
+		&cli.StringFlag{Name: "dir-template", Usage: "每个直播回放的`目录模板`（相对于输出目录），可以用/分隔多级目录。可用的占位符有{uid}、{uname}、{room}、{title}、{rid}、{start}、{end}和{quality}，时间可以指定格式，如{start:2006-01-02}。", Value: defaultDirTemplate},
+		&cli.StringFlag{Name: "name-template", Usage: "合并后视频的`文件名模板`（不含扩展名），占位符同--dir-template，另有{part}表示合并的分段（complete、parts-3-5或clip-...）。", Value: defaultNameTemplate},
+		&cli.StringFlag{Name: "quality", Usage: "`画质`名称或编号(qn)，也可以是best（最高画质）或worst（最低画质）。不指定则使用默认画质。"},
+		&cli.Float64Flag{Name: "limit", Usage: "`下载限速值`，单位为MiB/s。例如1表示限速1MiB/s，0表示不限速。"},
+	}
+
 	return &cli.App{
 		Version:  version.VersionString,
 		Compiled: version.CompiledTime,
 		Name:     "bililive-downloader",
 		Usage:    "Download livestream recordings from Bilibili",
-		Flags:    []cli.Flag{&cli.BoolFlag{Name: "debug", Usage: "开启DEBUG级别日志", Value: false}},
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "debug", Usage: "开启DEBUG级别日志", Value: false},
+			&cli.StringFlag{Name: "config", Usage: "`配置文件`路径，默认为$XDG_CONFIG_HOME/bililive-downloader/config.yaml。命令行参数优先于配置文件。", EnvVars: []string{"BILILIVE_DOWNLOADER_CONFIG"}},
+			&cli.StringFlag{Name: "profile", Usage: "使用配置文件中的哪个`配置`，默认为配置文件中default_profile指定的配置。", EnvVars: []string{"BILILIVE_DOWNLOADER_PROFILE"}},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:    "version",
@@ -261,15 +727,71 @@ func newCliApp() *cli.App {
 				Aliases: []string{"d"},
 				Usage:   "下载直播回放",
 				Action:  wrapAction(handleDownloadAction),
-				Flags: []cli.Flag{
+				Flags: joinFlags([]cli.Flag{
 					&cli.BoolFlag{Name: "interactive", Aliases: []string{"i"}, Usage: "交互式询问各个未传递的参数。", Value: false},
-					&cli.UintFlag{Name: "concurrency", Usage: "设定`并发数`（可以同时下载几个分段）。如果您的网络较好，可适当调高。"},
-					&cli.StringFlag{Name: "select", Usage: "指定要下载的`分段编号`，以逗号分隔。"},
-					&cli.BoolFlag{Name: "no-merge", Usage: "不合并各个视频分段。如果不指定此选项，并下载所有分段，则会合并为单个视频文件。", Value: false},
+					&cli.StringFlag{Name: "select", Usage: "指定要下载的`分段编号`，以逗号分隔。支持范围（1-5、10-）、排除（!3）以及first3、last等写法。"},
+					&cli.StringFlag{Name: "from", Usage: "截取片段的`开始时间`，可以是相对直播开始的偏移（如01:23:00），或者时刻（如2021-03-01 12:34:56）。"},
+					&cli.StringFlag{Name: "to", Usage: "截取片段的`结束时间`，格式同--from。只下载与截取范围重叠的分段，并截取为单个视频。"},
 					&cli.StringFlag{Name: "record", Usage: "直播回放的`链接或ID`。"},
-					&cli.Float64Flag{Name: "limit", Usage: "`下载限速值`，单位为MiB/s。例如1表示限速1MiB/s，0表示不限速。"},
+					&cli.StringFlag{Name: "from-file", Usage: "从`列表文件`批量下载直播回放，每行一个链接或ID，以#开头的行为注释。-表示从标准输入读取。"},
+				}, recordFlags, transferFlags, networkFlags),
+			},
+			{
+				Name:   "info",
+				Usage:  "显示直播回放的详细信息",
+				Action: wrapAction(handleInfoAction),
+				Flags: joinFlags([]cli.Flag{
+					&cli.StringFlag{Name: "record", Usage: "直播回放的`链接或ID`。", Required: true},
+					&cli.StringFlag{Name: "format", Usage: "输出`格式`，可选table、json或yaml。", Value: "table"},
+				}, apiFlags),
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"l"},
+				Usage:   "列出直播间的直播回放",
+				Action:  wrapAction(handleListAction),
+				Flags: joinFlags([]cli.Flag{
+					&cli.Int64Flag{Name: "room", Usage: "`直播间ID`。"},
+					&cli.Int64Flag{Name: "uid", Usage: "主播`UID`，未指定直播间ID时使用。"},
+					&cli.StringFlag{Name: "format", Usage: "输出`格式`，可选table、json或ids（仅输出回放ID，每行一个）。", Value: "table"},
+					&cli.IntFlag{Name: "max", Usage: "最多列出的`回放数量`，0表示不限制。"},
+				}, apiFlags),
+			},
+			{
+				Name:    "watch",
+				Aliases: []string{"w"},
+				Usage:   "监视直播间，自动下载新的直播回放",
+				Action:  wrapAction(handleWatchAction),
+				Flags: joinFlags([]cli.Flag{
+					&cli.Int64Flag{Name: "room", Usage: "要监视的`直播间ID`。", Required: true},
+					&cli.DurationFlag{Name: "interval", Usage: "检查新回放的`时间间隔`。", Value: defaultWatchInterval},
+					&cli.StringFlag{Name: "state", Usage: "记录已下载回放的`状态文件`，默认为当前目录下的watch-<直播间ID>.json。"},
+					&cli.BoolFlag{Name: "skip-existing", Usage: "首次监视时跳过直播间已有的回放，只下载之后出现的新回放。", Value: false},
+				}, recordFlags, transferFlags, networkFlags),
+			},
+			{
+				Name:   "verify",
+				Usage:  "校验已下载的直播回放分段",
+				Action: wrapAction(handleVerifyAction),
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "dir", Usage: "直播回放的`下载目录`，其中应有下载任务.json文件。", Value: "."},
+					&cli.BoolFlag{Name: "decode", Usage: "完整解码视频以发现更多问题，耗时较长。", Value: false},
+					&cli.BoolFlag{Name: "delete-corrupted", Usage: "删除校验未通过的分段，之后可以使用resume命令重新下载。", Value: false},
+					&cli.StringFlag{Name: "format", Usage: "输出`格式`，可选table或json。", Value: "table"},
 				},
 			},
+			{
+				Name:    "resume",
+				Aliases: []string{"r"},
+				Usage:   "继续未完成的下载任务",
+				Action:  wrapAction(handleResumeAction),
+				Flags: joinFlags([]cli.Flag{
+					&cli.StringFlag{Name: "dir", Usage: "直播回放的`下载目录`，其中应有下载任务.json文件。", Value: "."},
+					&cli.UintFlag{Name: "concurrency", Usage: "设定`并发数`，不指定则沿用上次的设置。"},
+					&cli.Float64Flag{Name: "limit", Usage: "`下载限速值`，单位为MiB/s，不指定则沿用上次的设置。"},
+					&cli.StringFlag{Name: "quality", Usage: "`画质`名称或编号(qn)，不指定则沿用上次的设置。"},
+				}, transferFlags, networkFlags),
+			},
 		},
 	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractRecordID(t *testing.T) {
+	testData := map[string]string{
+		"R1":                                    "R1",
+		" R1 ":                                  "R1",
+		"https://live.bilibili.com/record/R1":   "R1",
+		"https://live.bilibili.com/record/R1?a": "R1",
+		"live.bilibili.com/record/ R1 ?a=/b":    "R1",
+	}
+	for link, expected := range testData {
+		recordID, err := extractRecordID(link)
+		if assert.NoError(t, err, link) {
+			assert.Equal(t, expected, recordID, link)
+		}
+	}
+
+	for _, link := range []string{"", " ", "https://live.bilibili.com/record/", "?rid=R1"} {
+		_, err := extractRecordID(link)
+		assert.Error(t, err, link)
+	}
+}
+
+func TestReadRecordList(t *testing.T) {
+	dir := t.TempDir()
+	// writeList writes `content` into a list file, and returns its path.
+	writeList := func(content string) string {
+		filePath := filepath.Join(dir, "list.txt")
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+
+	testData := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"ids and links", "R1\r\nhttps://live.bilibili.com/record/R2?from=list\n  R3  \n", []string{"R1", "R2", "R3"}},
+		{"comments", "# 回放列表\n\nR1\n  # R2\nR3 # 第三场\nR4#5\n", []string{"R1", "R3", "R4#5"}},
+		{"duplicates", "R1\nR2\nhttps://live.bilibili.com/record/R1\nR2 # again\n", []string{"R1", "R2"}},
+	}
+	for _, row := range testData {
+		recordIDs, err := readRecordList(writeList(row.content))
+		if assert.NoError(t, err, row.name) {
+			assert.Equal(t, row.expected, recordIDs, row.name)
+		}
+	}
+
+	_, err := readRecordList(writeList("R1\nhttps://live.bilibili.com/record/\nR3\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "第2行")
+	}
+	_, err = readRecordList(filepath.Join(dir, "missing.txt"))
+	assert.Error(t, err)
+
+	// `-` reads from stdin.
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	os.Stdin, err = os.Open(writeList("R1\n# comment\nR2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdin.Close()
+	recordIDs, err := readRecordList("-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"R1", "R2"}, recordIDs)
+}
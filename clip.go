@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clipRange is a time range of the livestream to keep, instead of whole parts.
+type clipRange struct {
+	From     time.Time     // Wall clock time to start from
+	To       time.Time     // Wall clock time to end at
+	Offset   time.Duration // Start of the clip, relative to start of the first overlapping part
+	Duration time.Duration // Length of the clip in media time, gaps between parts are excluded
+}
+
+// String returns a representation of the clip suitable for filenames.
+func (r *clipRange) String() string {
+	return fmt.Sprintf("%s-%s", r.From.In(timezone).Format("150405"), r.To.In(timezone).Format("150405"))
+}
+
+// parseClipPoint parses a point of time in the livestream.
+// It's either wall clock time like `2021-03-01 12:34:56`, or offset from start of the livestream like `01:23:00`.
+func parseClipPoint(str string, recordStart time.Time) (time.Time, error) {
+	str = strings.TrimSpace(str)
+	if t, err := time.ParseInLocation(timeFormat, str, timezone); err == nil {
+		return t, nil
+	}
+
+	offset, err := helper.ParseTimeOffset(str)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return recordStart.Add(offset), nil
+}
+
+// newClipRange maps time range [`from`, `to`) onto parts of given record.
+// The range is clamped to the overlapping parts, as there's no media beyond them.
+// It returns the clip relative to the first overlapping part, and part numbers overlapping with it.
+// Offset and duration are in media time, i.e. gaps between parts are skipped, as merged parts are played back-to-back.
+func newClipRange(info *models.LiveRecordInfo, parts *models.RecordParts, from, to time.Time) (*clipRange, []int, error) {
+	if !to.After(from) {
+		return nil, nil, errors.New("截取的结束时间必须晚于开始时间")
+	}
+
+	var selection []int
+	clip := &clipRange{From: from, To: to}
+	var firstPartStart, lastPartEnd time.Time
+	for i, r := range partTimeRanges(info, parts) {
+		if !r.End.After(from) || !r.Start.Before(to) {
+			continue
+		}
+		if selection == nil {
+			firstPartStart = r.Start.Time
+		}
+		lastPartEnd = r.End.Time
+		selection = append(selection, i+1)
+
+		// Only the overlapping piece of each part ends up in the clip.
+		start, end := r.Start.Time, r.End.Time
+		if from.After(start) {
+			start = from
+		}
+		if to.Before(end) {
+			end = to
+		}
+		clip.Duration += end.Sub(start)
+	}
+	if len(selection) == 0 {
+		return nil, nil, fmt.Errorf("直播回放中没有%s ~ %s的内容", helper.JSONTime{Time: from}, helper.JSONTime{Time: to})
+	}
+
+	if from.After(firstPartStart) {
+		clip.Offset = from.Sub(firstPartStart)
+	} else {
+		// The range starts before the first part, there's nothing to trim at start.
+		clip.From = firstPartStart
+	}
+	if to.After(lastPartEnd) {
+		// The range ends after the last part, there's nothing to trim at end.
+		clip.To = lastPartEnd
+	}
+	return clip, selection, nil
+}
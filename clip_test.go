@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// testRecord returns a record starting at 12:00, with two parts of an hour each (12:00 - 14:00),
+// followed by half-hour parts named after their start times 14:30 and 16:00, leaving gaps between parts.
+func testRecord() (*models.LiveRecordInfo, *models.RecordParts) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, timezone)
+	info := &models.LiveRecordInfo{ID: "R1", Start: helper.JSONTime{Time: start}, End: helper.JSONTime{Time: start.Add(4*time.Hour + 30*time.Minute)}}
+	parts := &models.RecordParts{List: []models.RecordPart{
+		{Url: "https://cdn.example.com/1.flv", Length: helper.Duration{Duration: time.Hour}},
+		{Url: "https://cdn.example.com/2.flv", Length: helper.Duration{Duration: time.Hour}},
+		{Url: "https://cdn.example.com/R1-2021-03-01-14-30-00.flv", Length: helper.Duration{Duration: 30 * time.Minute}},
+		{Url: "https://cdn.example.com/R1-2021-03-01-16-00-00.flv", Length: helper.Duration{Duration: 30 * time.Minute}},
+	}}
+	return info, parts
+}
+
+func TestNewClipRange(t *testing.T) {
+	info, parts := testRecord()
+	at := func(hour, min int) time.Time {
+		return time.Date(2021, 3, 1, hour, min, 0, 0, timezone)
+	}
+
+	type testRow struct {
+		name      string
+		from, to  time.Time
+		expected  *clipRange
+		selection []int
+	}
+	rows := []testRow{
+		{"within a part", at(12, 10), at(12, 40), &clipRange{From: at(12, 10), To: at(12, 40), Offset: 10 * time.Minute, Duration: 30 * time.Minute}, []int{1}},
+		{"across parts", at(12, 30), at(13, 30), &clipRange{From: at(12, 30), To: at(13, 30), Offset: 30 * time.Minute, Duration: time.Hour}, []int{1, 2}},
+		{"start before the first part", at(11, 0), at(12, 30), &clipRange{From: at(12, 0), To: at(12, 30), Duration: 30 * time.Minute}, []int{1}},
+		{"end after the last part", at(16, 15), at(17, 0), &clipRange{From: at(16, 15), To: at(16, 30), Offset: 15 * time.Minute, Duration: 15 * time.Minute}, []int{4}},
+		{"beyond both ends", at(11, 0), at(17, 0), &clipRange{From: at(12, 0), To: at(16, 30), Duration: 3 * time.Hour}, []int{1, 2, 3, 4}},
+		{"no part", at(15, 0), at(16, 0), nil, nil},
+		// Part 2 ends at 14:00 while part 3 starts at 14:30, part 3 ends at 15:00 while part 4 starts at 16:00.
+		{"across a gap", at(13, 30), at(15, 0), &clipRange{From: at(13, 30), To: at(15, 0), Offset: 30 * time.Minute, Duration: time.Hour}, []int{2, 3}},
+		{"across gaps", at(14, 45), at(16, 15), &clipRange{From: at(14, 45), To: at(16, 15), Offset: 15 * time.Minute, Duration: 30 * time.Minute}, []int{3, 4}},
+		{"end in a gap", at(14, 45), at(15, 45), &clipRange{From: at(14, 45), To: at(15, 0), Offset: 15 * time.Minute, Duration: 15 * time.Minute}, []int{3}},
+		{"start in a gap", at(15, 15), at(16, 15), &clipRange{From: at(16, 0), To: at(16, 15), Offset: 0, Duration: 15 * time.Minute}, []int{4}},
+		{"end after the last part with a gap", at(14, 45), at(17, 0), &clipRange{From: at(14, 45), To: at(16, 30), Offset: 15 * time.Minute, Duration: 45 * time.Minute}, []int{3, 4}},
+		{"end before start", at(12, 40), at(12, 10), nil, nil},
+	}
+	for _, row := range rows {
+		clip, selection, err := newClipRange(info, parts, row.from, row.to)
+		if row.expected == nil {
+			assert.Error(t, err, row.name)
+			continue
+		}
+		if assert.NoError(t, err, row.name) {
+			assert.Equal(t, row.selection, selection, row.name)
+			assert.True(t, row.expected.From.Equal(clip.From), "%s: from %v", row.name, clip.From)
+			assert.True(t, row.expected.To.Equal(clip.To), "%s: to %v", row.name, clip.To)
+			assert.Equal(t, row.expected.Offset, clip.Offset, row.name)
+			assert.Equal(t, row.expected.Duration, clip.Duration, row.name)
+		}
+	}
+}
+
+func TestApplyClip(t *testing.T) {
+	info, parts := testRecord()
+
+	// Open ends default to the whole record.
+	p := DownloadParam{Info: info, Parts: parts}
+	if assert.NoError(t, applyClip(&p, time.Time{}, info.Start.Add(90*time.Minute))) {
+		assert.Equal(t, []int{1, 2}, p.DownloadList)
+		assert.Equal(t, time.Duration(0), p.Clip.Offset)
+		assert.Equal(t, 90*time.Minute, p.Clip.Duration)
+	}
+	p = DownloadParam{Info: info, Parts: parts}
+	if assert.NoError(t, applyClip(&p, info.Start.Add(90*time.Minute), time.Time{})) {
+		assert.Equal(t, []int{2, 3, 4}, p.DownloadList)
+		assert.Equal(t, 30*time.Minute, p.Clip.Offset)
+		assert.Equal(t, 90*time.Minute, p.Clip.Duration)
+	}
+
+	p = DownloadParam{Info: info, Parts: &models.RecordParts{}}
+	assert.Error(t, applyClip(&p, time.Time{}, time.Time{}))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+)
+
+// sensitiveConfigKeys are config keys whose values must not be logged, as they may contain credentials.
+var sensitiveConfigKeys = []string{"cookies", "proxy", "api-proxy", "cdn-proxy"}
+
+// configExemptFlags are flags of each command not filled from the config file,
+// since the command reuses the settings saved from the previous run instead.
+var configExemptFlags = map[string][]string{
+	"resume": {"concurrency", "limit", "quality"},
+}
+
+// applyConfig fills flags not given on the command line with values from the selected profile of the config file,
+// so that command line flags always take precedence over the config.
+// A missing config file at the default path is ignored, while an explicitly specified one must exist.
+func applyConfig(c *cli.Context) error {
+	path := c.String("config")
+	if path == "" {
+		path = models.DefaultConfigPath()
+		if path == "" {
+			return nil
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	config, err := models.LoadConfig(path)
+	if err != nil {
+		return fmt.Errorf("无法读取配置文件%s: %w", path, err)
+	}
+	profile, err := config.Profile(c.String("profile"))
+	if err != nil {
+		return err
+	}
+
+	lineage := c.Lineage()
+	for _, key := range profile.Keys() {
+		if key == "config" || key == "profile" {
+			continue
+		}
+
+		ctx, name := findConfigurableFlag(lineage, key)
+		if ctx == nil {
+			logger.Debug().Str("配置项", key).Msg("当前命令不支持此配置项，已忽略")
+			continue
+		}
+		if c.IsSet(name) {
+			continue
+		}
+
+		values, err := profile.FlagValues(key)
+		if err != nil {
+			return fmt.Errorf("配置文件%s有误: %w", path, err)
+		}
+		for _, value := range values {
+			if err := ctx.Set(name, value); err != nil {
+				return fmt.Errorf("配置项%s的值无效: %w", key, err)
+			}
+		}
+		if helper.ContainsString(sensitiveConfigKeys, name) {
+			logger.Debug().Str("配置项", name).Msg("使用配置文件中的设置")
+		} else {
+			logger.Debug().Str("配置项", name).Strs("值", values).Msg("使用配置文件中的设置")
+		}
+	}
+	return nil
+}
+
+// findConfigurableFlag finds the context defining the flag named (or aliased) `key`, searching from the current command up to the app.
+// The primary name of the flag is returned along with the context.
+func findConfigurableFlag(lineage []*cli.Context, key string) (*cli.Context, string) {
+	for _, ctx := range lineage {
+		if ctx.App == nil {
+			continue
+		}
+		flags := ctx.App.Flags
+		var exempt []string
+		if ctx.Command != nil && ctx.Command.Name != "" {
+			flags, exempt = ctx.Command.Flags, configExemptFlags[ctx.Command.Name]
+		}
+
+		for _, flag := range flags {
+			names := flag.Names()
+			if !helper.ContainsString(names, key) {
+				continue
+			}
+			if helper.ContainsString(exempt, names[0]) {
+				return nil, ""
+			}
+			return ctx, names[0]
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+default_profile: nas
+defaults:
+  retries: 5
+  debug: true
+profiles:
+  nas:
+    limit: 4.5
+    quality: best
+    concurrency: 4
+    container: mkv
+    i: true
+    format: json
+    no-such-flag: 1
+  broken:
+    retries: many
+`
+
+// runWithConfig runs the command line app with `config` as the config file, command actions only apply the config.
+// The context of the command is returned, so flag values can be checked after the config is applied.
+func runWithConfig(t *testing.T, config string, args ...string) (*cli.Context, error) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var captured *cli.Context
+	app := newCliApp()
+	for _, command := range app.Commands {
+		command.Action = func(c *cli.Context) error {
+			captured = c
+			return applyConfig(c)
+		}
+	}
+	app.Writer, app.ErrWriter = ioutil.Discard, ioutil.Discard
+	err := app.Run(append([]string{"bililive-downloader", "--config", configFile}, args...))
+	return captured, err
+}
+
+func TestApplyConfig(t *testing.T) {
+	c, err := runWithConfig(t, testConfig, "download")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(5), c.Uint("retries"))
+		assert.Equal(t, 4.5, c.Float64("limit"))
+		assert.Equal(t, "best", c.String("quality"))
+		assert.Equal(t, uint(4), c.Uint("concurrency"))
+		assert.Equal(t, "mkv", c.String("container"))
+		assert.True(t, c.Bool("debug"), "flags of the app are filled too")
+		assert.True(t, c.Bool("interactive"), "flags can be configured by aliases")
+	}
+
+	// Command line flags take precedence.
+	c, err = runWithConfig(t, testConfig, "download", "--retries", "1", "--quality", "worst", "--limit", "0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(1), c.Uint("retries"))
+		assert.Equal(t, "worst", c.String("quality"))
+		assert.Equal(t, float64(0), c.Float64("limit"))
+		assert.Equal(t, uint(4), c.Uint("concurrency"))
+	}
+
+	// Resume keeps settings of the previous run, unless given on the command line.
+	c, err = runWithConfig(t, testConfig, "resume")
+	if assert.NoError(t, err) {
+		assert.False(t, c.IsSet("concurrency"))
+		assert.False(t, c.IsSet("limit"))
+		assert.False(t, c.IsSet("quality"))
+		assert.Equal(t, uint(5), c.Uint("retries"))
+	}
+	c, err = runWithConfig(t, testConfig, "resume", "--concurrency", "8")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(8), c.Uint("concurrency"))
+		assert.False(t, c.IsSet("limit"))
+	}
+
+	// Keys apply to every command having such a flag, e.g. `format` of `info`.
+	c, err = runWithConfig(t, testConfig, "info", "--record", "R1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "json", c.String("format"))
+	}
+	// Keys unknown to the command are ignored, `resume` has no `format` flag.
+	c, err = runWithConfig(t, testConfig, "resume")
+	if assert.NoError(t, err) {
+		assert.False(t, c.IsSet("format"))
+		assert.Empty(t, c.String("format"))
+	}
+	c, err = runWithConfig(t, testConfig, "version")
+	assert.NoError(t, err)
+
+	_, err = runWithConfig(t, testConfig, "--profile", "broken", "download")
+	assert.Error(t, err)
+	_, err = runWithConfig(t, testConfig, "--profile", "missing", "download")
+	assert.Error(t, err)
+}
+
+func TestFindConfigurableFlag(t *testing.T) {
+	c, err := runWithConfig(t, "", "resume")
+	if !assert.NoError(t, err) {
+		return
+	}
+	lineage := c.Lineage()
+	type testRow struct {
+		key          string
+		expectedName string
+		fromApp      bool
+	}
+	testData := []testRow{
+		{"retries", "retries", false},
+		{"dir", "dir", false},
+		{"debug", "debug", true},
+		{"profile", "profile", true},
+		{"concurrency", "", false}, // exempt
+		{"quality", "", false},     // exempt
+		{"output-dir", "", false},  // not a flag of resume
+		{"no-such-flag", "", false},
+	}
+	for _, row := range testData {
+		ctx, name := findConfigurableFlag(lineage, row.key)
+		assert.Equal(t, row.expectedName, name, row.key)
+		if row.expectedName == "" {
+			assert.Nil(t, ctx, row.key)
+		} else if assert.NotNil(t, ctx, row.key) {
+			assert.Equal(t, row.fromApp, ctx.Command == nil || ctx.Command.Name == "", row.key)
+		}
+	}
+}
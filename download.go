@@ -2,211 +2,666 @@ package main
 
 import (
 	"bililive-downloader/ffmpeg"
+	"bililive-downloader/flv"
 	"bililive-downloader/helper"
 	"bililive-downloader/models"
 	"bililive-downloader/progressbar"
+	"bililive-downloader/remux"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/c2h5oh/datasize"
 	"github.com/cavaliercoder/grab"
 	"github.com/gosuri/uiprogress"
 	"golang.org/x/time/rate"
+	"io"
 	"io/ioutil"
 	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// mergedDurationTolerance is how much duration of the merged media may differ from the expected one.
+const mergedDurationTolerance = time.Second * 10
+
+// boundaryDesyncTolerance is how much A/V desync at boundaries of merged parts is tolerated without a warning.
+const boundaryDesyncTolerance = time.Millisecond * 200
+
+// concatListFileName is the name of the temporary list file for ffmpeg's concat demuxer, in the record directory.
+const concatListFileName = "合并列表.txt"
+
+// Remuxers de-capping FLV into TS, see `--remuxer` option.
+const (
+	remuxerNative = "native" // Built-in remuxer, see package remux
+	remuxerFfmpeg = "ffmpeg"
+)
+
 const UaKey = "User-Agent"
 const UserAgent = "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/55.0.2883.87 Safari/537.36"
 
-// downloadSinglePart downloads given part (as encoded in `task`) into given directory.
-// Downloaded file will also be de-capped to MPEGTS media, the intermediate FLV file will be deleted.
-func downloadSinglePart(task *models.PartTask) (filePath string, err error) {
-	recordPart := task.Part
+// checkExistingTsFile tells whether an existing TS media of given task can be used as result directly.
+// With state recorded by a previous run, the TS media must have been finished, and match the recorded fingerprint.
+// Without it (e.g. processed by an older version of this program), the TS media is trusted as is.
+func checkExistingTsFile(task *models.PartTask, tsFilePath string) bool {
+	prev := task.PreviousState
+	if prev == nil {
+		return true
+	}
+	if (prev.Step != models.StepDone && prev.Step != models.StepExists) || prev.Fingerprint == "" {
+		return false
+	}
 
-	rawFilePath := filepath.Join(task.DownloadDirectory, recordPart.FileName())
-	decappedTsFilePath := fmt.Sprintf("%s.ts", strings.Split(rawFilePath, ".")[0])
-	tsFileName := filepath.Base(decappedTsFilePath)
+	fingerprint, err := helper.FileFingerprint(tsFilePath)
+	if err != nil {
+		logger.Error().Err(err).Str("文件", tsFilePath).Msg("读取TS文件信息出错")
+		return false
+	}
+	return fingerprint == prev.Fingerprint
+}
 
-	bar := task.AddProgressBar(-1)
+// recordTsFingerprint records fingerprint of finished TS media into job manifest, so it can be trusted by later runs.
+func recordTsFingerprint(task *models.PartTask, tsFilePath string) {
+	if task.Manifest == nil {
+		return
+	}
 
-	// Already processed (probably selectively downloaded this part before), use existing MPEGTS media as result, no need to re-download.
-	if info, err := os.Stat(decappedTsFilePath); err == nil && info.Mode().IsRegular() {
-		logger.Debug().Str("文件", info.Name()).Msg("TS文件已存在，完全跳过处理")
-		task.SetCurrentStep("已存在")
-		task.SetFileName(tsFileName)
-		bar.SetTotal(info.Size())
-		bar.SetCurrent(info.Size())
-		return decappedTsFilePath, nil
+	fingerprint, err := helper.FileFingerprint(tsFilePath)
+	if err != nil {
+		logger.Error().Err(err).Str("文件", tsFilePath).Msg("读取TS文件信息出错")
+		return
 	}
+	task.Manifest.UpdatePart(task.PartNumber, func(state *models.PartState) {
+		state.FileName = filepath.Base(tsFilePath)
+		state.Fingerprint = fingerprint
+	})
+}
 
-	var client *grab.Client
-	var dlReq *grab.Request
-	var resp *grab.Response
-	var ticker *time.Ticker
+// stallTimeout is how long a transfer can go without any progress, before it's considered stalled.
+const stallTimeout = time.Minute
 
-	// Already downloaded, directly proceed to de-cap, skip downloading.
-	if info, err := os.Stat(rawFilePath); err == nil && info.Size() == int64(recordPart.Size.Bytes()) {
-		logger.Debug().Str("文件", rawFilePath).Msg("文件已经存在，跳过下载")
-		task.SetCurrentStep("已下载")
-		bar.SetTotal(info.Size())
-		bar.SetCurrent(info.Size())
-		goto WaitTillDecapped
+// partialCheckSize is the size of data to compare, before resuming a partial file from a different mirror.
+const partialCheckSize = 64 * 1024
+
+var errTransferStalled = errors.New("下载停滞")
+var errSizeMismatch = errors.New("下载的文件大小与预期不符")
+var errCorruptedDownload = errors.New("下载的文件已损坏")
+
+// mirrorHost returns host of given mirror URL, which identifies the mirror.
+func mirrorHost(mirror string) string {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return mirror
 	}
+	return u.Host
+}
 
-	logger.Debug().Str("文件", rawFilePath).Msg("开始下载文件")
-	bar.SetTotal(int64(task.Part.Size.Bytes()))
-	task.SetCurrentStep("下载中")
-	client = grab.NewClient()
+// partialContentMatches tells whether `mirror` serves the same content as the partially downloaded `filePath`.
+// It compares the last bytes of the partial file with the same range fetched from `mirror`.
+func partialContentMatches(ctx context.Context, mirror, filePath string) (bool, error) {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	checkSize := int64(partialCheckSize)
+	if info.Size() < checkSize {
+		checkSize = info.Size()
+	}
+	local := make([]byte, checkSize)
+	if _, err := f.ReadAt(local, info.Size()-checkSize); err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mirror, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(UaKey, UserAgent)
+	requestCookies.AddTo(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", info.Size()-checkSize, info.Size()-1))
+	resp, err := cdnClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	// Range requests not supported, the file can't be resumed from this mirror anyway.
+	if resp.StatusCode != http.StatusPartialContent {
+		return false, nil
+	}
+	remote, err := ioutil.ReadAll(io.LimitReader(resp.Body, checkSize))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(local, remote), nil
+}
+
+// transferPartFromMirrors downloads raw FLV file of given task into `rawFilePath`, trying all mirrors of the part in turn.
+// It starts with the mirror which served this part last time, and returns the last error if all mirrors fail.
+func transferPartFromMirrors(ctx context.Context, task *models.PartTask, bar *progressbar.ProgressBar, rawFilePath string) error {
+	mirrors := task.Part.Mirrors()
+
+	var prevHost string
+	if task.Manifest != nil {
+		if state := task.Manifest.Part(task.PartNumber); state != nil {
+			prevHost = state.Mirror
+		}
+	}
+	var start int
+	for i, mirror := range mirrors {
+		if mirrorHost(mirror) == prevHost {
+			start = i
+		}
+	}
+
+	var err error
+	for i := range mirrors {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		mirror := mirrors[(start+i)%len(mirrors)]
+		host := mirrorHost(mirror)
+
+		// The partial file might come from another mirror, only resume it if the content matches.
+		if host != prevHost {
+			if matches, checkErr := partialContentMatches(ctx, mirror, rawFilePath); !matches {
+				logger.Info().Err(checkErr).Int("编号", task.PartNumber).Str("线路", host).Msg("已下载的部分与此线路内容不一致，重新下载")
+				os.Remove(rawFilePath)
+			}
+		}
+		if task.Manifest != nil {
+			task.Manifest.UpdatePart(task.PartNumber, func(state *models.PartState) {
+				state.Mirror = host
+			})
+		}
+
+		logger.Debug().Int("编号", task.PartNumber).Str("线路", host).Msg("从此线路下载")
+		if err = transferPart(ctx, task, bar, rawFilePath, mirror); err == nil {
+			logger.Info().Int("编号", task.PartNumber).Str("线路", host).Msg("分段下载完成")
+			return nil
+		}
+		// Interrupted, keep the partial file so it can be resumed later.
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if errors.Is(err, errSizeMismatch) {
+			os.Remove(rawFilePath)
+		}
+		if len(mirrors) > 1 {
+			logger.Warn().Err(err).Int("编号", task.PartNumber).Str("线路", host).Msg("此线路下载出错，切换线路")
+		}
+		prevHost = host
+	}
+
+	return err
+}
+
+// transferPart downloads raw FLV file of given task from `mirror` into `rawFilePath`, progress is reported to `bar`.
+// A partially downloaded file is resumed, if the server supports it.
+// The transfer is cancelled if it stalls or `ctx` is done, and fails if the downloaded file size is not as expected.
+func transferPart(ctx context.Context, task *models.PartTask, bar *progressbar.ProgressBar, rawFilePath, mirror string) error {
+	client := grab.NewClient()
 	client.UserAgent = UserAgent
-	dlReq, err = grab.NewRequest(rawFilePath, recordPart.Url)
+	client.HTTPClient = cdnClient
+	dlReq, err := grab.NewRequest(rawFilePath, mirror)
 	if err != nil {
-		return
+		return helper.NoRetry(err)
 	}
+	requestCookies.AddTo(dlReq.HTTPRequest)
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dlReq = dlReq.WithContext(ctx)
 	dlReq.RateLimiter = task.RateLimiter
-	resp = client.Do(dlReq)
-	ticker = time.NewTicker(time.Millisecond * 120)
+	resp := client.Do(dlReq)
+	if resp.DidResume {
+		logger.Info().Str("文件", rawFilePath).Int64("已下载字节数", resp.BytesComplete()).Msg("继续下载")
+	}
+	ticker := time.NewTicker(time.Millisecond * 120)
 	defer ticker.Stop()
 
-WaitTillDownloaded:
+	var lastSaved time.Time
+	var stalled bool
+	lastProgress, lastBytesComplete := time.Now(), resp.BytesComplete()
 	for {
 		select {
 		case <-ticker.C:
 			bar.SetCurrent(resp.BytesComplete())
+			if current := resp.BytesComplete(); current != lastBytesComplete {
+				lastProgress, lastBytesComplete = time.Now(), current
+			} else if !stalled && time.Since(lastProgress) > stallTimeout {
+				logger.Warn().Int("编号", task.PartNumber).Dur("无进度时间", stallTimeout).Msg("下载停滞，取消下载")
+				stalled = true
+				cancel()
+			}
+			// Record downloaded bytes, but don't write the manifest file too often.
+			if task.Manifest != nil && time.Since(lastSaved) > time.Second*5 {
+				task.Manifest.UpdatePart(task.PartNumber, func(state *models.PartState) {
+					state.BytesComplete = resp.BytesComplete()
+				})
+				_ = task.Manifest.Save()
+				lastSaved = time.Now()
+			}
 		case <-resp.Done:
-			logger.Debug().Str("文件", rawFilePath).Msg("文件下载请求结束")
+			logger.Debug().Str("文件", rawFilePath).Err(resp.Err()).Msg("文件下载请求结束")
 			bar.SetCurrent(resp.BytesComplete())
-			break WaitTillDownloaded
+			if task.Manifest != nil {
+				task.Manifest.UpdatePart(task.PartNumber, func(state *models.PartState) {
+					state.BytesComplete = resp.BytesComplete()
+				})
+			}
+			if stalled {
+				return errTransferStalled
+			}
+			if err := resp.Err(); err != nil {
+				return err
+			}
+
+			info, err := os.Stat(rawFilePath)
+			if err != nil {
+				return err
+			}
+			if expected := int64(task.Part.Size.Bytes()); expected > 0 && info.Size() != expected {
+				return fmt.Errorf("%w：期望%d字节，实际%d字节", errSizeMismatch, expected, info.Size())
+			}
+			return nil
 		}
 	}
+}
+
+// verifyDownloadedPart verifies the downloaded raw FLV file of given task, and records the verdict into job manifest.
+// A corrupted file is deleted, so that it's downloaded again when retried.
+func verifyDownloadedPart(ctx context.Context, task *models.PartTask, rawFilePath string) error {
+	task.SetCurrentStep(models.StepVerifying)
+	verdict, err := verifyFlvFile(ctx, rawFilePath, int64(task.Part.Size.Bytes()), false)
+	if err != nil {
+		return err
+	}
+	verdict.File = task.Part.FileName()
+	if task.Manifest != nil {
+		task.Manifest.UpdatePart(task.PartNumber, func(state *models.PartState) {
+			state.Verdict = verdict
+		})
+	}
+
+	if !verdict.OK {
+		logger.Warn().Int("编号", task.PartNumber).Strs("问题", verdict.Problems).Msg("下载的文件校验未通过，将重新下载")
+		os.Remove(rawFilePath)
+		return fmt.Errorf("%w：%s", errCorruptedDownload, strings.Join(verdict.Problems, "；"))
+	}
+	logger.Debug().Int("编号", task.PartNumber).Msg("下载的文件校验通过")
+	return nil
+}
+
+// downloadSinglePart downloads given part (as encoded in `task`) into given directory.
+// Downloaded file will also be de-capped to MPEGTS media, the intermediate FLV file will be deleted.
+// Once `ctx` is done, it stops as soon as possible, keeping the partially downloaded FLV file for resuming later.
+func downloadSinglePart(ctx context.Context, task *models.PartTask) (filePath string, err error) {
+	recordPart := task.Part
+
+	// Files are written under partial names, and only renamed to the final names after being checked.
+	rawFilePath := filepath.Join(task.DownloadDirectory, recordPart.FileName())
+	partialRawFilePath := helper.PartialFilePath(rawFilePath)
+	decappedTsFilePath := strings.TrimSuffix(rawFilePath, filepath.Ext(rawFilePath)) + ".ts"
+	partialTsFilePath := helper.PartialFilePath(decappedTsFilePath)
+	tsFileName := filepath.Base(decappedTsFilePath)
+
+	bar := task.AddProgressBar(-1)
+
+	// Already processed (probably selectively downloaded this part before), use existing MPEGTS media as result, no need to re-download.
+	if info, err := os.Stat(decappedTsFilePath); err == nil && info.Mode().IsRegular() {
+		if checkExistingTsFile(task, decappedTsFilePath) {
+			logger.Debug().Str("文件", info.Name()).Msg("TS文件已存在，完全跳过处理")
+			if task.PreviousState == nil {
+				recordTsFingerprint(task, decappedTsFilePath)
+			}
+			task.SetCurrentStep(models.StepExists)
+			task.SetFileName(tsFileName)
+			bar.SetTotal(info.Size())
+			bar.SetCurrent(info.Size())
+			return decappedTsFilePath, nil
+		}
+
+		logger.Info().Str("文件", info.Name()).Msg("TS文件未处理完成，将重新处理")
+		if err := os.Remove(decappedTsFilePath); err != nil {
+			return "", err
+		}
+	}
+
+	// Already downloaded, directly proceed to de-cap, skip downloading.
+	// The file might be left by older versions which didn't verify downloads, so verify it before trusting it.
+	if info, err := os.Stat(rawFilePath); err == nil {
+		if info.Size() == int64(recordPart.Size.Bytes()) {
+			logger.Debug().Str("文件", rawFilePath).Msg("文件已经存在，校验后跳过下载")
+			bar.SetTotal(info.Size())
+			bar.SetCurrent(info.Size())
+			err := verifyDownloadedPart(ctx, task, rawFilePath)
+			if err == nil {
+				task.SetCurrentStep(models.StepDownloaded)
+				goto WaitTillDecapped
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			if !errors.Is(err, errCorruptedDownload) {
+				return "", err
+			}
+			// The corrupted file is removed by verification, download it again.
+			bar.SetCurrent(0)
+		} else {
+			// Older versions downloaded into the final file directly, continue downloading it as a partial file.
+			logger.Debug().Str("文件", rawFilePath).Msg("文件未下载完成，将继续下载")
+			if err := os.Rename(rawFilePath, partialRawFilePath); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	logger.Debug().Str("文件", rawFilePath).Msg("开始下载文件")
+	bar.SetTotal(int64(task.Part.Size.Bytes()))
+	task.SetCurrentStep(models.StepDownloading)
+	err = task.RetryPolicy.DoContext(ctx, func() error {
+		if err := transferPartFromMirrors(ctx, task, bar, partialRawFilePath); err != nil {
+			return err
+		}
+		return verifyDownloadedPart(ctx, task, partialRawFilePath)
+	}, func(retry uint, delay time.Duration, err error) {
+		logger.Warn().Err(err).Int("编号", task.PartNumber).Uint("重试次数", retry).Dur("等待时间", delay).Msg("下载出错，稍后重试")
+		task.SetRetry(retry)
+	})
+	if err != nil {
+		return
+	}
+	if err = os.Rename(partialRawFilePath, rawFilePath); err != nil {
+		return
+	}
 
 WaitTillDecapped:
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
 	// De-cap from FLV to MPEG TS media
-	// TODO Are we confident enough that all bilibili livestream records will be H.264 streams encapsulated in FLV containers?
-	logger.Debug().Str("文件", rawFilePath).Str("目标文件", tsFileName).Msg("解包为TS媒体")
-	task.SetCurrentStep("解包中")
+	codecs, err := checkPartCodecs(task, rawFilePath)
+	if err != nil {
+		logger.Error().Err(err).Str("原始文件", rawFilePath).Msg("无法解包")
+		task.SetCurrentStep(models.StepFailed)
+		return "", err
+	}
+	logger.Debug().Str("文件", rawFilePath).Str("目标文件", tsFileName).Str("解包方式", task.Remuxer).Msg("解包为TS媒体")
+	task.SetCurrentStep(models.StepDecapping)
 	task.SetFileName(tsFileName)
 	bar.SetUnitType(progressbar.UnitTypeDuration)
-	runner, _ := ffmpeg.NewRunner("-i", rawFilePath, "-c", "copy", "-bsf:v", "h264_mp4toannexb", "-f", "mpegts", decappedTsFilePath)
+	// ffmpeg refuses to overwrite existing files, remove the one left by an interrupted run.
+	os.Remove(partialTsFilePath)
+	tsDuration, err := decapPart(ctx, task, bar, codecs, rawFilePath, partialTsFilePath)
+	if err != nil {
+		// The TS file is incomplete, the FLV file is kept so de-capping can be done again later.
+		os.Remove(partialTsFilePath)
+		if ctx.Err() == nil {
+			logger.Error().Err(err).Str("原始文件", rawFilePath).Str("TS文件", tsFileName).Msg("解包出错")
+			task.SetCurrentStep(models.StepFailed)
+		}
+		return "", err
+	}
+
+	// 解包后对TS媒体进行检查，如果长度相差过大则认为解包失败，保留FLV文件以供后续人工检视
+	task.SetCurrentStep(models.StepChecking)
+	logger.Debug().Dur("期望时长", task.Part.Length.Duration).Dur("解包后时长", tsDuration).Msg("检查解包后媒体时长")
+	if math.Abs(float64(task.Part.Length.Duration-tsDuration)) >= float64(time.Second*3) {
+		logger.Error().Str("原始文件", rawFilePath).Str("TS文件", tsFileName).Msg("解包后媒体时长检查未通过")
+		os.Remove(partialTsFilePath)
+		task.SetCurrentStep(models.StepFailed)
+		return "", errors.New("解包后媒体时长检查未通过")
+	}
+
+	if err = os.Rename(partialTsFilePath, decappedTsFilePath); err != nil {
+		logger.Error().Err(err).Str("TS文件", tsFileName).Msg("重命名TS文件出错")
+		task.SetCurrentStep(models.StepFailed)
+		return "", err
+	}
+	// Record the TS media as done before deleting the FLV file, so an interruption in between never loses both.
+	recordTsFingerprint(task, decappedTsFilePath)
+	task.SetCurrentStep(models.StepDone)
+	logger.Debug().Str("将删除的文件", rawFilePath).Str("TS文件", tsFileName).Msg("检查通过")
+	os.Remove(rawFilePath)
+	return decappedTsFilePath, nil
+}
+
+// checkPartCodecs probes codecs of the downloaded FLV file with ffprobe, and tells whether they can be de-capped into TS.
+// It's skipped if ffprobe is not available (codecs are left unknown), the native remuxer checks codecs by itself anyway.
+func checkPartCodecs(task *models.PartTask, rawFilePath string) (ffmpeg.Codecs, error) {
+	if !ffmpeg.ProbeAvailable() {
+		return ffmpeg.Codecs{}, nil
+	}
+	info, err := ffmpeg.Probe(rawFilePath)
+	if err != nil {
+		// Failing to probe doesn't mean the file can't be de-capped, let the remuxer try.
+		logger.Warn().Err(err).Int("编号", task.PartNumber).Msg("检查媒体编码出错")
+		return ffmpeg.Codecs{}, nil
+	}
+
+	codecs := ffmpeg.CodecsOf(info)
+	event := logger.Debug().Int("编号", task.PartNumber).Str("格式", info.FormatName).Stringer("编码", codecs)
+	if video := info.VideoStream(); video != nil {
+		event = event.Str("分辨率", fmt.Sprintf("%dx%d", video.Width, video.Height)).Float64("帧率", video.FrameRate)
+	}
+	if audio := info.AudioStream(); audio != nil {
+		event = event.Int("采样率", audio.SampleRate).Int("声道数", audio.Channels)
+	}
+	event.Msg("媒体编码")
+
+	_, err = codecs.DecapArgs()
+	return codecs, err
+}
+
+// decapPart de-caps raw FLV file (with `codecs`) into TS file `tsFilePath` with the remuxer chosen for the task, and returns duration of the TS media.
+// The native remuxer falls back to ffmpeg for media it doesn't support, if ffmpeg is available.
+func decapPart(ctx context.Context, task *models.PartTask, bar *progressbar.ProgressBar, codecs ffmpeg.Codecs, rawFilePath, tsFilePath string) (time.Duration, error) {
+	if task.Remuxer == remuxerFfmpeg {
+		return decapPartWithFfmpeg(ctx, bar, codecs, rawFilePath, tsFilePath)
+	}
+
+	bar.SetTotal(int64(task.Part.Length.Duration))
+	stats, err := remux.FlvFileToTs(ctx, rawFilePath, tsFilePath, func(current time.Duration) {
+		bar.SetCurrent(int64(current))
+	})
+	if err == nil {
+		bar.SetCurrent(int64(task.Part.Length.Duration))
+		return stats.Duration(), nil
+	}
+	if !errors.Is(err, flv.ErrUnsupportedCodec) || !ffmpeg.Available() {
+		return 0, err
+	}
+
+	logger.Warn().Err(err).Int("编号", task.PartNumber).Msg("内置解包器不支持该媒体，改用ffmpeg解包")
+	return decapPartWithFfmpeg(ctx, bar, codecs, rawFilePath, tsFilePath)
+}
+
+// decapPartWithFfmpeg de-caps raw FLV file into TS file `tsFilePath` with ffmpeg, and returns duration of the TS media.
+// Bitstream filters are chosen according to `codecs`.
+func decapPartWithFfmpeg(ctx context.Context, bar *progressbar.ProgressBar, codecs ffmpeg.Codecs, rawFilePath, tsFilePath string) (time.Duration, error) {
+	args, err := codecs.DecapArgs()
+	if err != nil {
+		return 0, err
+	}
+	runner, err := ffmpeg.NewRunner(append(append([]string{"-i", rawFilePath}, args...), tsFilePath)...)
+	if err != nil {
+		return 0, err
+	}
 	runner.ProbeMediaDuration(rawFilePath)
 	runner.SetTimeout(time.Minute * 15)
 	var decapProgTotalSet bool
-	err = runner.Run(func(current, total int64) {
+	err = runner.Run(ctx, func(current, total int64) {
 		if !decapProgTotalSet {
 			bar.SetTotal(total)
 			decapProgTotalSet = true
 		}
 		bar.SetCurrent(current)
 	})
-
 	if err != nil {
-		logger.Error().Err(err).Str("原始文件", rawFilePath).Str("TS文件", tsFileName).Msg("解包出错")
-		task.SetCurrentStep("已出错")
-	} else {
-		// 解包后对TS媒体进行检查，如果长度相差过大则认为解包失败，保留FLV文件以供后续人工检视
-		durationMatch := func(tsDuration time.Duration) bool {
-			diff := math.Abs(float64(task.Part.Length.Duration - tsDuration))
-			logger.Debug().Dur("期望时长", task.Part.Length.Duration).Dur("解包后时长", tsDuration).Msg("检查解包后媒体时长")
-			return diff < float64(time.Second*3)
-		}
-
-		task.SetCurrentStep("检查中")
-		if tsDuration, err := runner.ProbSingleMediaDuration(decappedTsFilePath); err == nil && durationMatch(tsDuration) {
-			logger.Debug().Str("将删除的文件", rawFilePath).Str("TS文件", tsFileName).Msg("检查通过")
-			os.Remove(rawFilePath)
-			task.SetCurrentStep("已完成")
-		} else {
-			logger.Error().Err(err).Str("原始文件", rawFilePath).Str("TS文件", tsFileName).Msg("解包后媒体时长检查未通过")
-			task.SetCurrentStep("已出错")
-		}
+		return 0, err
 	}
-
-	return decappedTsFilePath, err
+	return runner.ProbSingleMediaDuration(tsFilePath)
 }
 
-// downloadRecordParts download selected parts (`downloadList`) of given livestream record into `where`.
-// It also manages the progress bar and concurrency of downloading (`concurrency`).
-func downloadRecordParts(recordInfo *models.RecordParts, downloadList []int, where string, concurrency uint, speedLimit datasize.ByteSize) (filePaths map[int]string, err error) {
-	taskQueue := make(chan *models.PartTask)
+// partResult is the outcome of downloading a single part.
+type partResult struct {
+	partNumber int
+	filePath   string
+	err        error
+}
 
-	filePaths = make(map[int]string)
-	var filePathUpdater sync.Mutex
+// partJob is a part download task sent to a downloadPool, along with where to report its result.
+type partJob struct {
+	ctx    context.Context
+	task   *models.PartTask
+	result chan<- partResult
+}
 
-	var wg sync.WaitGroup
+// downloadPool is a group of workers downloading record parts.
+// It can be shared among multiple records, so that concurrency and speed limitation apply to all of them.
+type downloadPool struct {
+	queue       chan partJob
+	rateLimiter grab.RateLimiter
+	retryPolicy helper.RetryPolicy
+	remuxer     string
+	wg          sync.WaitGroup
+}
 
-	if int(concurrency) > len(downloadList) {
-		concurrency = uint(len(downloadList))
-		logger.Info().Uint("下载并发数", concurrency).Msg("自动调整下载并发数")
+// newDownloadPool starts `concurrency` workers, all of which share the same speed limitation (`speedLimit`).
+// Failed transfers are retried according to `retryPolicy`, and downloaded parts are de-capped with `remuxer`.
+func newDownloadPool(concurrency uint, speedLimit datasize.ByteSize, retryPolicy helper.RetryPolicy, remuxer string) *downloadPool {
+	pool := &downloadPool{queue: make(chan partJob), retryPolicy: retryPolicy, remuxer: remuxer}
+	if speedLimit != 0 {
+		pool.rateLimiter = rate.NewLimiter(rate.Limit(speedLimit), int(speedLimit))
 	}
 
 	for i := 0; i < int(concurrency); i++ {
 		workerIndex := i + 1
-		wg.Add(1)
+		pool.wg.Add(1)
 		go func(index int) {
 			logger.Debug().Int("worker编号", index).Msg("worker启动")
-			defer wg.Done()
+			defer pool.wg.Done()
 
-			for task := range taskQueue {
-				downloadTask := task
+			for job := range pool.queue {
+				downloadTask := job.task
 				logger.Debug().Int("worker编号", index).Int("任务编号", downloadTask.PartNumber).Msg("接到任务")
 				time.Sleep(time.Millisecond * 20 * time.Duration(downloadTask.PartNumber))
 
-				func() {
-					downloadedFilePath, err := downloadSinglePart(downloadTask)
-					if err != nil {
-						logger.Error().Err(err).Int("编号", downloadTask.PartNumber).Msg("下载出错")
-						downloadTask.SetCurrentStep("已出错")
-					} else {
-						filePathUpdater.Lock()
-						defer filePathUpdater.Unlock()
-						filePaths[downloadTask.PartNumber] = downloadedFilePath
-					}
-				}()
+				var downloadedFilePath string
+				err := job.ctx.Err()
+				if err == nil {
+					downloadedFilePath, err = downloadSinglePart(job.ctx, downloadTask)
+				}
+				if err != nil && job.ctx.Err() != nil {
+					logger.Warn().Int("编号", downloadTask.PartNumber).Msg("下载已取消")
+					downloadTask.SetCurrentStep(models.StepCancelled)
+					err = job.ctx.Err()
+				} else if err != nil {
+					logger.Error().Err(err).Int("编号", downloadTask.PartNumber).Msg("下载出错")
+					downloadTask.SetCurrentStep(models.StepFailed)
+				}
+				job.result <- partResult{partNumber: downloadTask.PartNumber, filePath: downloadedFilePath, err: err}
 			}
 			logger.Debug().Int("worker编号", index).Msg("worker退出")
 		}(workerIndex)
 	}
 
-	var speedLimiter grab.RateLimiter
-	if speedLimit != 0 {
-		speedLimiter = rate.NewLimiter(rate.Limit(speedLimit), int(speedLimit))
-	}
+	return pool
+}
+
+// close stops accepting new tasks, and waits for all workers to exit.
+func (p *downloadPool) close() {
+	close(p.queue)
+	p.wg.Wait()
+	logger.Debug().Msg("所有worker都已退出")
+}
+
+// downloadRecordParts download selected parts (`downloadList`) of given livestream record into `where`.
+// Tasks are sent to `pool`, which manages concurrency and speed limitation of downloading.
+// State of each part is tracked in `manifest`. It returns after all selected parts are processed.
+// Once `ctx` is done, no more parts are scheduled, and the context error is returned after running ones stop.
+func downloadRecordParts(ctx context.Context, pool *downloadPool, manifest *models.JobManifest, recordInfo *models.RecordParts, downloadList []int, where string) (filePaths map[int]string, err error) {
+	filePaths = make(map[int]string)
+	results := make(chan partResult, len(downloadList))
+
 	// Generate and insert tasks.
+	var taskCount int
 	for i, part := range recordInfo.List {
 		recordPart := part
 		if !helper.ContainsInt(downloadList, i+1) {
 			continue
 		}
+		if ctx.Err() != nil {
+			break
+		}
 
 		task := &models.PartTask{
 			PartNumber:        i + 1,
 			Part:              &recordPart,
 			DownloadDirectory: where,
-			RateLimiter:       speedLimiter,
+			RateLimiter:       pool.rateLimiter,
+			RetryPolicy:       pool.retryPolicy,
+			Remuxer:           pool.remuxer,
+			Manifest:          manifest,
+			PreviousState:     manifest.Part(i + 1),
 		}
-		task.SetCurrentStep("等待中")
+		manifest.UpdatePart(task.PartNumber, func(state *models.PartState) {
+			state.FileName = recordPart.FileName()
+			state.RawFileName = recordPart.FileName()
+			state.Size = recordPart.Size.Bytes()
+		})
+		task.SetCurrentStep(models.StepWaiting)
 		task.SetFileName(recordPart.FileName())
-		taskQueue <- task
+		select {
+		case pool.queue <- partJob{ctx: ctx, task: task, result: results}:
+			taskCount++
+		case <-ctx.Done():
+			task.SetCurrentStep(models.StepCancelled)
+		}
 	}
+	logger.Debug().Int("任务数量", taskCount).Msg("所有任务发送完毕")
 
-	logger.Debug().Int("任务数量", len(downloadList)).Msg("所有任务发送完毕")
-	close(taskQueue)
-
-	wg.Wait()
-	logger.Debug().Msg("所有worker都已退出")
+	for i := 0; i < taskCount; i++ {
+		result := <-results
+		if result.err == nil {
+			filePaths[result.partNumber] = result.filePath
+		}
+	}
 
+	err = ctx.Err()
 	return
 }
 
-// concatRecordParts concatenates multiple record parts into a single MP4 file.
-func concatRecordParts(inputFiles map[int]string, output string) error {
+// concatRecordParts concatenates multiple record parts into a single file of `container` with ffmpeg's concat demuxer.
+// Keys of `inputFiles` are part numbers, parts are concatenated in order.
+// If `clip` is given, only that range of the concatenated media is kept.
+// If `fixTimestamps` is set, missing timestamps are generated and the output starts from 0.
+// The media is written into a partial file first, which becomes `output` only if its duration is as expected.
+func concatRecordParts(ctx context.Context, inputFiles map[int]string, output string, clip *clipRange, fixTimestamps bool, container string) error {
 	if info, err := os.Stat(output); err == nil && info.Mode().IsRegular() {
 		return fmt.Errorf("文件 %s 已经存在", output)
 	}
+	partialOutput := helper.PartialFilePath(output)
+	os.Remove(partialOutput)
 
 	bar := progressbar.AddProgressBar(-1)
 	bar.SetPrefixDecorator(func(b *uiprogress.Bar) string {
@@ -214,56 +669,166 @@ func concatRecordParts(inputFiles map[int]string, output string) error {
 	})
 	bar.SetUnitType(progressbar.UnitTypeDuration)
 
-	// Concat TS containers (with H.264 media) together into a single MP4 container.
-	concatList := make([]string, len(inputFiles))
-	for i, filePath := range inputFiles {
-		concatList[i-1] = filePath
+	// Concat TS containers together into a single container, with bitstream filters chosen by codecs of the media.
+	partNumbers := make([]int, 0, len(inputFiles))
+	for i := range inputFiles {
+		partNumbers = append(partNumbers, i)
+	}
+	sort.Ints(partNumbers)
+	concatList := make([]string, 0, len(inputFiles))
+	for _, i := range partNumbers {
+		concatList = append(concatList, inputFiles[i])
+	}
+
+	codecs, err := probeMergedParts(concatList)
+	if err != nil {
+		return err
+	}
+	mergeArgs, err := codecs.MergeArgs(container)
+	if err != nil {
+		return err
+	}
+
+	// Paths in the list file don't need to be escaped for command line, and there's no limit of its length.
+	listFile := filepath.Join(filepath.Dir(output), concatListFileName)
+	if err := ffmpeg.WriteConcatList(listFile, concatList); err != nil {
+		return fmt.Errorf("生成合并列表出错：%w", err)
+	}
+	defer os.Remove(listFile)
+
+	var args []string
+	if fixTimestamps {
+		args = append(args, "-fflags", "+genpts")
+	}
+	if clip != nil {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", clip.Offset.Seconds()))
+	}
+	args = append(args, ffmpeg.ConcatInputArgs(listFile)...)
+	if clip != nil {
+		args = append(args, "-t", fmt.Sprintf("%.3f", clip.Duration.Seconds()))
+	}
+	// The format is part of `mergeArgs`, as it can't be guessed from the partial file name.
+	args = append(args, mergeArgs...)
+	if fixTimestamps {
+		args = append(args, "-avoid_negative_ts", "make_zero")
 	}
+	args = append(args, partialOutput)
 
-	runner, _ := ffmpeg.NewRunner(
-		"-i", fmt.Sprintf("concat:%s", strings.Join(concatList, "|")),
-		"-c", "copy",
-		"-bsf:a", "aac_adtstoasc",
-		"-movflags", "faststart",
-		output,
-	)
+	runner, err := ffmpeg.NewRunner(args...)
+	if err != nil {
+		return err
+	}
 	runner.ProbeMediaDuration(concatList...)
+	if clip != nil {
+		runner.SetMediaDuration(clip.Duration)
+	}
 	runner.SetTimeout(time.Minute * 20)
 	var progTotalSet bool
-	return runner.Run(func(current, total int64) {
+	err = runner.Run(ctx, func(current, total int64) {
 		if !progTotalSet {
 			bar.SetTotal(total)
 			progTotalSet = true
 		}
 		bar.SetCurrent(current)
 	})
+	if err != nil {
+		os.Remove(partialOutput)
+		return err
+	}
+
+	duration, err := runner.ProbSingleMediaDuration(partialOutput)
+	if err != nil {
+		os.Remove(partialOutput)
+		return err
+	}
+	if expected := runner.MediaDuration(); math.Abs(float64(expected-duration)) >= float64(mergedDurationTolerance) {
+		os.Remove(partialOutput)
+		return fmt.Errorf("合并后的媒体时长不正确：期望%v，实际%v", expected, duration)
+	}
+	return os.Rename(partialOutput, output)
+}
+
+// probeMergedParts probes codecs of the TS media to be merged, which must be the same for all of them.
+// A/V desync at boundaries of the media is reported too. Codecs are left unknown if ffprobe is not available.
+func probeMergedParts(inputFiles []string) (ffmpeg.Codecs, error) {
+	var codecs ffmpeg.Codecs
+	if !ffmpeg.ProbeAvailable() {
+		return codecs, nil
+	}
+
+	var prev *ffmpeg.MediaInfo
+	for i, filePath := range inputFiles {
+		info, err := ffmpeg.Probe(filePath)
+		if err != nil {
+			return codecs, fmt.Errorf("检查%s的媒体编码出错：%w", filepath.Base(filePath), err)
+		}
+		partCodecs := ffmpeg.CodecsOf(info)
+		if i == 0 {
+			codecs = partCodecs
+		} else if partCodecs != codecs {
+			return codecs, fmt.Errorf("%w：%s的编码为%s，与%s的编码%s不同，无法合并",
+				ffmpeg.ErrUnsupportedCodec, filepath.Base(filePath), partCodecs, filepath.Base(inputFiles[0]), codecs)
+		}
+
+		if prev != nil {
+			if desync, ok := ffmpeg.BoundaryDesync(prev, info); ok && (desync >= boundaryDesyncTolerance || desync <= -boundaryDesyncTolerance) {
+				logger.Warn().Str("前一分段", filepath.Base(inputFiles[i-1])).Str("后一分段", filepath.Base(filePath)).
+					Dur("音频延迟", desync).Msg("分段交界处音画不同步，部分播放器或剪辑软件中可能出现问题")
+			}
+		}
+		prev = info
+	}
+	logger.Debug().Stringer("编码", codecs).Msg("合并的媒体编码")
+	return codecs, nil
 }
 
 type DownloadParam struct {
-	RecordID     string                 // Record ID
-	Info         *models.LiveRecordInfo // Record info
-	Parts        *models.RecordParts    // Video parts
-	Liver        *models.LiverInfo      // Livestreamer info
-	DownloadList []int                  // Selected part numbers
-	Concurrency  uint
-	NoMerge      bool
-	RateLimit    datasize.ByteSize // Download speed limitation, in bytes/second
+	RecordID      string                 // Record ID
+	Info          *models.LiveRecordInfo // Record info
+	Parts         *models.RecordParts    // Video parts
+	Liver         *models.LiverInfo      // Livestreamer info
+	DownloadList  []int                  // Selected part numbers
+	Concurrency   uint
+	NoMerge       bool
+	Merge         bool              // Whether a contiguous selection of some parts is merged too, only the full record is by default
+	FixTimestamps bool              // Whether timestamps are regenerated when merging parts
+	Container     string            // Container format of merged media, see `ffmpeg.CheckContainer`
+	RateLimit     datasize.ByteSize // Download speed limitation, in bytes/second
+	Directory     string            // Record directory, generated from `DirTemplate` if empty
+	OutputDir     string            // Base directory of generated record directories, current directory if empty
+	DirTemplate   string            // Template of record directory (relative to `OutputDir`), see `defaultDirTemplate`
+	NameTemplate  string            // Template of merged file name, see `defaultNameTemplate`
+	Retry         helper.RetryPolicy
+	Remuxer       string     // How parts are de-capped, see `remuxerNative` & `remuxerFfmpeg`
+	Quality       string     // Requested quality, see `RecordParts.ResolveQuality`
+	Clip          *clipRange // Time range to keep, parts in `DownloadList` must be the ones overlapping with it
 }
 
-func cliDownload(p DownloadParam) error {
-	// Mkdir
-	cwd, err := os.Getwd()
-	if err != nil {
-		logger.Fatal().Err(err).Msg("检测当前目录出错")
+// mergeable tells whether selected parts are merged into a single file (or referenced by a single playlist).
+// It's the case for the full record and a clip, or a contiguous selection of more than one part if `Merge` is set.
+func (p *DownloadParam) mergeable() bool {
+	if p.Clip != nil || len(p.DownloadList) == len(p.Parts.List) {
+		return true
 	}
+	return p.Merge && len(p.DownloadList) > 1 && helper.IsContiguous(p.DownloadList)
+}
 
-	recordDownloadDir := filepath.Join(
-		cwd,
-		fmt.Sprintf("%d-%s", p.Liver.UserID, p.Liver.UserName),
-		fmt.Sprintf("%s-%s-%s", strings.ReplaceAll(p.Info.Start.String(), ":", "-"), p.Info.Title, p.RecordID),
-	)
+// cliDownload downloads selected parts of the record described by `p`, using workers in `pool`.
+// The progress bar manager should be started by the caller.
+// If `ctx` is done, it stops after saving the job manifest, so the download can be resumed later.
+func cliDownload(ctx context.Context, pool *downloadPool, p DownloadParam) error {
+	// Mkdir
+	recordDownloadDir := p.Directory
+	if recordDownloadDir == "" {
+		var err error
+		if recordDownloadDir, err = recordDirectory(&p); err != nil {
+			logger.Error().Err(err).Msg("生成下载目录出错")
+			return err
+		}
+	}
 	if err := os.MkdirAll(recordDownloadDir, 0755); err != nil {
-		logger.Fatal().Err(err).Str("下载目录", recordDownloadDir).Msg("建立下载目录出错")
+		logger.Error().Err(err).Str("下载目录", recordDownloadDir).Msg("建立下载目录出错")
+		return err
 	}
 	logger.Info().Str("下载目录", recordDownloadDir).Send()
 
@@ -276,48 +841,111 @@ func cliDownload(p DownloadParam) error {
 		info.WriteString(fmt.Sprintf("开始于：%s\n", p.Info.Start))
 		info.WriteString(fmt.Sprintf("结束于：%s\n", p.Info.End))
 		info.WriteString(fmt.Sprintf("共%d部分\n", len(p.Parts.List)))
-		info.WriteString(fmt.Sprintf("选择下载的分段：%s\n", p.DownloadList))
+		info.WriteString(fmt.Sprintf("选择下载的分段：%v\n", p.DownloadList))
 		if err := ioutil.WriteFile(infoFile, []byte(info.String()), 0755); err != nil {
 			logger.Error().Err(err).Str("直播信息文件", infoFile).Msg("写入直播回放信息出错")
 		}
 	}
 
-	progressbar.Start()
+	// Load job manifest recorded by previous run, so we can continue from where it stopped.
+	manifest, err := models.LoadJobManifest(recordDownloadDir)
+	if err != nil || manifest.RecordID != p.RecordID {
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn().Err(err).Str("直播回放ID", p.RecordID).Msg("读取下载任务状态出错，将重新记录")
+		}
+		manifest = models.NewJobManifest(recordDownloadDir, p.RecordID)
+	}
+	manifest.Update(func(m *models.JobManifest) {
+		m.DownloadList = p.DownloadList
+		m.Concurrency = p.Concurrency
+		m.NoMerge = p.NoMerge
+		m.Merge = p.Merge
+		m.FixTimestamps = p.FixTimestamps
+		m.Container = p.Container
+		m.RateLimit = p.RateLimit
+		m.Quality = p.Quality
+		m.NameTemplate = p.NameTemplate
+		m.ClipFrom, m.ClipTo = nil, nil
+		if p.Clip != nil {
+			m.ClipFrom, m.ClipTo = &p.Clip.From, &p.Clip.To
+		}
+		m.Finished = false
+	})
+	if err := manifest.Save(); err != nil {
+		logger.Error().Err(err).Str("文件", manifest.Path()).Msg("保存下载任务状态出错")
+		return err
+	}
+	finish := func() {
+		manifest.Update(func(m *models.JobManifest) {
+			m.Finished = true
+		})
+		if err := manifest.Save(); err != nil {
+			logger.Error().Err(err).Str("文件", manifest.Path()).Msg("保存下载任务状态出错")
+		}
+	}
 
-	fullRecordFile := filepath.Join(
-		recordDownloadDir,
-		fmt.Sprintf(
-			"%s-%s-%s-%s-complete.mp4",
-			strings.ReplaceAll(p.Info.Start.String(), ":", "-"),
-			p.RecordID,
-			p.Info.Title,
-			p.Parts.Quality(),
-		),
-	)
+	mergeable := p.mergeable()
+	mergedFileSuffix := "complete"
+	expectedDuration := p.Parts.Length.Duration
+	if p.Clip != nil {
+		mergedFileSuffix = fmt.Sprintf("clip-%s", p.Clip)
+		expectedDuration = p.Clip.Duration
+	} else if mergeable && len(p.DownloadList) != len(p.Parts.List) {
+		mergedFileSuffix = fmt.Sprintf("parts-%d-%d", p.DownloadList[0], p.DownloadList[len(p.DownloadList)-1])
+		expectedDuration = 0
+		for _, i := range p.DownloadList {
+			expectedDuration += p.Parts.List[i-1].Length.Duration
+		}
+	}
+	mergedName, err := mergedFileName(&p, mergedFileSuffix)
+	if err != nil {
+		logger.Error().Err(err).Msg("生成合并后的文件名出错")
+		return err
+	}
+	fullRecordFile := filepath.Join(recordDownloadDir, mergedName+"."+ffmpeg.ContainerExt(p.Container))
 
-	// Skip if the full recording is already downloaded.
+	// Merging relies on ffmpeg, fail before downloading anything if it's missing.
+	if mergeable && !p.NoMerge && !ffmpeg.Available() {
+		logger.Error().Msg("合并视频需要ffmpeg工具，请安装ffmpeg或使用--no-merge选项")
+		return errors.New("没有找到ffmpeg工具")
+	}
+	// So is checking duration of the merged file, which needs ffprobe for some containers.
+	if mergeable && !p.NoMerge && !ffmpeg.CanProbeDuration(p.Container) {
+		logger.Error().Str("容器格式", p.Container).Msg("检查合并后的视频时长需要ffprobe工具，请安装ffprobe或选择其他容器格式")
+		return errors.New("没有找到ffprobe工具")
+	}
+
+	// Skip if the full recording (or the merged parts) is already downloaded.
 	if _, err := os.Stat(fullRecordFile); !os.IsNotExist(err) {
 		logger.Debug().Str("文件", filepath.Base(fullRecordFile)).Msg("完整直播回放文件已存在，检查媒体时长")
-		inspector, _ := ffmpeg.NewRunner()
-		fullRecordDuration, err := inspector.ProbSingleMediaDuration(fullRecordFile)
+		fullRecordDuration, err := ffmpeg.ProbeDuration(fullRecordFile)
 		if err != nil {
 			logger.Error().Err(err).Str("文件", filepath.Base(fullRecordFile)).Msg("检查媒体文件出错")
 			return err
 		}
 
-		if math.Abs(float64(p.Parts.Length.Duration-fullRecordDuration)) < float64(time.Second*10) {
+		if math.Abs(float64(expectedDuration-fullRecordDuration)) < float64(mergedDurationTolerance) {
 			logger.Info().Str("文件", filepath.Base(fullRecordFile)).Msg("完整直播回放文件已存在，跳过下载")
+			finish()
 			return nil
 		}
 	}
 
-	decappedFiles, err := downloadRecordParts(p.Parts, p.DownloadList, recordDownloadDir, p.Concurrency, p.RateLimit)
+	decappedFiles, err := downloadRecordParts(ctx, pool, manifest, p.Parts, p.DownloadList, recordDownloadDir)
+	if ctx.Err() != nil {
+		if err := manifest.Save(); err != nil {
+			logger.Error().Err(err).Str("文件", manifest.Path()).Msg("保存下载任务状态出错")
+		}
+		logger.Warn().Str("直播回放ID", p.RecordID).Str("下载目录", recordDownloadDir).Msg("下载已中断，可以使用resume命令继续")
+		return ctx.Err()
+	}
 	if err != nil {
-		logger.Fatal().Err(err).Msg("下载直播回放出错")
+		logger.Error().Err(err).Msg("下载直播回放出错")
+		return err
 	}
 
-	// All parts downloaded, concat into a single file.
-	if len(p.DownloadList) == len(p.Parts.List) && len(decappedFiles) == len(p.Parts.List) {
+	// All selected parts downloaded, concat into a single file.
+	if mergeable && len(decappedFiles) == len(p.DownloadList) {
 		// Generate playlist to reference all the TS media files.
 		if p.NoMerge {
 			logger.Debug().Msg("将不合并视频文件，仅生成m3u8播放列表")
@@ -329,34 +957,50 @@ func cliDownload(p DownloadParam) error {
 			playlistFilePath := filepath.Join(recordDownloadDir, "播放列表.m3u8")
 			err := ffmpeg.GenerateM3U8Playlist(tsFileList, playlistFilePath)
 			logger.Debug().Err(err).Msg("生成m3u8播放列表")
+			if err == nil {
+				finish()
+			}
 			return err
 
-		} else { // Merge all TS media files into a single MP4 file.
-			logger.Info().Ints("下载的分段", p.DownloadList).Msg("合并为单个视频")
-			if err := concatRecordParts(decappedFiles, fullRecordFile); err != nil {
-				logger.Fatal().Err(err).Ints("下载的分段", p.DownloadList).Str("合并后的文件", fullRecordFile).Msg("合并视频分段出错")
+		} else { // Merge all TS media files into a single file, trim to the clip if there is one.
+			if p.Clip != nil {
+				logger.Info().Ints("下载的分段", p.DownloadList).Str("开始于", helper.JSONTime{Time: p.Clip.From}.String()).Dur("时长", p.Clip.Duration).Msg("截取为单个视频")
+			} else {
+				logger.Info().Ints("下载的分段", p.DownloadList).Msg("合并为单个视频")
+			}
+			if err := concatRecordParts(ctx, decappedFiles, fullRecordFile, p.Clip, p.FixTimestamps, p.Container); err != nil {
+				if ctx.Err() != nil {
+					logger.Warn().Str("直播回放ID", p.RecordID).Str("下载目录", recordDownloadDir).Msg("合并已中断，可以使用resume命令继续")
+					return ctx.Err()
+				}
+				logger.Error().Err(err).Ints("下载的分段", p.DownloadList).Str("合并后的文件", fullRecordFile).Msg("合并视频分段出错")
+				return err
 			}
-			progressbar.Stop()
 
 			for _, filePath := range decappedFiles {
 				err = os.Remove(filePath)
 				logger.Debug().Err(err).Str("文件", filePath).Msg("删除中间文件")
 			}
 
-			logger.Info().Str("合并后的文件", fullRecordFile).Msg("完整回放下载完毕")
+			logger.Info().Str("合并后的文件", fullRecordFile).Msg("回放下载完毕")
+			finish()
 			return nil
 		}
-	} else {
-		progressbar.Stop()
 	}
 
+	var failedCount int
 	for _, i := range p.DownloadList {
 		if filePath, ok := decappedFiles[i]; ok {
 			logger.Info().Str("文件", filePath).Msgf("第%d部分下载完成", i)
 		} else {
 			logger.Warn().Msgf("第%d部分下载不成功", i)
+			failedCount++
 		}
 	}
 
+	if failedCount > 0 {
+		return fmt.Errorf("有%d个分段下载不成功", failedCount)
+	}
+	finish()
 	return nil
 }
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bililive-downloader/ffmpeg"
+	"bililive-downloader/flv"
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"bililive-downloader/progressbar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPartTask creates a task downloading a part of `size` bytes from `url`, into a temporary directory.
+func newPartTask(t *testing.T, url string, size int) *models.PartTask {
+	progressbar.Init(ioutil.Discard)
+	dir := t.TempDir()
+	return &models.PartTask{
+		PartNumber:        1,
+		Part:              &models.RecordPart{Url: url, Size: helper.Size{ByteSize: datasize.ByteSize(size)}},
+		DownloadDirectory: dir,
+		RetryPolicy:       helper.RetryPolicy{Backoff: time.Millisecond},
+		Manifest:          models.NewJobManifest(dir, "R1"),
+	}
+}
+
+func TestDownloadSinglePart_ExistingFile(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	// A corrupted file of the right size, as left by older versions which didn't verify downloads.
+	task := newPartTask(t, server.URL+"/1.flv", 1024)
+	rawFilePath := filepath.Join(task.DownloadDirectory, "1.flv")
+	if err := ioutil.WriteFile(rawFilePath, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := downloadSinglePart(context.Background(), task)
+	assert.Error(t, err)
+	assert.NoFileExists(t, rawFilePath)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "downloaded again")
+	if state := task.Manifest.Part(1); assert.NotNil(t, state) && assert.NotNil(t, state.Verdict) {
+		assert.False(t, state.Verdict.OK)
+	}
+	assert.False(t, errors.Is(err, errCorruptedDownload))
+}
+
+// testPartContent returns content of a part of `size` bytes, which doesn't repeat within partialCheckSize.
+func testPartContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/251)
+	}
+	return content
+}
+
+// newMirror starts a server serving `content`, Range requests are ignored unless `rangeSupported`.
+// Range headers of received GET requests are recorded into `ranges`.
+func newMirror(content []byte, rangeSupported bool, ranges *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && ranges != nil {
+			*ranges = append(*ranges, r.Header.Get("Range"))
+		}
+		if rangeSupported {
+			http.ServeContent(w, r, "part.flv", time.Time{}, bytes.NewReader(content))
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	}))
+}
+
+func TestPartialContentMatches(t *testing.T) {
+	content := testPartContent(partialCheckSize * 3)
+	mirror := newMirror(content, true, nil)
+	defer mirror.Close()
+	noRangeMirror := newMirror(content, false, nil)
+	defer noRangeMirror.Close()
+
+	garbage := make([]byte, partialCheckSize*2)
+	type testRow struct {
+		name     string
+		partial  []byte // nil means no partial file
+		mirror   string
+		expected bool
+	}
+	testData := []testRow{
+		{"no partial file", nil, mirror.URL, true},
+		{"empty partial file", []byte{}, mirror.URL, true},
+		{"matching", content[:partialCheckSize*2], mirror.URL, true},
+		{"matching, smaller than check size", content[:1000], mirror.URL, true},
+		{"not matching", garbage, mirror.URL, false},
+		{"range not supported", content[:partialCheckSize*2], noRangeMirror.URL, false},
+	}
+
+	for _, row := range testData {
+		filePath := filepath.Join(t.TempDir(), "1.flv")
+		if row.partial != nil {
+			if err := ioutil.WriteFile(filePath, row.partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		matches, err := partialContentMatches(context.Background(), row.mirror, filePath)
+		assert.NoError(t, err, row.name)
+		assert.Equal(t, row.expected, matches, row.name)
+	}
+}
+
+func TestTransferPartFromMirrors(t *testing.T) {
+	content := testPartContent(partialCheckSize * 3)
+	half := len(content) / 2
+	garbage := make([]byte, half)
+
+	type testRow struct {
+		name           string
+		primaryFails   bool
+		rangeSupported bool
+		partial        []byte // Partial file downloaded from the primary mirror before, nil if there isn't one
+		expectedRange  string // Range of the download request to the backup mirror
+	}
+	testData := []testRow{
+		{"primary fails, backup succeeds", true, true, nil, ""},
+		{"partial file matches backup", true, true, content[:half], fmt.Sprintf("bytes=%d-", half)},
+		{"partial file doesn't match backup", true, true, garbage, ""},
+		{"backup doesn't support range", true, false, content[:half], ""},
+	}
+
+	for _, row := range testData {
+		var primaryRequests int32
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&primaryRequests, 1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		var ranges []string
+		backup := newMirror(content, row.rangeSupported, &ranges)
+
+		task := newPartTask(t, primary.URL+"/1.flv", len(content))
+		backupUrl := backup.URL + "/1.flv"
+		task.Part.BackupUrl = &backupUrl
+		rawFilePath := filepath.Join(task.DownloadDirectory, "1.flv")
+		if row.partial != nil {
+			if err := ioutil.WriteFile(rawFilePath, row.partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(primary.URL)
+			task.Manifest.UpdatePart(1, func(state *models.PartState) {
+				state.Mirror = u.Host
+			})
+		}
+
+		bar := task.AddProgressBar(int64(len(content)))
+		err := transferPartFromMirrors(context.Background(), task, bar, rawFilePath)
+		primary.Close()
+		backup.Close()
+
+		if !assert.NoError(t, err, row.name) {
+			continue
+		}
+		assert.NotZero(t, atomic.LoadInt32(&primaryRequests), row.name)
+		downloaded, _ := ioutil.ReadFile(rawFilePath)
+		assert.True(t, bytes.Equal(content, downloaded), "%s: downloaded content differs", row.name)
+		if assert.NotEmpty(t, ranges, row.name) {
+			assert.Equal(t, row.expectedRange, ranges[len(ranges)-1], row.name)
+		}
+		u, _ := url.Parse(backup.URL)
+		assert.Equal(t, u.Host, task.Manifest.Part(1).Mirror, row.name)
+	}
+}
+
+func TestDownloadParam_Mergeable(t *testing.T) {
+	parts := &models.RecordParts{List: make([]models.RecordPart, 5)}
+	type testRow struct {
+		selection []int
+		merge     bool
+		clip      bool
+		expected  bool
+	}
+	testData := []testRow{
+		{[]int{1, 2, 3, 4, 5}, false, false, true},
+		{[]int{2, 3, 4}, false, false, false},
+		{[]int{2, 3, 4}, true, false, true},
+		{[]int{3}, true, false, false},
+		{[]int{1, 3}, true, false, false},
+		{[]int{3}, false, true, true},
+	}
+	for _, row := range testData {
+		p := DownloadParam{Parts: parts, DownloadList: row.selection, Merge: row.merge}
+		if row.clip {
+			p.Clip = &clipRange{}
+		}
+		assert.Equal(t, row.expected, p.mergeable(), "%v", row)
+	}
+
+	// A record of a single part is still merged as the full record.
+	p := DownloadParam{Parts: &models.RecordParts{List: make([]models.RecordPart, 1)}, DownloadList: []int{1}}
+	assert.True(t, p.mergeable())
+}
+
+// testFlvMedia returns FLV media with a single H.264 video stream lasting `duration`, which can be de-capped by the native remuxer.
+func testFlvMedia(duration time.Duration) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'F', 'L', 'V', 1, 0x01, 0, 0, 0, 9, 0, 0, 0, 0})
+	writeTag := func(timestamp uint32, data []byte) {
+		size := uint32(len(data))
+		buf.Write([]byte{
+			flv.TagTypeVideo,
+			byte(size >> 16), byte(size >> 8), byte(size),
+			byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp), byte(timestamp >> 24),
+			0, 0, 0,
+		})
+		buf.Write(data)
+		_ = binary.Write(&buf, binary.BigEndian, 11+size)
+	}
+
+	sps, pps := []byte{0x67, 0x64, 0x00, 0x28, 0xac}, []byte{0x68, 0xee, 0x3c, 0x80}
+	header := append([]byte{0x17, 0, 0, 0, 0, 1, 0x64, 0x00, 0x28, 0xff, 0xe1, 0, byte(len(sps))}, sps...)
+	writeTag(0, append(append(header, 1, 0, byte(len(pps))), pps...))
+	for ts := time.Duration(0); ts <= duration; ts += 40 * time.Millisecond {
+		frame := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 3, 0x41, 0x9a, 0x02}
+		if ts%time.Second == 0 {
+			frame = []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 4, 0x65, 0x88, 0x84, 0x00}
+		}
+		writeTag(uint32(ts.Milliseconds()), frame)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadSinglePart_PartialFiles(t *testing.T) {
+	content := testFlvMedia(2 * time.Second)
+	half := len(content) / 2
+
+	type testRow struct {
+		name           string
+		length         time.Duration
+		rawFile        []byte // Content of the FLV file under its final name, nil if there isn't one
+		partialTsFile  []byte // Content of the partial TS file, nil if there isn't one
+		expectedRange  string // Range of the last download request, empty if nothing is downloaded
+		expectedFailed bool
+	}
+	testData := []testRow{
+		{"fresh download", 2 * time.Second, nil, nil, "", false},
+		{"partial FLV under final name is resumed", 2 * time.Second, content[:half], nil, fmt.Sprintf("bytes=%d-", half), false},
+		{"stale partial TS file is replaced", 2 * time.Second, content, []byte("stale"), "", false},
+		{"duration check fails", time.Hour, content, nil, "", true},
+	}
+
+	for _, row := range testData {
+		var ranges []string
+		mirror := newMirror(content, true, &ranges)
+		task := newPartTask(t, mirror.URL+"/1.flv", len(content))
+		task.Part.Length = helper.Duration{Duration: row.length}
+		rawFilePath := filepath.Join(task.DownloadDirectory, "1.flv")
+		tsFilePath := filepath.Join(task.DownloadDirectory, "1.ts")
+		if row.rawFile != nil {
+			if err := ioutil.WriteFile(rawFilePath, row.rawFile, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if row.partialTsFile != nil {
+			if err := ioutil.WriteFile(helper.PartialFilePath(tsFilePath), row.partialTsFile, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		filePath, err := downloadSinglePart(context.Background(), task)
+		mirror.Close()
+		// Partial files never stay, whatever the result is.
+		assert.NoFileExists(t, helper.PartialFilePath(rawFilePath), row.name)
+		assert.NoFileExists(t, helper.PartialFilePath(tsFilePath), row.name)
+		if row.rawFile == nil || row.expectedRange != "" {
+			if assert.NotEmpty(t, ranges, row.name) {
+				assert.Equal(t, row.expectedRange, ranges[len(ranges)-1], row.name)
+			}
+		} else {
+			assert.Empty(t, ranges, row.name)
+		}
+
+		if row.expectedFailed {
+			assert.Error(t, err, row.name)
+			assert.NoFileExists(t, tsFilePath, row.name)
+			assert.FileExists(t, rawFilePath, "%s: FLV file should be kept", row.name)
+			assert.Equal(t, models.StepFailed, task.Manifest.Part(1).Step, row.name)
+			continue
+		}
+		if !assert.NoError(t, err, row.name) {
+			continue
+		}
+		assert.Equal(t, tsFilePath, filePath, row.name)
+		assert.NoFileExists(t, rawFilePath, row.name)
+		if duration, err := ffmpeg.ProbeDuration(tsFilePath); assert.NoError(t, err, row.name) {
+			assert.InDelta(t, 2*time.Second, duration, float64(100*time.Millisecond), row.name)
+		}
+		if state := task.Manifest.Part(1); assert.NotNil(t, state, row.name) {
+			assert.Equal(t, models.StepDone, state.Step, row.name)
+			assert.NotEmpty(t, state.Fingerprint, row.name)
+		}
+	}
+}
+
+func TestConcatRecordParts_PartialFile(t *testing.T) {
+	progressbar.Init(ioutil.Discard)
+	dir := t.TempDir()
+	output := filepath.Join(dir, "complete.mp4")
+	if err := ioutil.WriteFile(helper.PartialFilePath(output), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Merging fails as the part is missing, and the stale partial file is removed anyway.
+	err := concatRecordParts(context.Background(), map[int]string{1: filepath.Join(dir, "1.ts")}, output, nil, false, ffmpeg.ContainerMP4)
+	assert.Error(t, err)
+	assert.NoFileExists(t, helper.PartialFilePath(output))
+	assert.NoFileExists(t, output)
+
+	// An existing file is never overwritten.
+	if err := ioutil.WriteFile(output, []byte("merged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, concatRecordParts(context.Background(), map[int]string{1: filepath.Join(dir, "1.ts")}, output, nil, false, ffmpeg.ContainerMP4))
+	merged, _ := ioutil.ReadFile(output)
+	assert.Equal(t, "merged", string(merged))
+}
@@ -0,0 +1,164 @@
+package ffmpeg
+
+import (
+	"bililive-downloader/helper"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Codec names as reported by ffprobe.
+const (
+	CodecH264 = "h264"
+	CodecHEVC = "hevc"
+	CodecAV1  = "av1"
+	CodecAAC  = "aac"
+	CodecOpus = "opus"
+)
+
+// ErrUnsupportedCodec means the media can't be processed due to its codecs.
+var ErrUnsupportedCodec = errors.New("不支持的编码格式")
+
+// Codecs are codecs of the video & audio streams of a media, empty if there's no such stream or it's unknown.
+type Codecs struct {
+	Video string
+	Audio string
+}
+
+// CodecsOf returns codecs of the first video & audio streams in `info`.
+func CodecsOf(info *MediaInfo) Codecs {
+	var codecs Codecs
+	if video := info.VideoStream(); video != nil {
+		codecs.Video = video.CodecName
+	}
+	if audio := info.AudioStream(); audio != nil {
+		codecs.Audio = audio.CodecName
+	}
+	return codecs
+}
+
+func (c Codecs) String() string {
+	video, audio := c.Video, c.Audio
+	if video == "" {
+		video = "-"
+	}
+	if audio == "" {
+		audio = "-"
+	}
+	return video + "/" + audio
+}
+
+// DecapArgs returns ffmpeg output arguments for de-capping FLV media of these codecs into MPEG-TS.
+// Video in FLV is length-prefixed, it's converted into Annex-B form for TS. For unknown codecs, ffmpeg inserts
+// the bitstream filters it needs automatically.
+func (c Codecs) DecapArgs() ([]string, error) {
+	args := []string{"-c", "copy"}
+	switch c.Video {
+	case "":
+	case CodecH264:
+		args = append(args, "-bsf:v", "h264_mp4toannexb")
+	case CodecHEVC:
+		args = append(args, "-bsf:v", "hevc_mp4toannexb")
+	default:
+		// AV1 is not supported by ffmpeg's MPEG-TS muxer either.
+		return nil, fmt.Errorf("%w：%s视频不能保存为TS", ErrUnsupportedCodec, c.Video)
+	}
+	switch c.Audio {
+	case "", CodecAAC, CodecOpus:
+	default:
+		return nil, fmt.Errorf("%w：%s音频不能保存为TS", ErrUnsupportedCodec, c.Audio)
+	}
+	return append(args, "-f", "mpegts"), nil
+}
+
+// Containers of merged media.
+const (
+	ContainerMP4  = "mp4"
+	ContainerFMP4 = "fmp4" // Fragmented MP4, which stays playable if merging is interrupted
+	ContainerMKV  = "mkv"
+	ContainerTS   = "ts"
+	ContainerFLV  = "flv"
+)
+
+// container describes how media are written into a container format.
+type container struct {
+	format         string   // Name of ffmpeg muxer
+	ext            string   // File extension
+	videoCodecs    []string // Supported video codecs
+	audioCodecs    []string // Supported audio codecs
+	args           []string // Extra output arguments
+	nativeDuration bool     // Whether duration of the media can be probed without ffprobe
+}
+
+var containers = map[string]container{
+	ContainerMP4: {
+		format: "mp4", ext: "mp4",
+		videoCodecs: []string{CodecH264, CodecHEVC, CodecAV1}, audioCodecs: []string{CodecAAC, CodecOpus},
+		args:           []string{"-movflags", "faststart"},
+		nativeDuration: true,
+	},
+	ContainerFMP4: {
+		format: "mp4", ext: "mp4",
+		videoCodecs: []string{CodecH264, CodecHEVC, CodecAV1}, audioCodecs: []string{CodecAAC, CodecOpus},
+		// Duration is not recorded in the header of fragmented MP4.
+		args: []string{"-movflags", "frag_keyframe+empty_moov+default_base_moof"},
+	},
+	ContainerMKV: {
+		format: "matroska", ext: "mkv",
+		videoCodecs: []string{CodecH264, CodecHEVC, CodecAV1}, audioCodecs: []string{CodecAAC, CodecOpus},
+	},
+	ContainerTS: {
+		format: "mpegts", ext: "ts",
+		videoCodecs: []string{CodecH264, CodecHEVC}, audioCodecs: []string{CodecAAC, CodecOpus},
+		nativeDuration: true,
+	},
+	ContainerFLV: {
+		format: "flv", ext: "flv",
+		videoCodecs: []string{CodecH264}, audioCodecs: []string{CodecAAC},
+		nativeDuration: true,
+	},
+}
+
+// CheckContainer tells whether `name` is one of the supported containers.
+func CheckContainer(name string) error {
+	if _, ok := containers[name]; !ok {
+		return fmt.Errorf("不支持的容器格式%s", name)
+	}
+	return nil
+}
+
+// ContainerExt returns the file extension (without dot) of media in container `name`.
+func ContainerExt(name string) string {
+	return containers[name].ext
+}
+
+// CanProbeDuration tells whether duration of media in container `name` can be probed, natively or by ffprobe.
+func CanProbeDuration(name string) bool {
+	return containers[name].nativeDuration || ProbeAvailable()
+}
+
+// MergeArgs returns ffmpeg output arguments for merging TS media of these codecs into container `name`.
+func (c Codecs) MergeArgs(name string) ([]string, error) {
+	ct, ok := containers[name]
+	if !ok {
+		return nil, CheckContainer(name)
+	}
+	if c.Video != "" && !helper.ContainsString(ct.videoCodecs, c.Video) {
+		return nil, fmt.Errorf("%w：%s视频不能保存为%s", ErrUnsupportedCodec, c.Video, strings.ToUpper(name))
+	}
+	if c.Audio != "" && !helper.ContainsString(ct.audioCodecs, c.Audio) {
+		return nil, fmt.Errorf("%w：%s音频不能保存为%s", ErrUnsupportedCodec, c.Audio, strings.ToUpper(name))
+	}
+
+	args := []string{"-c", "copy"}
+	if c.Video == CodecHEVC && ct.format == "mp4" {
+		// Players from Apple only recognize the `hvc1` tag, ffmpeg uses `hev1` by default.
+		args = append(args, "-tag:v", "hvc1")
+	}
+	if c.Audio == CodecAAC && ct.format != "mpegts" {
+		// AAC in TS has ADTS headers, which other containers don't allow.
+		args = append(args, "-bsf:a", "aac_adtstoasc")
+	}
+	args = append(args, ct.args...)
+	return append(args, "-f", ct.format), nil
+}
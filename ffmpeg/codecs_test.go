@@ -0,0 +1,72 @@
+package ffmpeg
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCodecs_DecapArgs(t *testing.T) {
+	type testRow struct {
+		codecs   Codecs
+		expected []string
+		err      error
+	}
+	rows := []testRow{
+		{Codecs{CodecH264, CodecAAC}, []string{"-c", "copy", "-bsf:v", "h264_mp4toannexb", "-f", "mpegts"}, nil},
+		{Codecs{CodecHEVC, CodecOpus}, []string{"-c", "copy", "-bsf:v", "hevc_mp4toannexb", "-f", "mpegts"}, nil},
+		{Codecs{"", CodecAAC}, []string{"-c", "copy", "-f", "mpegts"}, nil},
+		{Codecs{}, []string{"-c", "copy", "-f", "mpegts"}, nil},
+		{Codecs{CodecAV1, CodecAAC}, nil, ErrUnsupportedCodec},
+		{Codecs{CodecH264, "mp3"}, nil, ErrUnsupportedCodec},
+	}
+	for _, row := range rows {
+		args, err := row.codecs.DecapArgs()
+		assert.True(t, errors.Is(err, row.err), "%s: %v", row.codecs, err)
+		assert.Equal(t, row.expected, args, row.codecs.String())
+	}
+}
+
+func TestCodecs_MergeArgs(t *testing.T) {
+	type testRow struct {
+		codecs    Codecs
+		container string
+		expected  []string
+		err       error
+	}
+	rows := []testRow{
+		{Codecs{CodecH264, CodecAAC}, ContainerMP4, []string{"-c", "copy", "-bsf:a", "aac_adtstoasc", "-movflags", "faststart", "-f", "mp4"}, nil},
+		{Codecs{CodecHEVC, CodecOpus}, ContainerMP4, []string{"-c", "copy", "-tag:v", "hvc1", "-movflags", "faststart", "-f", "mp4"}, nil},
+		{Codecs{CodecAV1, ""}, ContainerMP4, []string{"-c", "copy", "-movflags", "faststart", "-f", "mp4"}, nil},
+		{Codecs{"mpeg2video", CodecAAC}, ContainerMP4, nil, ErrUnsupportedCodec},
+		{Codecs{CodecHEVC, CodecAAC}, ContainerFMP4, []string{"-c", "copy", "-tag:v", "hvc1", "-bsf:a", "aac_adtstoasc", "-movflags", "frag_keyframe+empty_moov+default_base_moof", "-f", "mp4"}, nil},
+		{Codecs{CodecHEVC, CodecAAC}, ContainerMKV, []string{"-c", "copy", "-bsf:a", "aac_adtstoasc", "-f", "matroska"}, nil},
+		{Codecs{CodecAV1, CodecOpus}, ContainerMKV, []string{"-c", "copy", "-f", "matroska"}, nil},
+		{Codecs{CodecHEVC, CodecAAC}, ContainerTS, []string{"-c", "copy", "-f", "mpegts"}, nil},
+		{Codecs{CodecAV1, CodecAAC}, ContainerTS, nil, ErrUnsupportedCodec},
+		{Codecs{CodecH264, CodecAAC}, ContainerFLV, []string{"-c", "copy", "-bsf:a", "aac_adtstoasc", "-f", "flv"}, nil},
+		{Codecs{CodecHEVC, CodecAAC}, ContainerFLV, nil, ErrUnsupportedCodec},
+		{Codecs{CodecH264, CodecOpus}, ContainerFLV, nil, ErrUnsupportedCodec},
+		{Codecs{}, ContainerFLV, []string{"-c", "copy", "-f", "flv"}, nil},
+	}
+	for _, row := range rows {
+		args, err := row.codecs.MergeArgs(row.container)
+		assert.True(t, errors.Is(err, row.err), "%s in %s: %v", row.codecs, row.container, err)
+		assert.Equal(t, row.expected, args, "%s in %s", row.codecs, row.container)
+	}
+
+	_, err := Codecs{CodecH264, CodecAAC}.MergeArgs("avi")
+	assert.Error(t, err)
+}
+
+func TestContainerExt(t *testing.T) {
+	assert.Equal(t, "mp4", ContainerExt(ContainerFMP4))
+	assert.Equal(t, "mkv", ContainerExt(ContainerMKV))
+	assert.NoError(t, CheckContainer(ContainerTS))
+	assert.Error(t, CheckContainer("MP4"))
+}
+
+func TestCodecs_String(t *testing.T) {
+	assert.Equal(t, "hevc/aac", Codecs{CodecHEVC, CodecAAC}.String())
+	assert.Equal(t, "-/aac", Codecs{Audio: CodecAAC}.String())
+}
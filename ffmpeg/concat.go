@@ -0,0 +1,81 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WriteConcatList writes the list file of ffmpeg's concat demuxer, which refers to `inputFiles` in order.
+// Paths are made absolute, so the list file can be put anywhere, and ConcatInputArgs must be used to read it.
+func WriteConcatList(listFile string, inputFiles []string) error {
+	f, err := os.Create(listFile)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	w.WriteString("ffconcat version 1.0\n")
+	for _, filePath := range inputFiles {
+		if err = writeConcatEntry(w, filePath); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeConcatEntry(w *bufio.Writer, filePath string) error {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	// The list is read line by line, there's no way to escape line breaks.
+	if strings.ContainsAny(absPath, "\r\n") {
+		return errors.New("文件路径中不能有换行符：" + absPath)
+	}
+	_, err = w.WriteString("file " + quoteConcatPath(absPath) + "\n")
+	return err
+}
+
+// quoteConcatPath quotes `path` for the concat list, in which `'` can only be escaped outside quotes.
+func quoteConcatPath(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// ConcatInputArgs returns ffmpeg input arguments for reading the list file written by WriteConcatList.
+// Input options like `-ss` should go before them.
+func ConcatInputArgs(listFile string) []string {
+	// Absolute paths are considered unsafe by the concat demuxer.
+	return []string{"-f", "concat", "-safe", "0", "-i", listFile}
+}
+
+// BoundaryDesync tells how much audio and video go out of sync at the boundary of media `prev` and `next`,
+// if they're concatenated by the concat demuxer. Positive means audio lags behind video, negative means it goes ahead.
+// The concat demuxer keeps timestamps inside each media, so the desync is the difference between the gaps left in audio
+// and video, where streams of `prev` end at different time, or those of `next` start at different time.
+// Players and editors that ignore timestamps and play frames back-to-back are affected by it.
+// It returns false if it can't tell, e.g. either media has no video or audio, or duration of streams is unknown.
+func BoundaryDesync(prev, next *MediaInfo) (time.Duration, bool) {
+	prevVideo, prevAudio := prev.VideoStream(), prev.AudioStream()
+	nextVideo, nextAudio := next.VideoStream(), next.AudioStream()
+	if prevVideo == nil || prevAudio == nil || nextVideo == nil || nextAudio == nil {
+		return 0, false
+	}
+	if prevVideo.Duration == 0 || prevAudio.Duration == 0 {
+		return 0, false
+	}
+
+	// Audio running short at the end of `prev` leaves a gap, which delays audio of `next` relatively.
+	endGap := (prevVideo.StartTime + prevVideo.Duration) - (prevAudio.StartTime + prevAudio.Duration)
+	startGap := nextAudio.StartTime - nextVideo.StartTime
+	return endGap + startGap, true
+}
@@ -0,0 +1,59 @@
+package ffmpeg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteConcatList(t *testing.T) {
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "list.txt")
+	inputFiles := []string{
+		filepath.Join(dir, "a|b.ts"),
+		filepath.Join(dir, "主播's 直播.ts"),
+	}
+	if !assert.NoError(t, WriteConcatList(listFile, inputFiles)) {
+		return
+	}
+	content, err := ioutil.ReadFile(listFile)
+	if assert.NoError(t, err) {
+		expected := "ffconcat version 1.0\n" +
+			"file '" + filepath.Join(dir, "a|b.ts") + "'\n" +
+			"file '" + filepath.Join(dir, "主播") + `'\''s 直播.ts'` + "\n"
+		assert.Equal(t, expected, string(content))
+	}
+
+	assert.Error(t, WriteConcatList(listFile, []string{filepath.Join(dir, "a\nb.ts")}))
+	assert.Equal(t, []string{"-f", "concat", "-safe", "0", "-i", listFile}, ConcatInputArgs(listFile))
+}
+
+func TestBoundaryDesync(t *testing.T) {
+	media := func(videoStart, videoDuration, audioStart, audioDuration time.Duration) *MediaInfo {
+		return &MediaInfo{Streams: []StreamInfo{
+			{Type: StreamTypeVideo, StartTime: videoStart, Duration: videoDuration},
+			{Type: StreamTypeAudio, StartTime: audioStart, Duration: audioDuration},
+		}}
+	}
+	type testRow struct {
+		prev, next *MediaInfo
+		expected   time.Duration
+		ok         bool
+	}
+	rows := []testRow{
+		{media(1400*time.Millisecond, time.Minute, 1400*time.Millisecond, time.Minute), media(1400*time.Millisecond, time.Minute, 1400*time.Millisecond, time.Minute), 0, true},
+		// Audio of the former ends early, and audio of the latter starts late.
+		{media(0, time.Minute, 0, time.Minute-300*time.Millisecond), media(0, time.Minute, 200*time.Millisecond, time.Minute), 500 * time.Millisecond, true},
+		// Audio of the latter starts before video.
+		{media(0, time.Minute, 0, time.Minute), media(500*time.Millisecond, time.Minute, 0, time.Minute), -500 * time.Millisecond, true},
+		{media(0, 0, 0, time.Minute), media(0, time.Minute, 0, time.Minute), 0, false},
+		{&MediaInfo{Streams: []StreamInfo{{Type: StreamTypeAudio, Duration: time.Minute}}}, media(0, time.Minute, 0, time.Minute), 0, false},
+	}
+	for i, row := range rows {
+		desync, ok := BoundaryDesync(row.prev, row.next)
+		assert.Equal(t, row.ok, ok, "row %d", i)
+		assert.Equal(t, row.expected, desync, "row %d", i)
+	}
+}
@@ -0,0 +1,193 @@
+package ffmpeg
+
+import (
+	"bililive-downloader/flv"
+	"bililive-downloader/mpegts"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errUnknownFormat means the media format can't be probed natively.
+var errUnknownFormat = errors.New("无法识别的媒体格式")
+
+// ProbeDuration probes duration of given media file.
+// FLV, TS and MP4 files are probed natively by reading their headers & timestamps, which is fast and doesn't need ffprobe.
+// `ffprobe` is used for other formats, or if native probing fails (e.g. the file is broken), as long as it's located.
+func ProbeDuration(filePath string) (time.Duration, error) {
+	duration, err := probeDurationNatively(filePath)
+	if err == nil || ffprobeBin == "" {
+		return duration, err
+	}
+	return probeDurationWithFfprobe(filePath)
+}
+
+// probeDurationNatively detects format of the media file by its magic bytes, and reads its duration.
+func probeDurationNatively(filePath string) (time.Duration, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, mpegts.PacketSize+1)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("FLV")):
+		return flv.Duration(f)
+	case len(magic) > mpegts.PacketSize && magic[0] == 0x47 && magic[mpegts.PacketSize] == 0x47:
+		return mpegts.Duration(f)
+	case len(magic) >= 8 && isMP4BoxType(string(magic[4:8])):
+		return mp4Duration(f)
+	default:
+		return 0, errUnknownFormat
+	}
+}
+
+// probeDurationWithFfprobe runs `ffprobe` command to get duration of given media file.
+func probeDurationWithFfprobe(filePath string) (time.Duration, error) {
+	var duration time.Duration
+
+	timeout, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	probeProc := exec.CommandContext(timeout, ffprobeBin, "-show_entries", "format=duration", filePath)
+	stdout, err := probeProc.StdoutPipe()
+	if err != nil {
+		return duration, err
+	}
+
+	if err := probeProc.Start(); err != nil {
+		return duration, err
+	}
+
+	var durationStr string
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.Contains(line, "duration=") {
+				durationStr = strings.TrimSpace(strings.ReplaceAll(line, "duration=", ""))
+				break
+			}
+		}
+
+		io.Copy(ioutil.Discard, stdout)
+	}()
+
+	if err := probeProc.Wait(); err != nil {
+		return duration, err
+	}
+
+	durationSec, err := strconv.ParseFloat(durationStr, 64)
+	if err != nil {
+		return duration, err
+	}
+
+	duration = time.Duration(durationSec * float64(time.Second))
+	return duration, nil
+}
+
+// isMP4BoxType tells whether `boxType` is one that MP4 files usually start with.
+func isMP4BoxType(boxType string) bool {
+	switch boxType {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide":
+		return true
+	}
+	return false
+}
+
+// mp4Duration reads duration of MP4 media from the movie header box (`moov/mvhd`).
+func mp4Duration(r io.ReadSeeker) (time.Duration, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	moovStart, moovEnd, err := findMP4Box(r, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhdStart, mvhdEnd, err := findMP4Box(r, moovStart, moovEnd, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	// Version & flags, creation & modification time, time scale, duration.
+	header := make([]byte, 32)
+	if mvhdEnd-mvhdStart < int64(len(header)) {
+		return 0, errors.New("MP4文件的mvhd不完整")
+	}
+	if _, err := r.Seek(mvhdStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	var timeScale, duration uint64
+	if header[0] == 1 {
+		timeScale = uint64(binary.BigEndian.Uint32(header[20:24]))
+		duration = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		timeScale = uint64(binary.BigEndian.Uint32(header[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+		if duration == 0xffffffff {
+			duration = 0
+		}
+	}
+	// Fragmented MP4 files might leave the duration unknown in mvhd.
+	if timeScale == 0 || duration == 0 || duration == 0xffffffffffffffff {
+		return 0, errors.New("MP4文件没有记录时长")
+	}
+	return time.Duration(float64(duration) / float64(timeScale) * float64(time.Second)), nil
+}
+
+// findMP4Box finds the box of `boxType` among boxes between `start` and `end` of `r`, and returns where its content starts and ends.
+func findMP4Box(r io.ReadSeeker, start, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return 0, 0, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0: // Extends to the end
+			size = end - pos
+		case 1: // 64-bit size follows
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || pos+size > end {
+			return 0, 0, errors.New("MP4文件结构已损坏")
+		}
+
+		if string(header[4:8]) == boxType {
+			return pos + headerSize, pos + size, nil
+		}
+		pos += size
+	}
+	return 0, 0, errors.New("MP4文件中没有找到" + boxType)
+}
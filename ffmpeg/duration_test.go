@@ -0,0 +1,103 @@
+package ffmpeg
+
+import (
+	"bililive-downloader/mpegts"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp4Box builds an MP4 box with given type and content.
+func mp4Box(boxType string, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	box := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(box, uint32(8+len(body)))
+	copy(box[4:], boxType)
+	return append(box, body...)
+}
+
+// mvhd builds content of a movie header box.
+func mvhd(version byte, timeScale uint32, duration uint64) []byte {
+	if version == 1 {
+		b := make([]byte, 32)
+		b[0] = 1
+		binary.BigEndian.PutUint32(b[20:], timeScale)
+		binary.BigEndian.PutUint64(b[24:], duration)
+		return append(b, make([]byte, 80)...)
+	}
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint32(b[12:], timeScale)
+	binary.BigEndian.PutUint32(b[16:], uint32(duration))
+	return append(b, make([]byte, 80)...)
+}
+
+func TestMp4Duration(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	largeMdat := append([]byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 20}, 1, 2, 3, 4)
+
+	type testRow struct {
+		name     string
+		data     []byte
+		expected time.Duration
+		hasError bool
+	}
+	rows := []testRow{
+		{"version 0", bytes.Join([][]byte{ftyp, mp4Box("moov", mp4Box("mvhd", mvhd(0, 1000, 3723500))), mp4Box("mdat", []byte{1, 2})}, nil), 3723500 * time.Millisecond, false},
+		{"version 1, moov at end", bytes.Join([][]byte{ftyp, largeMdat, mp4Box("moov", mp4Box("trak"), mp4Box("mvhd", mvhd(1, 90000, 90000*7200)))}, nil), 2 * time.Hour, false},
+		{"fragmented", bytes.Join([][]byte{ftyp, mp4Box("moov", mp4Box("mvhd", mvhd(0, 1000, 0)))}, nil), 0, true},
+		{"no moov", bytes.Join([][]byte{ftyp, mp4Box("mdat", []byte{1, 2})}, nil), 0, true},
+		{"truncated", bytes.Join([][]byte{ftyp, mp4Box("moov", mp4Box("mvhd", mvhd(0, 1000, 1000)))}, nil)[:60], 0, true},
+	}
+	for _, row := range rows {
+		duration, err := mp4Duration(bytes.NewReader(row.data))
+		if row.hasError {
+			assert.Error(t, err, row.name)
+		} else {
+			assert.NoError(t, err, row.name)
+			assert.Equal(t, row.expected, duration, row.name)
+		}
+	}
+}
+
+func TestProbeDuration(t *testing.T) {
+	dir := t.TempDir()
+
+	var ts bytes.Buffer
+	muxer := mpegts.NewMuxer(&ts)
+	audio, _ := muxer.AddStream(mpegts.StreamTypeAAC)
+	for i := 0; i < 50; i++ {
+		_ = muxer.WritePacket(audio, &mpegts.Packet{PTS: time.Duration(i) * 100 * time.Millisecond, Data: []byte{1, 2, 3}})
+	}
+	flv := []byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0}
+	for _, timestamp := range []byte{0x10, 0x20} {
+		flv = append(flv, 8, 0, 0, 1, 0, 0, timestamp, 0, 0, 0, 0, 0xaf, 0, 0, 0, 12)
+	}
+
+	type testRow struct {
+		name     string
+		data     []byte
+		expected time.Duration
+		hasError bool
+	}
+	rows := []testRow{
+		{"a.ts", ts.Bytes(), 4900 * time.Millisecond, false},
+		{"a.flv", flv, 16 * time.Millisecond, false},
+		{"a.mp4", mp4Box("moov", mp4Box("mvhd", mvhd(0, 1000, 1500))), 1500 * time.Millisecond, false},
+		{"a.txt", []byte("not a media file"), 0, true},
+	}
+	for _, row := range rows {
+		filePath := filepath.Join(dir, row.name)
+		assert.NoError(t, ioutil.WriteFile(filePath, row.data, 0644))
+		duration, err := ProbeDuration(filePath)
+		if row.hasError {
+			assert.Error(t, err, row.name)
+		} else {
+			assert.NoError(t, err, row.name)
+			assert.Equal(t, row.expected, duration, row.name)
+		}
+	}
+}
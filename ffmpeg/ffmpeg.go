@@ -5,8 +5,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -18,7 +16,7 @@ var ffmpegBin string
 var ffprobeBin string
 var initGuard sync.Once
 
-// Init sets the location of `ffmpeg` and `ffprobe` binary executable.
+// Init sets the location of `ffmpeg` and `ffprobe` binary executable, leave it empty if one is not found.
 // No runner can be created before this function is called.
 func Init(ffmpegBinLocation, ffprobeBinLocation string) {
 	initGuard.Do(func() {
@@ -27,12 +25,17 @@ func Init(ffmpegBinLocation, ffprobeBinLocation string) {
 	})
 }
 
+// Available tells whether `ffmpeg` is located, i.e. runners can be created.
+// `ffprobe` is optional, it's only a fallback of ProbeDuration.
+func Available() bool {
+	return ffmpegBin != ""
+}
+
 type Runner struct {
-	ffmpegBin  string // Location of `ffmpeg` binary executable
-	ffprobeBin string // Location of `ffprobe` binary executable
-	args       []string
-	duration   time.Duration // Duration of current processing media
-	timeout    time.Duration
+	ffmpegBin string // Location of `ffmpeg` binary executable
+	args      []string
+	duration  time.Duration // Duration of current processing media
+	timeout   time.Duration
 }
 
 // NewRunner creates a new Runner instance
@@ -40,9 +43,6 @@ func NewRunner(args ...string) (*Runner, error) {
 	if ffmpegBin == "" {
 		return nil, errors.New("ffmpeg not located, you should probably call Init first")
 	}
-	if ffprobeBin == "" {
-		return nil, errors.New("ffprobe not located, you should probably call Init first")
-	}
 
 	fullArgs := []string{"-progress", "-", "-nostats"}
 
@@ -62,55 +62,16 @@ func NewRunner(args ...string) (*Runner, error) {
 		fullArgs = append(fullArgs, a)
 	}
 
-	return &Runner{args: fullArgs, ffmpegBin: ffmpegBin, ffprobeBin: ffprobeBin}, nil
+	return &Runner{args: fullArgs, ffmpegBin: ffmpegBin}, nil
 }
 
-// probSingleMediaDuration runs `ffprobe` command to get duration og given media file.
+// ProbSingleMediaDuration probes duration of given media file, see ProbeDuration.
 // It does not touch internal state of `r`.
 func (r *Runner) ProbSingleMediaDuration(filePath string) (time.Duration, error) {
-	var duration time.Duration
-
-	timeout, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	probeProc := exec.CommandContext(timeout, r.ffprobeBin, "-show_entries", "format=duration", filePath)
-	stdout, err := probeProc.StdoutPipe()
-	if err != nil {
-		return duration, err
-	}
-
-	if err := probeProc.Start(); err != nil {
-		return duration, err
-	}
-
-	var durationStr string
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.Contains(line, "duration=") {
-				durationStr = strings.TrimSpace(strings.ReplaceAll(line, "duration=", ""))
-				break
-			}
-		}
-
-		io.Copy(ioutil.Discard, stdout)
-	}()
-
-	if err := probeProc.Wait(); err != nil {
-		return duration, err
-	}
-
-	durationSec, err := strconv.ParseFloat(durationStr, 64)
-	if err != nil {
-		return duration, err
-	}
-
-	duration = time.Duration(durationSec * float64(time.Second))
-	return duration, nil
+	return ProbeDuration(filePath)
 }
 
-// ProbeMediaDuration runs `ffprobe` command to probe given list of media files.
+// ProbeMediaDuration probes durations of given list of media files, see ProbeDuration.
 // The result will be stored into `r` to be used as `total` of progress callback.
 // This is because `ffmpeg` does not reliably output durations of all the media files it's processing, so we do a manual probe instead.
 func (r *Runner) ProbeMediaDuration(listOfFiles ...string) error {
@@ -130,6 +91,17 @@ func (r *Runner) ProbeMediaDuration(listOfFiles ...string) error {
 	return nil
 }
 
+// SetMediaDuration sets duration of the output media manually, to be used as `total` of progress callback.
+// Use it when the output is not as long as all the input media, e.g. a clip of them.
+func (r *Runner) SetMediaDuration(duration time.Duration) {
+	r.duration = duration
+}
+
+// MediaDuration returns duration of the output media, as probed or set manually.
+func (r *Runner) MediaDuration() time.Duration {
+	return r.duration
+}
+
 // SetTimeout sets a timeout for given Runner instance
 func (r *Runner) SetTimeout(timeout time.Duration) {
 	r.timeout = timeout
@@ -162,21 +134,20 @@ func parseDurationStr(str string) (time.Duration, error) {
 }
 
 // Run runs the given Runner instance (spawns ffmpeg process).
+// The process is killed if `ctx` is done before it exits, the output file is left as is.
 // Pass a callback function to receive progress.
-func (r *Runner) Run(progressCallback func(current, total int64)) error {
+func (r *Runner) Run(ctx context.Context, progressCallback func(current, total int64)) error {
 	if r.duration == 0 {
 		return fmt.Errorf("total duration unknown, please call .ProbeMediaDuration first")
 	}
 
-	var proc *exec.Cmd
-	if r.timeout == 0 {
-		proc = exec.Command(r.ffmpegBin, r.args...)
-	} else {
-		timeout, cancel := context.WithTimeout(context.Background(), r.timeout)
+	if r.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
 		defer cancel()
-
-		proc = exec.CommandContext(timeout, r.ffmpegBin, r.args...)
 	}
+	proc := exec.CommandContext(ctx, r.ffmpegBin, r.args...)
+	isolateProcess(proc)
 
 	ffmpegStdout, err := proc.StdoutPipe()
 	if err != nil {
@@ -216,5 +187,9 @@ func (r *Runner) Run(progressCallback func(current, total int64)) error {
 		progressCallback(r.duration.Nanoseconds(), r.duration.Nanoseconds())
 	}
 
+	// Report why the process was killed, rather than "signal: killed".
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
 	return err
 }
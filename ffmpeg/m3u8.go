@@ -1,6 +1,7 @@
 package ffmpeg
 
 import (
+	"bililive-downloader/helper"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -11,7 +12,7 @@ import (
 
 // GenerateM3U8Playlist generates a M3U8 playlist file for given input files.
 // All input files must be of TS media type.
-// This function requires ffmpeg toolset (same as rest of this package), as it uses the `ffprobe` tool.
+// Durations of input files are probed by ProbeDuration, which doesn't need the ffmpeg toolset for TS files.
 // Existing playlist file will be deleted.
 func GenerateM3U8Playlist(inputFiles []string, outputFile string) error {
 	_, err := os.Stat(outputFile)
@@ -28,11 +29,6 @@ func GenerateM3U8Playlist(inputFiles []string, outputFile string) error {
 		return fmt.Errorf("no input file given")
 	}
 
-	runner, err := NewRunner()
-	if err != nil {
-		return err
-	}
-
 	baseDir := filepath.Dir(outputFile)
 	// Build playlist entries
 	var refMode *os.FileMode
@@ -57,7 +53,7 @@ func GenerateM3U8Playlist(inputFiles []string, outputFile string) error {
 		}
 
 		// Probe media duration
-		length, err := runner.ProbSingleMediaDuration(filePath)
+		length, err := ProbeDuration(filePath)
 		if err != nil {
 			return err
 		}
@@ -81,5 +77,9 @@ func GenerateM3U8Playlist(inputFiles []string, outputFile string) error {
 
 	playlistHeader.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int64(maxDuration.Round(time.Second).Seconds()+1)))
 	playlistHeader.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n\n")
-	return ioutil.WriteFile(outputFile, []byte(playlistHeader.String()+playlistItems.String()), *refMode)
+	partialFile := helper.PartialFilePath(outputFile)
+	if err := ioutil.WriteFile(partialFile, []byte(playlistHeader.String()+playlistItems.String()), *refMode); err != nil {
+		return err
+	}
+	return os.Rename(partialFile, outputFile)
 }
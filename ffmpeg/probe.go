@@ -0,0 +1,192 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream types of StreamInfo.
+const (
+	StreamTypeVideo = "video"
+	StreamTypeAudio = "audio"
+)
+
+// MediaInfo is the overview of a media file, as probed by ffprobe.
+type MediaInfo struct {
+	FormatName string // Short name of the container format, e.g. `flv`, `mpegts`, `mov,mp4,m4a,3gp,3g2,mj2`
+	Duration   time.Duration
+	StartTime  time.Duration
+	BitRate    int64 // Overall bit rate, in bits/second
+	Size       int64 // File size, in bytes
+	Streams    []StreamInfo
+}
+
+// StreamInfo describes a stream of a media file.
+type StreamInfo struct {
+	Index     int
+	Type      string // StreamTypeVideo, StreamTypeAudio, or others like `data`
+	CodecName string // e.g. `h264`, `hevc`, `aac`
+	Profile   string // e.g. `High`, `LC`
+	BitRate   int64  // In bits/second, 0 if unknown
+	StartTime time.Duration
+	Duration  time.Duration
+
+	// Video streams only
+	Width       int
+	Height      int
+	FrameRate   float64 // Average frame rate, in frames/second
+	PixelFormat string
+
+	// Audio streams only
+	SampleRate    int // In Hz
+	Channels      int
+	ChannelLayout string
+}
+
+// VideoStream returns the first video stream, nil if there isn't one.
+func (m *MediaInfo) VideoStream() *StreamInfo {
+	return m.firstStream(StreamTypeVideo)
+}
+
+// AudioStream returns the first audio stream, nil if there isn't one.
+func (m *MediaInfo) AudioStream() *StreamInfo {
+	return m.firstStream(StreamTypeAudio)
+}
+
+func (m *MediaInfo) firstStream(streamType string) *StreamInfo {
+	for i := range m.Streams {
+		if m.Streams[i].Type == streamType {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// ProbeAvailable tells whether `ffprobe` is located, i.e. Probe can be used.
+func ProbeAvailable() bool {
+	return ffprobeBin != ""
+}
+
+// Probe runs `ffprobe` command to inspect streams and format of given media file.
+func Probe(filePath string) (*MediaInfo, error) {
+	if ffprobeBin == "" {
+		return nil, errors.New("ffprobe not located, you should probably call Init first")
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	probeProc := exec.CommandContext(timeout, ffprobeBin, "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
+	output, err := probeProc.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return parseProbeOutput(output)
+}
+
+// probeOutput is the JSON output of ffprobe, numbers other than integers are output as strings.
+type probeOutput struct {
+	Streams []struct {
+		Index         int    `json:"index"`
+		CodecType     string `json:"codec_type"`
+		CodecName     string `json:"codec_name"`
+		Profile       string `json:"profile"`
+		BitRate       string `json:"bit_rate"`
+		StartTime     string `json:"start_time"`
+		Duration      string `json:"duration"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		AvgFrameRate  string `json:"avg_frame_rate"`
+		RFrameRate    string `json:"r_frame_rate"`
+		PixelFormat   string `json:"pix_fmt"`
+		SampleRate    string `json:"sample_rate"`
+		Channels      int    `json:"channels"`
+		ChannelLayout string `json:"channel_layout"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		StartTime  string `json:"start_time"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// parseProbeOutput parses JSON output of ffprobe into MediaInfo. Values ffprobe doesn't know (`N/A`) are left as 0.
+func parseProbeOutput(data []byte) (*MediaInfo, error) {
+	var output probeOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+
+	info := &MediaInfo{
+		FormatName: output.Format.FormatName,
+		Duration:   parseSeconds(output.Format.Duration),
+		StartTime:  parseSeconds(output.Format.StartTime),
+		BitRate:    parseInt(output.Format.BitRate),
+		Size:       parseInt(output.Format.Size),
+	}
+	for _, s := range output.Streams {
+		stream := StreamInfo{
+			Index:         s.Index,
+			Type:          s.CodecType,
+			CodecName:     s.CodecName,
+			Profile:       s.Profile,
+			BitRate:       parseInt(s.BitRate),
+			StartTime:     parseSeconds(s.StartTime),
+			Duration:      parseSeconds(s.Duration),
+			Width:         s.Width,
+			Height:        s.Height,
+			FrameRate:     parseRational(s.AvgFrameRate),
+			PixelFormat:   s.PixelFormat,
+			SampleRate:    int(parseInt(s.SampleRate)),
+			Channels:      s.Channels,
+			ChannelLayout: s.ChannelLayout,
+		}
+		// Average frame rate is unknown for some streams, e.g. those in FLV files without metadata.
+		if stream.FrameRate == 0 {
+			stream.FrameRate = parseRational(s.RFrameRate)
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+	return info, nil
+}
+
+func parseInt(str string) int64 {
+	v, _ := strconv.ParseInt(str, 10, 64)
+	return v
+}
+
+func parseSeconds(str string) time.Duration {
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(v * float64(time.Second))
+}
+
+// parseRational parses rational numbers like `30000/1001`.
+func parseRational(str string) float64 {
+	fields := strings.SplitN(str, "/", 2)
+	num, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(fields) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
@@ -0,0 +1,108 @@
+package ffmpeg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	output := `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "h264",
+            "profile": "High",
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "pix_fmt": "yuv420p",
+            "r_frame_rate": "30/1",
+            "avg_frame_rate": "30000/1001",
+            "start_time": "1.400000",
+            "duration": "1799.966667",
+            "bit_rate": "6000000"
+        },
+        {
+            "index": 1,
+            "codec_name": "aac",
+            "profile": "LC",
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 2,
+            "channel_layout": "stereo",
+            "r_frame_rate": "0/0",
+            "avg_frame_rate": "0/0",
+            "start_time": "1.423000",
+            "bit_rate": "N/A"
+        },
+        {
+            "index": 2,
+            "codec_type": "data",
+            "r_frame_rate": "0/0",
+            "avg_frame_rate": "0/0"
+        }
+    ],
+    "format": {
+        "filename": "a.ts",
+        "nb_streams": 3,
+        "format_name": "mpegts",
+        "start_time": "1.400000",
+        "duration": "1800.023000",
+        "size": "1373471168",
+        "bit_rate": "6104234"
+    }
+}`
+	info, err := parseProbeOutput([]byte(output))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "mpegts", info.FormatName)
+	assert.Equal(t, 1800023*time.Millisecond, info.Duration)
+	assert.Equal(t, 1400*time.Millisecond, info.StartTime)
+	assert.Equal(t, int64(6104234), info.BitRate)
+	assert.Equal(t, int64(1373471168), info.Size)
+	assert.Len(t, info.Streams, 3)
+
+	video := info.VideoStream()
+	if assert.NotNil(t, video) {
+		assert.Equal(t, "h264", video.CodecName)
+		assert.Equal(t, "High", video.Profile)
+		assert.Equal(t, 1920, video.Width)
+		assert.Equal(t, 1080, video.Height)
+		assert.InDelta(t, 29.97, video.FrameRate, 0.001)
+		assert.Equal(t, int64(6000000), video.BitRate)
+	}
+
+	audio := info.AudioStream()
+	if assert.NotNil(t, audio) {
+		assert.Equal(t, 1, audio.Index)
+		assert.Equal(t, "aac", audio.CodecName)
+		assert.Equal(t, 48000, audio.SampleRate)
+		assert.Equal(t, 2, audio.Channels)
+		assert.Equal(t, "stereo", audio.ChannelLayout)
+		assert.Equal(t, int64(0), audio.BitRate)
+		assert.Equal(t, 0.0, audio.FrameRate)
+		assert.Equal(t, 1423*time.Millisecond, audio.StartTime)
+	}
+
+	_, err = parseProbeOutput([]byte("not JSON"))
+	assert.Error(t, err)
+}
+
+func TestParseRational(t *testing.T) {
+	type testRow struct {
+		str      string
+		expected float64
+	}
+	rows := []testRow{
+		{"25/1", 25},
+		{"60", 60},
+		{"0/0", 0},
+		{"", 0},
+		{"N/A", 0},
+	}
+	for _, row := range rows {
+		assert.Equal(t, row.expected, parseRational(row.str), row.str)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package ffmpeg
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolateProcess puts the process into its own process group, so that Ctrl-C in the terminal doesn't reach it.
+// It's stopped through the context it's started with instead, which lets the caller clean up after it.
+func isolateProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,55 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// maxScanErrors limits how many error messages ScanErrors collects, a badly broken file can produce lots of them.
+const maxScanErrors = 20
+
+// ScanErrors reads through given media file with ffmpeg, and returns error messages it reports (empty if the file is fine).
+// With `decode`, all streams are decoded, which finds more problems but takes much longer.
+// Otherwise streams are only demuxed, which is fast and finds problems of the container structure.
+func ScanErrors(ctx context.Context, filePath string, decode bool) ([]string, error) {
+	if ffmpegBin == "" {
+		return nil, errors.New("ffmpeg not located, you should probably call Init first")
+	}
+
+	args := []string{"-nostdin", "-v", "error", "-i", filePath}
+	if !decode {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-f", "null", "-")
+
+	proc := exec.CommandContext(ctx, ffmpegBin, args...)
+	isolateProcess(proc)
+	stderr, err := proc.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := proc.Start(); err != nil {
+		return nil, err
+	}
+
+	var messages []string
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && len(messages) < maxScanErrors {
+			messages = append(messages, line)
+		}
+	}
+
+	err = proc.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// ffmpeg explains why it failed, which is what we're looking for.
+	if err != nil && len(messages) == 0 {
+		return nil, err
+	}
+	return messages, nil
+}
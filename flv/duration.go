@@ -0,0 +1,257 @@
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// maxHeadTags limits how many tags are read from the start of file to find the first media tag.
+	maxHeadTags = 64
+	// maxTailTags limits how many tags are walked backwards from the end of file to find the last media tag.
+	maxTailTags = 64
+)
+
+// Duration reads duration of FLV media from `r`, without reading through all tags.
+// It's the time span between the first and the last audio / video tags, the latter is found by walking backwards from
+// the end of file with PreviousTagSize. If the tail of the file is broken, `duration` in onMetaData is used instead.
+func Duration(r io.ReadSeeker) (time.Duration, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var first time.Duration
+	var metaDuration time.Duration
+	var mediaSeen bool
+	for i := 0; i < maxHeadTags && !mediaSeen; i++ {
+		tag, err := reader.ReadTag()
+		if err != nil {
+			return 0, err
+		}
+		switch tag.Type {
+		case TagTypeScript:
+			if d, ok := metaDataDuration(tag.Data); ok {
+				metaDuration = d
+			}
+		case TagTypeAudio, TagTypeVideo:
+			first, mediaSeen = tag.Timestamp, true
+		}
+	}
+
+	if mediaSeen {
+		if last, err := lastMediaTimestamp(r); err == nil {
+			return last - first, nil
+		}
+	}
+	if metaDuration > 0 {
+		return metaDuration, nil
+	}
+	return 0, fmt.Errorf("%w：无法确定时长", ErrCorrupted)
+}
+
+// lastMediaTimestamp finds timestamp of the last audio / video tag, by walking backwards from the end of file.
+func lastMediaTimestamp(r io.ReadSeeker) (time.Duration, error) {
+	pos, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	var prevTagSize [4]byte
+	var header [tagHeaderSize]byte
+	for i := 0; i < maxTailTags; i++ {
+		if err := readAt(r, pos-4, prevTagSize[:]); err != nil {
+			return 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(prevTagSize[:]))
+		tagStart := pos - 4 - size
+		if size < tagHeaderSize || tagStart < headerSize+4 {
+			return 0, fmt.Errorf("%w：位置%d的标签长度记录为%d", ErrCorrupted, pos-4, size)
+		}
+		if err := readAt(r, tagStart, header[:]); err != nil {
+			return 0, err
+		}
+		dataSize := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if dataSize+tagHeaderSize != size {
+			return 0, fmt.Errorf("%w：位置%d的标签长度记录为%d，实际为%d", ErrCorrupted, pos-4, size, tagHeaderSize+dataSize)
+		}
+
+		if tagType := header[0] & 0x1f; tagType == TagTypeAudio || tagType == TagTypeVideo {
+			timestamp := uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+			return time.Duration(timestamp) * time.Millisecond, nil
+		}
+		pos = tagStart
+	}
+	return 0, fmt.Errorf("%w：文件末尾没有音视频标签", ErrCorrupted)
+}
+
+func readAt(r io.ReadSeeker, offset int64, p []byte) error {
+	if offset < 0 {
+		return ErrTruncated
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, p); err != nil {
+		return ErrTruncated
+	}
+	return nil
+}
+
+// AMF0 value types used in script tags.
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// metaDataDuration extracts `duration` (in seconds) from data of an onMetaData script tag.
+func metaDataDuration(data []byte) (time.Duration, bool) {
+	d := &amfDecoder{data: data}
+	if name, ok := d.value(); !ok || name != "onMetaData" {
+		return 0, false
+	}
+
+	if len(d.data) < 1 {
+		return 0, false
+	}
+	switch d.data[0] {
+	case amfECMAArray:
+		if len(d.data) < 5 {
+			return 0, false
+		}
+		d.data = d.data[5:] // The count is just a hint, the array ends with an end marker like objects.
+	case amfObject:
+		d.data = d.data[1:]
+	default:
+		return 0, false
+	}
+
+	for {
+		key, ok := d.key()
+		if !ok {
+			return 0, false
+		}
+		value, ok := d.value()
+		if !ok {
+			return 0, false
+		}
+		if key == "duration" {
+			seconds, ok := value.(float64)
+			if !ok || seconds <= 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+				return 0, false
+			}
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+	}
+}
+
+// amfDecoder decodes AMF0 values, just enough to read onMetaData.
+type amfDecoder struct {
+	data []byte
+}
+
+func (d *amfDecoder) take(n int) ([]byte, bool) {
+	if n < 0 || len(d.data) < n {
+		return nil, false
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, true
+}
+
+// key reads a property name of an object, it fails at the end of the object.
+func (d *amfDecoder) key() (string, bool) {
+	b, ok := d.take(2)
+	if !ok {
+		return "", false
+	}
+	s, ok := d.take(int(binary.BigEndian.Uint16(b)))
+	if !ok || len(s) == 0 {
+		return "", false
+	}
+	return string(s), true
+}
+
+// value reads a value. Numbers are returned as float64, strings as string, and others are skipped as nil.
+func (d *amfDecoder) value() (interface{}, bool) {
+	marker, ok := d.take(1)
+	if !ok {
+		return nil, false
+	}
+	switch marker[0] {
+	case amfNumber:
+		b, ok := d.take(8)
+		if !ok {
+			return nil, false
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), true
+	case amfBoolean:
+		_, ok := d.take(1)
+		return nil, ok
+	case amfString:
+		b, ok := d.take(2)
+		if !ok {
+			return nil, false
+		}
+		s, ok := d.take(int(binary.BigEndian.Uint16(b)))
+		return string(s), ok
+	case amfLongString:
+		b, ok := d.take(4)
+		if !ok {
+			return nil, false
+		}
+		s, ok := d.take(int(binary.BigEndian.Uint32(b)))
+		return string(s), ok
+	case amfNull, amfUndefined:
+		return nil, true
+	case amfDate:
+		_, ok := d.take(10)
+		return nil, ok
+	case amfECMAArray, amfObject:
+		if marker[0] == amfECMAArray {
+			if _, ok := d.take(4); !ok {
+				return nil, false
+			}
+		}
+		for {
+			if len(d.data) >= 3 && d.data[0] == 0 && d.data[1] == 0 && d.data[2] == amfObjectEnd {
+				d.data = d.data[3:]
+				return nil, true
+			}
+			if _, ok := d.key(); !ok {
+				return nil, false
+			}
+			if _, ok := d.value(); !ok {
+				return nil, false
+			}
+		}
+	case amfStrictArray:
+		b, ok := d.take(4)
+		if !ok {
+			return nil, false
+		}
+		for i := uint32(0); i < binary.BigEndian.Uint32(b); i++ {
+			if _, ok := d.value(); !ok {
+				return nil, false
+			}
+		}
+		return nil, true
+	default:
+		return nil, false
+	}
+}
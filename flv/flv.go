@@ -0,0 +1,202 @@
+// Package flv reads FLV files tag by tag, as downloaded from bilibili livestream records.
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Tag types.
+const (
+	TagTypeAudio  = 8
+	TagTypeVideo  = 9
+	TagTypeScript = 18
+)
+
+const (
+	headerSize    = 9
+	tagHeaderSize = 11
+	// maxTagDataSize limits size of a single tag, anything larger is surely corrupted.
+	maxTagDataSize = 16 << 20
+)
+
+var (
+	// ErrInvalidHeader means the file is not an FLV file at all.
+	ErrInvalidHeader = errors.New("不是有效的FLV文件")
+	// ErrTruncated means the file ends in the middle of a tag.
+	ErrTruncated = errors.New("FLV文件不完整")
+	// ErrCorrupted means the tag structure is broken.
+	ErrCorrupted = errors.New("FLV文件已损坏")
+)
+
+// Header is the FLV file header.
+type Header struct {
+	Version  uint8
+	HasAudio bool
+	HasVideo bool
+}
+
+// Tag is a single FLV tag.
+type Tag struct {
+	Type      uint8
+	Timestamp time.Duration
+	Data      []byte
+	Offset    int64 // Offset of the tag in the file
+}
+
+// Reader reads FLV tags from an underlying reader.
+type Reader struct {
+	r      io.Reader
+	offset int64
+	Header Header
+}
+
+// NewReader reads FLV header from `r`, and returns a Reader to read tags after it.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: r}
+
+	var header [headerSize]byte
+	if err := reader.readFull(header[:]); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	if !bytes.Equal(header[:3], []byte("FLV")) {
+		return nil, ErrInvalidHeader
+	}
+	dataOffset := binary.BigEndian.Uint32(header[5:9])
+	if dataOffset < headerSize {
+		return nil, ErrInvalidHeader
+	}
+	reader.Header = Header{
+		Version:  header[3],
+		HasAudio: header[4]&0x04 != 0,
+		HasVideo: header[4]&0x01 != 0,
+	}
+
+	// Skip extra header bytes and the first PreviousTagSize, which is always 0.
+	if _, err := io.CopyN(ioutil.Discard, reader, int64(dataOffset-headerSize)); err != nil {
+		return nil, ErrTruncated
+	}
+	var prevTagSize [4]byte
+	if err := reader.readFull(prevTagSize[:]); err != nil {
+		return nil, ErrTruncated
+	}
+	return reader, nil
+}
+
+// Read implements io.Reader, keeping track of the offset.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *Reader) readFull(p []byte) error {
+	_, err := io.ReadFull(r, p)
+	return err
+}
+
+// ReadTag reads the next tag. io.EOF is returned if there are no more tags,
+// ErrTruncated if the file ends in the middle of a tag, and ErrCorrupted if the tag is malformed.
+func (r *Reader) ReadTag() (*Tag, error) {
+	offset := r.offset
+	var header [tagHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, ErrTruncated
+	}
+
+	tagType := header[0] & 0x1f
+	dataSize := uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	timestamp := uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+	if dataSize > maxTagDataSize {
+		return nil, fmt.Errorf("%w：位置%d的标签长度为%d", ErrCorrupted, offset, dataSize)
+	}
+
+	tag := &Tag{
+		Type:      tagType,
+		Timestamp: time.Duration(timestamp) * time.Millisecond,
+		Data:      make([]byte, dataSize),
+		Offset:    offset,
+	}
+	if err := r.readFull(tag.Data); err != nil {
+		return nil, ErrTruncated
+	}
+
+	var prevTagSize [4]byte
+	if err := r.readFull(prevTagSize[:]); err != nil {
+		return nil, ErrTruncated
+	}
+	if size := binary.BigEndian.Uint32(prevTagSize[:]); size != tagHeaderSize+dataSize {
+		return nil, fmt.Errorf("%w：位置%d的标签长度记录为%d，实际为%d", ErrCorrupted, offset, size, tagHeaderSize+dataSize)
+	}
+	return tag, nil
+}
+
+// Summary is the overview of an FLV file.
+type Summary struct {
+	Header      Header
+	AudioTags   int
+	VideoTags   int
+	ScriptTags  int
+	FirstTime   time.Duration // Timestamp of the first audio / video tag
+	LastTime    time.Duration // Timestamp of the last audio / video tag
+	Size        int64         // Bytes read
+	Interrupted error         // Why reading stopped before the end of file, nil if the file is intact
+}
+
+// Duration is the time span between first and last audio / video tags.
+func (s *Summary) Duration() time.Duration {
+	return s.LastTime - s.FirstTime
+}
+
+// Scan reads through all tags from `r`, and summarizes them.
+// An error is returned only if the FLV header is invalid, problems of the tags are reported in `Summary.Interrupted`.
+func Scan(r io.Reader) (*Summary, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{Header: reader.Header}
+	defer func() {
+		summary.Size = reader.offset
+	}()
+
+	var mediaSeen bool
+	for {
+		tag, err := reader.ReadTag()
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err != nil {
+			summary.Interrupted = err
+			return summary, nil
+		}
+
+		switch tag.Type {
+		case TagTypeAudio:
+			summary.AudioTags++
+		case TagTypeVideo:
+			summary.VideoTags++
+		case TagTypeScript:
+			summary.ScriptTags++
+			continue
+		default:
+			summary.Interrupted = fmt.Errorf("%w：位置%d的标签类型%d未知", ErrCorrupted, tag.Offset, tag.Type)
+			return summary, nil
+		}
+
+		if !mediaSeen {
+			summary.FirstTime, mediaSeen = tag.Timestamp, true
+		}
+		if tag.Timestamp > summary.LastTime {
+			summary.LastTime = tag.Timestamp
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+// buildFlv builds an FLV file with given tags, each tag is (type, timestamp in ms, data size).
+func buildFlv(tags ...[3]uint32) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	for _, tag := range tags {
+		tagType, timestamp, size := tag[0], tag[1], tag[2]
+		buf.Write([]byte{
+			byte(tagType),
+			byte(size >> 16), byte(size >> 8), byte(size),
+			byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp), byte(timestamp >> 24),
+			0, 0, 0,
+		})
+		buf.Write(make([]byte, size))
+		_ = binary.Write(&buf, binary.BigEndian, tagHeaderSize+size)
+	}
+	return buf.Bytes()
+}
+
+func TestReader_ReadTag(t *testing.T) {
+	data := buildFlv([3]uint32{TagTypeScript, 0, 20}, [3]uint32{TagTypeVideo, 0x01000010, 5})
+	reader, err := NewReader(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Header{Version: 1, HasAudio: true, HasVideo: true}, reader.Header)
+
+	tag, err := reader.ReadTag()
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(TagTypeScript), tag.Type)
+	assert.Len(t, tag.Data, 20)
+	assert.Equal(t, int64(13), tag.Offset)
+
+	tag, err = reader.ReadTag()
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(TagTypeVideo), tag.Type)
+	assert.Equal(t, time.Duration(0x01000010)*time.Millisecond, tag.Timestamp) // extended timestamp
+
+	_, err = reader.ReadTag()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestScan(t *testing.T) {
+	intact := buildFlv(
+		[3]uint32{TagTypeScript, 0, 30},
+		[3]uint32{TagTypeVideo, 1000, 100},
+		[3]uint32{TagTypeAudio, 1010, 10},
+		[3]uint32{TagTypeVideo, 3000, 100},
+		[3]uint32{TagTypeAudio, 2990, 10},
+	)
+
+	summary, err := Scan(bytes.NewReader(intact))
+	if assert.NoError(t, err) {
+		assert.NoError(t, summary.Interrupted)
+		assert.Equal(t, 2, summary.VideoTags)
+		assert.Equal(t, 2, summary.AudioTags)
+		assert.Equal(t, 1, summary.ScriptTags)
+		assert.Equal(t, time.Second*2, summary.Duration())
+		assert.Equal(t, int64(len(intact)), summary.Size)
+	}
+
+	type testRow struct {
+		data     []byte
+		expected error
+	}
+	badPrevSize := append([]byte{}, intact...)
+	badPrevSize[len(badPrevSize)-1]++
+	unknownType := append([]byte{}, intact...)
+	unknownType[13] = 7
+
+	testData := []testRow{
+		{intact[:len(intact)-1], ErrTruncated},
+		{intact[:len(intact)-20], ErrTruncated},
+		{intact[:20], ErrTruncated},
+		{badPrevSize, ErrCorrupted},
+		{unknownType, ErrCorrupted},
+	}
+	for i, row := range testData {
+		summary, err := Scan(bytes.NewReader(row.data))
+		if assert.NoError(t, err, i) {
+			assert.True(t, errors.Is(summary.Interrupted, row.expected), "%d: %v", i, summary.Interrupted)
+		}
+	}
+
+	for _, invalid := range [][]byte{nil, []byte("FLV"), []byte("MP4\x01\x05\x00\x00\x00\x09\x00\x00\x00\x00")} {
+		_, err := Scan(bytes.NewReader(invalid))
+		assert.Equal(t, ErrInvalidHeader, err)
+	}
+}
+
+func TestParseVideoTag(t *testing.T) {
+	packet, err := ParseVideoTag([]byte{0x17, PacketTypeData, 0xff, 0xff, 0xd8, 0xaa})
+	if assert.NoError(t, err) {
+		assert.True(t, packet.Keyframe)
+		assert.Equal(t, -40*time.Millisecond, packet.CompositionTime) // signed
+		assert.Equal(t, []byte{0xaa}, packet.Data)
+	}
+
+	packet, err = ParseVideoTag([]byte{0x1c, PacketTypeSequenceHeader, 0, 0, 0, 0x01})
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint8(VideoCodecHEVC), packet.CodecID)
+		assert.Equal(t, uint8(PacketTypeSequenceHeader), packet.PacketType)
+	}
+
+	// Enhanced RTMP, coded frames with and without composition time
+	packet, err = ParseVideoTag([]byte{0x91, 'h', 'v', 'c', '1', 0, 0, 0x28, 0xaa})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &VideoPacket{CodecID: VideoCodecHEVC, Keyframe: true, PacketType: PacketTypeData, CompositionTime: 40 * time.Millisecond, Data: []byte{0xaa}}, packet)
+	}
+	packet, err = ParseVideoTag([]byte{0xa3, 'a', 'v', 'c', '1', 0xaa})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &VideoPacket{CodecID: VideoCodecAVC, PacketType: PacketTypeData, Data: []byte{0xaa}}, packet)
+	}
+
+	_, err = ParseVideoTag([]byte{0x12, PacketTypeData, 0, 0, 0})
+	assert.True(t, errors.Is(err, ErrUnsupportedCodec))
+	_, err = ParseVideoTag([]byte{0x90, 'a', 'v', '0', '1', 0xaa})
+	assert.True(t, errors.Is(err, ErrUnsupportedCodec))
+	_, err = ParseVideoTag([]byte{0x17, PacketTypeData})
+	assert.True(t, errors.Is(err, ErrCorrupted))
+	_, err = ParseVideoTag([]byte{0x91, 'h', 'v', 'c', '1', 0})
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
+
+func TestParseDecoderConfig(t *testing.T) {
+	type testRow struct {
+		codecID  uint8
+		data     []byte
+		expected *DecoderConfig
+		err      error
+	}
+	hevcHeader := make([]byte, 21)
+	rows := []testRow{
+		{
+			VideoCodecAVC,
+			[]byte{1, 0x64, 0x00, 0x28, 0xff, 0xe1, 0, 2, 0x67, 0x64, 1, 0, 1, 0x68},
+			&DecoderConfig{CodecID: VideoCodecAVC, LengthSize: 4, ParameterSets: [][]byte{{0x67, 0x64}, {0x68}}},
+			nil,
+		},
+		{
+			VideoCodecHEVC,
+			append(append([]byte{}, hevcHeader...), 0xff, 3,
+				0xa0, 0, 1, 0, 1, 0x40,
+				0xa1, 0, 1, 0, 2, 0x42, 0x01,
+				0xa2, 0, 2, 0, 1, 0x44, 0, 1, 0x45),
+			&DecoderConfig{CodecID: VideoCodecHEVC, LengthSize: 4, ParameterSets: [][]byte{{0x40}, {0x42, 0x01}, {0x44}, {0x45}}},
+			nil,
+		},
+		{VideoCodecAVC, []byte{1, 0x64, 0x00, 0x28, 0xff, 0xe1, 0, 9, 0x67}, nil, ErrCorrupted},
+		{VideoCodecHEVC, append(append([]byte{}, hevcHeader...), 0xff, 1, 0xa0, 0, 1), nil, ErrCorrupted},
+		{2, []byte{1}, nil, ErrUnsupportedCodec},
+	}
+	for i, row := range rows {
+		config, err := ParseDecoderConfig(row.codecID, row.data)
+		if row.err != nil {
+			assert.True(t, errors.Is(err, row.err), "row %d: %v", i, err)
+			continue
+		}
+		if assert.NoError(t, err, "row %d", i) {
+			assert.Equal(t, row.expected, config, "row %d", i)
+		}
+	}
+}
+
+func TestSplitNALUnits(t *testing.T) {
+	units, err := SplitNALUnits([]byte{0, 0, 0, 2, 0x65, 0x01, 0, 0, 0, 1, 0x06}, 4)
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]byte{{0x65, 0x01}, {0x06}}, units)
+	}
+	units, err = SplitNALUnits([]byte{0, 1, 0x41}, 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]byte{{0x41}}, units)
+	}
+
+	_, err = SplitNALUnits([]byte{0, 0, 0, 5, 0x65}, 4)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
+
+func TestParseAACConfig(t *testing.T) {
+	config, err := ParseAACConfig([]byte{0x12, 0x10})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &AACConfig{ObjectType: 2, SampleRateIndex: 4, ChannelConfig: 2}, config)
+		assert.Equal(t, []byte{0xff, 0xf1, 0x50, 0x80, 0x02, 0x1f, 0xfc}, config.ADTSHeader(9))
+	}
+
+	// HE-AAC signalled with explicit sample rate
+	_, err = ParseAACConfig([]byte{0x2f, 0x80})
+	assert.True(t, errors.Is(err, ErrUnsupportedCodec))
+}
+
+// metaData builds data of an onMetaData script tag, with given duration in seconds.
+func metaData(duration float64) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{amfString, 0, 10})
+	buf.WriteString("onMetaData")
+	buf.Write([]byte{amfECMAArray, 0, 0, 0, 3})
+	for _, key := range []string{"encoder", "width", "duration"} {
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(key)))
+		buf.WriteString(key)
+		switch key {
+		case "encoder":
+			buf.Write([]byte{amfString, 0, 3, 'x', 'y', 'z'})
+		case "width":
+			buf.WriteByte(amfNumber)
+			_ = binary.Write(&buf, binary.BigEndian, float64(1920))
+		case "duration":
+			buf.WriteByte(amfNumber)
+			_ = binary.Write(&buf, binary.BigEndian, duration)
+		}
+	}
+	buf.Write([]byte{0, 0, amfObjectEnd})
+	return buf.Bytes()
+}
+
+func TestDuration(t *testing.T) {
+	meta := metaData(3.5)
+	data := buildFlv(
+		[3]uint32{TagTypeScript, 0, uint32(len(meta))},
+		[3]uint32{TagTypeVideo, 1000, 100},
+		[3]uint32{TagTypeAudio, 1010, 10},
+		[3]uint32{TagTypeVideo, 3000, 100},
+		[3]uint32{TagTypeAudio, 3020, 10},
+		[3]uint32{TagTypeScript, 3020, 10},
+	)
+	copy(data[13+tagHeaderSize:], meta)
+
+	duration, err := Duration(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 2020*time.Millisecond, duration)
+
+	// Broken tail, falls back to onMetaData.
+	duration, err = Duration(bytes.NewReader(data[:len(data)-3]))
+	assert.NoError(t, err)
+	assert.Equal(t, 3500*time.Millisecond, duration)
+
+	// Broken tail without onMetaData.
+	data[13+tagHeaderSize] = amfNull
+	_, err = Duration(bytes.NewReader(data[:len(data)-3]))
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	_, err = Duration(bytes.NewReader([]byte("not an FLV file")))
+	assert.Equal(t, ErrInvalidHeader, err)
+}
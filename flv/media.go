@@ -0,0 +1,284 @@
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Video codec IDs.
+const (
+	VideoCodecAVC  = 7
+	VideoCodecHEVC = 12 // Not in the FLV specification, but widely used by Chinese CDNs including bilibili
+)
+
+// videoExHeader marks an enhanced RTMP video tag, whose codec is identified by FourCC.
+const videoExHeader = 0x80
+
+// videoFourCCs maps FourCCs of enhanced RTMP to video codec IDs.
+var videoFourCCs = map[string]uint8{
+	"avc1": VideoCodecAVC,
+	"hvc1": VideoCodecHEVC,
+}
+
+// Packet types of enhanced RTMP video tags.
+const (
+	exPacketTypeSequenceStart = 0
+	exPacketTypeCodedFrames   = 1
+	exPacketTypeSequenceEnd   = 2
+	exPacketTypeCodedFramesX  = 3 // Coded frames without composition time
+)
+
+// Audio sound formats.
+const (
+	SoundFormatAAC = 10
+)
+
+// Packet types of video and AAC audio.
+const (
+	PacketTypeSequenceHeader = 0
+	PacketTypeData           = 1
+	PacketTypeEndOfSequence  = 2 // Video only
+)
+
+// ErrUnsupportedCodec means the media is encoded by a codec this package can't handle.
+var ErrUnsupportedCodec = errors.New("不支持的编码格式")
+
+// VideoPacket is the parsed payload of a video tag.
+type VideoPacket struct {
+	CodecID         uint8 // VideoCodecAVC or VideoCodecHEVC, enhanced RTMP tags are normalized too
+	Keyframe        bool
+	PacketType      uint8
+	CompositionTime time.Duration // PTS - DTS
+	Data            []byte        // Decoder configuration record for sequence headers, length-prefixed NAL units otherwise
+}
+
+// ParseVideoTag parses payload of a video tag, either a legacy one or an enhanced RTMP one.
+func ParseVideoTag(data []byte) (*VideoPacket, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("%w：视频标签为空", ErrCorrupted)
+	}
+	if data[0]&videoExHeader != 0 {
+		return parseExVideoTag(data)
+	}
+
+	packet := &VideoPacket{CodecID: data[0] & 0x0f, Keyframe: data[0]>>4 == 1}
+	if packet.CodecID != VideoCodecAVC && packet.CodecID != VideoCodecHEVC {
+		return nil, fmt.Errorf("%w：视频编码%d", ErrUnsupportedCodec, packet.CodecID)
+	}
+	if len(data) < 5 {
+		return nil, fmt.Errorf("%w：视频标签过短", ErrCorrupted)
+	}
+
+	packet.PacketType = data[1]
+	packet.CompositionTime = compositionTime(data[2:5])
+	packet.Data = data[5:]
+	return packet, nil
+}
+
+// parseExVideoTag parses payload of an enhanced RTMP video tag, packet types are converted into legacy ones.
+func parseExVideoTag(data []byte) (*VideoPacket, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("%w：视频标签过短", ErrCorrupted)
+	}
+	fourCC := string(data[1:5])
+	codecID, ok := videoFourCCs[fourCC]
+	if !ok {
+		return nil, fmt.Errorf("%w：视频编码%s", ErrUnsupportedCodec, fourCC)
+	}
+
+	packet := &VideoPacket{CodecID: codecID, Keyframe: (data[0]>>4)&0x07 == 1, Data: data[5:]}
+	switch data[0] & 0x0f {
+	case exPacketTypeSequenceStart:
+		packet.PacketType = PacketTypeSequenceHeader
+	case exPacketTypeCodedFrames:
+		if len(packet.Data) < 3 {
+			return nil, fmt.Errorf("%w：视频标签过短", ErrCorrupted)
+		}
+		packet.PacketType = PacketTypeData
+		packet.CompositionTime = compositionTime(packet.Data[:3])
+		packet.Data = packet.Data[3:]
+	case exPacketTypeCodedFramesX:
+		packet.PacketType = PacketTypeData
+	case exPacketTypeSequenceEnd:
+		packet.PacketType = PacketTypeEndOfSequence
+	default:
+		// Metadata and other packets which don't carry frames.
+		packet.PacketType = PacketTypeEndOfSequence + 1
+	}
+	return packet, nil
+}
+
+// compositionTime decodes composition time, which is a signed 24-bit integer in milliseconds.
+func compositionTime(b []byte) time.Duration {
+	cts := int32(uint32(b[0])<<16|uint32(b[1])<<8|uint32(b[2])) << 8 >> 8
+	return time.Duration(cts) * time.Millisecond
+}
+
+// AudioPacket is the parsed payload of an audio tag.
+type AudioPacket struct {
+	SoundFormat uint8
+	PacketType  uint8
+	Data        []byte // AudioSpecificConfig for sequence headers, raw AAC frame otherwise
+}
+
+// ParseAudioTag parses payload of an audio tag.
+func ParseAudioTag(data []byte) (*AudioPacket, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("%w：音频标签为空", ErrCorrupted)
+	}
+	packet := &AudioPacket{SoundFormat: data[0] >> 4}
+	if packet.SoundFormat != SoundFormatAAC {
+		return nil, fmt.Errorf("%w：音频编码%d", ErrUnsupportedCodec, packet.SoundFormat)
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("%w：音频标签过短", ErrCorrupted)
+	}
+
+	packet.PacketType = data[1]
+	packet.Data = data[2:]
+	return packet, nil
+}
+
+// DecoderConfig is the decoder configuration record in sequence headers of AVC / HEVC video.
+type DecoderConfig struct {
+	CodecID       uint8
+	LengthSize    int      // Size of NAL unit length prefix, in bytes
+	ParameterSets [][]byte // SPS & PPS for AVC, VPS, SPS & PPS for HEVC, in the order they should be sent
+}
+
+// ParseDecoderConfig parses AVCDecoderConfigurationRecord or HEVCDecoderConfigurationRecord, according to `codecID`.
+func ParseDecoderConfig(codecID uint8, data []byte) (*DecoderConfig, error) {
+	switch codecID {
+	case VideoCodecAVC:
+		return parseAVCConfig(data)
+	case VideoCodecHEVC:
+		return parseHEVCConfig(data)
+	default:
+		return nil, fmt.Errorf("%w：视频编码%d", ErrUnsupportedCodec, codecID)
+	}
+}
+
+// readParameterSets reads `count` parameter sets, each prefixed with its 16-bit length.
+func readParameterSets(data []byte, count int) ([][]byte, []byte, error) {
+	var sets [][]byte
+	for i := 0; i < count; i++ {
+		if len(data) < 2 {
+			return nil, nil, fmt.Errorf("%w：视频配置不完整", ErrCorrupted)
+		}
+		size := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+size {
+			return nil, nil, fmt.Errorf("%w：视频配置不完整", ErrCorrupted)
+		}
+		sets = append(sets, data[2:2+size])
+		data = data[2+size:]
+	}
+	return sets, data, nil
+}
+
+func parseAVCConfig(data []byte) (*DecoderConfig, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("%w：AVC配置过短", ErrCorrupted)
+	}
+	config := &DecoderConfig{CodecID: VideoCodecAVC, LengthSize: int(data[4]&0x03) + 1}
+
+	sps, rest, err := readParameterSets(data[6:], int(data[5]&0x1f))
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < 1 {
+		return nil, fmt.Errorf("%w：AVC配置不完整", ErrCorrupted)
+	}
+	pps, _, err := readParameterSets(rest[1:], int(rest[0]))
+	if err != nil {
+		return nil, err
+	}
+	config.ParameterSets = append(sps, pps...)
+	return config, nil
+}
+
+func parseHEVCConfig(data []byte) (*DecoderConfig, error) {
+	if len(data) < 23 {
+		return nil, fmt.Errorf("%w：HEVC配置过短", ErrCorrupted)
+	}
+	config := &DecoderConfig{CodecID: VideoCodecHEVC, LengthSize: int(data[21]&0x03) + 1}
+
+	// Arrays of VPS, SPS, PPS and SEI, each array is led by its NAL unit type.
+	rest := data[23:]
+	for i := 0; i < int(data[22]); i++ {
+		if len(rest) < 3 {
+			return nil, fmt.Errorf("%w：HEVC配置不完整", ErrCorrupted)
+		}
+		sets, next, err := readParameterSets(rest[3:], int(binary.BigEndian.Uint16(rest[1:3])))
+		if err != nil {
+			return nil, err
+		}
+		config.ParameterSets = append(config.ParameterSets, sets...)
+		rest = next
+	}
+	return config, nil
+}
+
+// SplitNALUnits splits length-prefixed NAL units, as in AVC / HEVC video packets.
+func SplitNALUnits(data []byte, lengthSize int) ([][]byte, error) {
+	var units [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, fmt.Errorf("%w：NAL单元长度不完整", ErrCorrupted)
+		}
+		var size int
+		for _, b := range data[:lengthSize] {
+			size = size<<8 | int(b)
+		}
+		data = data[lengthSize:]
+		if size > len(data) {
+			return nil, fmt.Errorf("%w：NAL单元长度为%d，剩余%d字节", ErrCorrupted, size, len(data))
+		}
+		units = append(units, data[:size])
+		data = data[size:]
+	}
+	return units, nil
+}
+
+// AACConfig is the AudioSpecificConfig in the AAC sequence header.
+type AACConfig struct {
+	ObjectType      uint8 // Audio object type, e.g. 2 for AAC-LC
+	SampleRateIndex uint8
+	ChannelConfig   uint8
+}
+
+// ParseAACConfig parses AudioSpecificConfig.
+func ParseAACConfig(data []byte) (*AACConfig, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("%w：AAC配置过短", ErrCorrupted)
+	}
+	config := &AACConfig{
+		ObjectType:      data[0] >> 3,
+		SampleRateIndex: (data[0]&0x07)<<1 | data[1]>>7,
+		ChannelConfig:   (data[1] >> 3) & 0x0f,
+	}
+	// Escaped object types and explicit sample rates can't be represented in ADTS headers.
+	if config.ObjectType == 0 || config.ObjectType > 4 || config.SampleRateIndex >= 13 {
+		return nil, fmt.Errorf("%w：AAC类型%d，采样率编号%d", ErrUnsupportedCodec, config.ObjectType, config.SampleRateIndex)
+	}
+	return config, nil
+}
+
+// ADTSHeaderSize is the size of ADTS header without CRC.
+const ADTSHeaderSize = 7
+
+// ADTSHeader builds the ADTS header for a raw AAC frame of `frameSize` bytes.
+func (c *AACConfig) ADTSHeader(frameSize int) []byte {
+	length := frameSize + ADTSHeaderSize
+	profile := c.ObjectType - 1
+	return []byte{
+		0xff,
+		0xf1, // MPEG-4, layer 0, no CRC
+		profile<<6 | c.SampleRateIndex<<2 | c.ChannelConfig>>2,
+		(c.ChannelConfig&0x03)<<6 | byte(length>>11),
+		byte(length >> 3),
+		byte(length&0x07)<<5 | 0x1f,
+		0xfc,
+	}
+}
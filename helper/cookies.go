@@ -0,0 +1,155 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCookieName is the name of bilibili login session cookie.
+const sessionCookieName = "SESSDATA"
+
+// cookieDomains are where cookies given by value are sent, i.e. bilibili API and media CDN.
+var cookieDomains = []string{".bilibili.com", ".bilivideo.com"}
+
+// Cookies are sent along with HTTP requests to authenticate them.
+// Their values must never be logged or written to files other than the user's own.
+type Cookies []*http.Cookie
+
+// ParseCookies parses cookies given by the user, which can be one of:
+//   - path to a Netscape-format cookies.txt exported from the browser;
+//   - a Cookie header value, e.g. "SESSDATA=xxx; bili_jct=yyy";
+//   - the bare value of SESSDATA cookie.
+//
+// Cookies given by value are scoped to bilibili domains, and only sent over HTTPS.
+func ParseCookies(spec string) (Cookies, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	if info, err := os.Stat(spec); err == nil && !info.IsDir() {
+		f, err := os.Open(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseNetscapeCookies(f)
+	}
+
+	if strings.Contains(spec, "=") {
+		header := http.Header{"Cookie": []string{spec}}
+		cookies := (&http.Request{Header: header}).Cookies()
+		if len(cookies) == 0 {
+			return nil, errors.New("无法解析Cookies")
+		}
+		return scopeCookies(cookies), nil
+	}
+
+	// Looks like a file name rather than a cookie value, the file might be misspelled.
+	if strings.ContainsAny(spec, `/\`) || strings.HasSuffix(strings.ToLower(spec), ".txt") {
+		return nil, fmt.Errorf("Cookies文件不存在: %s", spec)
+	}
+	return scopeCookies([]*http.Cookie{{Name: sessionCookieName, Value: spec}}), nil
+}
+
+// scopeCookies restricts cookies given by value to `cookieDomains` and HTTPS,
+// so they never leak to other hosts (e.g. backup mirrors) or over plain HTTP.
+func scopeCookies(cookies []*http.Cookie) Cookies {
+	scoped := make(Cookies, 0, len(cookies)*len(cookieDomains))
+	for _, domain := range cookieDomains {
+		for _, cookie := range cookies {
+			scoped = append(scoped, &http.Cookie{Name: cookie.Name, Value: cookie.Value, Domain: domain, Path: "/", Secure: true})
+		}
+	}
+	return scoped
+}
+
+// ParseNetscapeCookies parses cookies in Netscape cookies.txt format,
+// i.e. lines of tab-separated domain, include subdomains, path, secure, expiry, name and value.
+func ParseNetscapeCookies(r io.Reader) (Cookies, error) {
+	var cookies Cookies
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Cookies文件第%d行格式错误", lineNumber)
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cookies文件第%d行的过期时间无效", lineNumber)
+		}
+
+		// A leading dot marks that the cookie is also sent to subdomains, otherwise it's sent to the exact host only.
+		domain := strings.TrimPrefix(fields[0], ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			domain = "." + domain
+		}
+		cookie := &http.Cookie{
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expiry != 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(cookies) == 0 {
+		return nil, errors.New("Cookies文件中没有任何Cookie")
+	}
+	return cookies, nil
+}
+
+// AddTo adds cookies applicable to given request into its header.
+// Cookies are matched against the request by domain, path, secure flag and expiry.
+func (c Cookies) AddTo(req *http.Request) {
+	now := time.Now()
+	for _, cookie := range c {
+		if !cookie.Expires.IsZero() && cookie.Expires.Before(now) {
+			continue
+		}
+		if cookie.Secure && req.URL.Scheme != "https" {
+			continue
+		}
+		if cookie.Domain != "" && !domainMatches(req.URL.Hostname(), cookie.Domain) {
+			continue
+		}
+		if cookie.Path != "" && !strings.HasPrefix(req.URL.Path, cookie.Path) {
+			continue
+		}
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+}
+
+// domainMatches tells whether `host` is `domain`, or its subdomain if `domain` starts with a dot.
+func domainMatches(host, domain string) bool {
+	host, domain = strings.ToLower(host), strings.ToLower(domain)
+	if strings.HasPrefix(domain, ".") {
+		return host == domain[1:] || strings.HasSuffix(host, domain)
+	}
+	return host == domain
+}
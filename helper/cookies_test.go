@@ -0,0 +1,110 @@
+package helper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCookiesTxt = "# Netscape HTTP Cookie File\n" +
+	"\n" +
+	"#HttpOnly_.bilibili.com\tTRUE\t/\tFALSE\t0\tSESSDATA\tabc%2C123*xy\n" +
+	".bilibili.com\tTRUE\t/\tTRUE\t4102444800\tbili_jct\tcsrf\n" +
+	".bilibili.com\tTRUE\t/\tFALSE\t946684800\texpired\t1\n" +
+	"live.bilibili.com\tFALSE\t/xlive\tFALSE\t0\tpathonly\t2\r\n" +
+	".example.com\tTRUE\t/\tFALSE\t0\tother\t3\n"
+
+func TestParseNetscapeCookies(t *testing.T) {
+	cookies, err := ParseNetscapeCookies(strings.NewReader(testCookiesTxt))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, cookies, 5)
+	assert.Equal(t, "SESSDATA", cookies[0].Name)
+	assert.Equal(t, "abc%2C123*xy", cookies[0].Value)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Expires.IsZero())
+	assert.True(t, cookies[1].Secure)
+	assert.Equal(t, "2", cookies[3].Value)
+
+	for _, invalid := range []string{"", "# only comments\n", "a\tb\tc\n", ".a.com\tTRUE\t/\tFALSE\tnever\tx\ty\n"} {
+		_, err := ParseNetscapeCookies(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCookies_AddTo(t *testing.T) {
+	cookies, err := ParseNetscapeCookies(strings.NewReader(testCookiesTxt))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testData := map[string]string{
+		"https://api.live.bilibili.com/xlive/web-room/v1/record/getInfoByLiveRecord": "SESSDATA=abc%2C123*xy; bili_jct=csrf",
+		"http://bilibili.com/":                                  "SESSDATA=abc%2C123*xy",
+		"https://live.bilibili.com/xlive/a":                     "SESSDATA=abc%2C123*xy; bili_jct=csrf; pathonly=2",
+		"https://cn-gotcha01.bilivideo.com/live-bvc/record.flv": "",
+		"https://evilbilibili.com/":                             "",
+		"https://www.example.com/":                              "other=3",
+	}
+
+	for url, expected := range testData {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		cookies.AddTo(req)
+		assert.Equal(t, expected, req.Header.Get("Cookie"), url)
+	}
+}
+
+func TestParseCookies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookies")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookies.txt")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testCookiesTxt), 0600))
+
+	cookies, err := ParseCookies(path)
+	assert.NoError(t, err)
+	assert.Len(t, cookies, 5)
+
+	cookies, err = ParseCookies(" SESSDATA=a; bili_jct=b ")
+	assert.NoError(t, err)
+	assert.Len(t, cookies, 2*len(cookieDomains))
+
+	cookies, err = ParseCookies("abc%2C123*xy")
+	assert.NoError(t, err)
+	assert.Equal(t, Cookies{
+		{Name: "SESSDATA", Value: "abc%2C123*xy", Domain: ".bilibili.com", Path: "/", Secure: true},
+		{Name: "SESSDATA", Value: "abc%2C123*xy", Domain: ".bilivideo.com", Path: "/", Secure: true},
+	}, cookies)
+
+	// Cookies given by value are only sent to bilibili over HTTPS.
+	testData := map[string]string{
+		"https://api.live.bilibili.com/xlive/web-room/v1/record/getInfoByLiveRecord": "SESSDATA=abc%2C123*xy",
+		"https://d1--cn-gotcha03.bilivideo.com/a.flv":                                "SESSDATA=abc%2C123*xy",
+		"http://d1--cn-gotcha03.bilivideo.com/a.flv":                                 "",
+		"http://api.live.bilibili.com/":                                              "",
+		"https://backup.example.com/a.flv":                                           "",
+		"https://evilbilibili.com/":                                                  "",
+	}
+	for url, expected := range testData {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		cookies.AddTo(req)
+		assert.Equal(t, expected, req.Header.Get("Cookie"), url)
+	}
+
+	cookies, err = ParseCookies("")
+	assert.NoError(t, err)
+	assert.Nil(t, cookies)
+
+	_, err = ParseCookies(filepath.Join(dir, "missing.txt"))
+	assert.Error(t, err)
+}
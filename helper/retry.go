@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// maxRetryBackoff caps waiting time between retries, no matter how many retries were made.
+const maxRetryBackoff = time.Minute * 5
+
+// RetryPolicy describes how a failed operation should be retried.
+// Waiting time doubles after each failed attempt (exponential backoff).
+type RetryPolicy struct {
+	Retries uint          // Max number of retries, 0 means never retry
+	Backoff time.Duration // Waiting time before the first retry
+}
+
+// noRetryError wraps an error that should not be retried.
+type noRetryError struct {
+	err error
+}
+
+func (e noRetryError) Error() string {
+	return e.err.Error()
+}
+
+func (e noRetryError) Unwrap() error {
+	return e.err
+}
+
+// NoRetry marks `err` as permanent, `RetryPolicy.DoContext` returns it immediately without retrying.
+func NoRetry(err error) error {
+	if err == nil {
+		return nil
+	}
+	return noRetryError{err: err}
+}
+
+// Delay returns waiting time before given retry (starting from 1).
+func (p RetryPolicy) Delay(retry uint) time.Duration {
+	if retry == 0 || p.Backoff <= 0 {
+		return 0
+	}
+
+	delay := p.Backoff
+	for i := uint(1); i < retry; i++ {
+		delay *= 2
+		if delay >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+	return delay
+}
+
+// DoContext runs `fn` until it succeeds, returns an error marked by `NoRetry`, all retries are used up, or `ctx` is done.
+// `onRetry` is optional, it's called before waiting for each retry, with the error causing this retry.
+// The last error is returned if `fn` never succeeds, or the context error if waiting for a retry is interrupted.
+func (p RetryPolicy) DoContext(ctx context.Context, fn func() error, onRetry func(retry uint, delay time.Duration, err error)) error {
+	var err error
+	for retry := uint(0); ; retry++ {
+		if retry > 0 {
+			delay := p.Delay(retry)
+			if onRetry != nil {
+				onRetry(retry, delay, err)
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		var permanent noRetryError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if retry >= p.Retries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
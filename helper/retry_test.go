@@ -0,0 +1,99 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{Retries: 20, Backoff: time.Second}
+
+	testData := map[uint]time.Duration{
+		0:  0,
+		1:  time.Second,
+		2:  time.Second * 2,
+		3:  time.Second * 4,
+		5:  time.Second * 16,
+		9:  time.Second * 256,
+		10: time.Minute * 5, // capped
+		20: time.Minute * 5,
+	}
+
+	for retry, expected := range testData {
+		assert.Equal(t, expected, policy.Delay(retry))
+	}
+	assert.Equal(t, time.Duration(0), RetryPolicy{Retries: 3}.Delay(2))
+}
+
+func TestRetryPolicy_DoContext(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+
+	type testRow struct {
+		retries       uint
+		failures      int   // How many times fn fails before succeeding
+		failWith      error // Error returned by fn
+		expectedCalls int
+		expectedErr   error
+	}
+
+	testData := []testRow{
+		{0, 0, errTransient, 1, nil},
+		{0, 1, errTransient, 1, errTransient},
+		{3, 2, errTransient, 3, nil},
+		{3, 3, errTransient, 4, nil},
+		{3, 10, errTransient, 4, errTransient},
+		{3, 10, NoRetry(errPermanent), 1, errPermanent},
+	}
+
+	for _, row := range testData {
+		var calls int
+		var retries []uint
+		err := RetryPolicy{Retries: row.retries}.DoContext(context.Background(), func() error {
+			calls++
+			if calls <= row.failures {
+				return row.failWith
+			}
+			return nil
+		}, func(retry uint, delay time.Duration, err error) {
+			retries = append(retries, retry)
+			assert.Error(t, err)
+		})
+
+		assert.Equal(t, row.expectedErr, err)
+		assert.Equal(t, row.expectedCalls, calls)
+		assert.Len(t, retries, row.expectedCalls-1)
+	}
+
+	assert.NoError(t, NoRetry(nil))
+
+	policy := RetryPolicy{Retries: 5, Backoff: time.Hour}
+
+	// Cancelled while waiting for a retry.
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	start := time.Now()
+	err := policy.DoContext(ctx, func() error {
+		calls++
+		return errTransient
+	}, func(retry uint, delay time.Duration, err error) {
+		cancel()
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+	assert.True(t, time.Since(start) < time.Minute)
+
+	// Cancelled by `fn` itself, its error is returned without retrying.
+	ctx, cancel = context.WithCancel(context.Background())
+	calls = 0
+	err = policy.DoContext(ctx, func() error {
+		calls++
+		cancel()
+		return errTransient
+	}, nil)
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFileNameBytes is the max length of sanitized filenames, in bytes.
+// Most filesystems allow 255 bytes, some room is left for extensions and temporary suffixes.
+const MaxFileNameBytes = 200
+
+// illegalFileNameChars are characters not allowed in filenames on some platforms.
+// `:` is mostly seen in timestamps, so it's replaced with `-` for readability.
+var illegalFileNameChars = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", "-", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_",
+)
+
+// windowsReservedNames are device names which can't be used as filenames on Windows, even with an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName makes `name` safe to be used as a single path component on all platforms.
+// Path separators and other illegal characters are replaced, control characters are removed,
+// the name is truncated to `MaxFileNameBytes` on UTF-8 boundary, and trailing dots / spaces are trimmed.
+// Windows reserved names like `CON` are suffixed with `_`. An empty result becomes `_`.
+func SanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = illegalFileNameChars.Replace(name)
+
+	name = TruncateUTF8(strings.TrimSpace(name), MaxFileNameBytes)
+	name = strings.TrimRight(name, ". ")
+
+	// Reserved names are reserved with any extension as well, so the suffix goes right after the base name.
+	fields := strings.SplitN(name, ".", 2)
+	if windowsReservedNames[strings.ToUpper(strings.TrimSpace(fields[0]))] {
+		fields[0] += "_"
+		name = strings.Join(fields, ".")
+	}
+
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// TruncateUTF8 truncates `s` to at most `maxBytes` bytes, without breaking any UTF-8 encoded character.
+func TruncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
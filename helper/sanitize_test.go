@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	testData := map[string]string{
+		"正常的标题":                    "正常的标题",
+		"a/b\\c":                   "a_b_c",
+		"what?*<>|\"":              "what______",
+		"2021-03-01 12:34:56":      "2021-03-01 12-34-56",
+		"tab\there\nnewline\x7f":   "tabherenewline",
+		"  spaces around  ":        "spaces around",
+		"trailing dots...":         "trailing dots",
+		"trailing dot and space. ": "trailing dot and space",
+		".":                        "_",
+		"..":                       "_",
+		"../../etc/passwd":         ".._.._etc_passwd",
+		"":                         "_",
+		"\x00\x01":                 "_",
+		"CON":                      "CON_",
+		"con.txt":                  "con_.txt",
+		"Com1":                     "Com1_",
+		"LPT9.mp4":                 "LPT9_.mp4",
+		"CONSOLE":                  "CONSOLE",
+		"NUL-1":                    "NUL-1",
+		"invalid\xffutf8":          "invalidutf8",
+	}
+
+	for source, expected := range testData {
+		assert.Equal(t, expected, SanitizeFileName(source), source)
+	}
+}
+
+func TestSanitizeFileName_Length(t *testing.T) {
+	testData := []string{
+		strings.Repeat("a", 300),
+		strings.Repeat("直播", 100),
+		"x" + strings.Repeat("直播", 100),
+		strings.Repeat("🎮", 80),
+	}
+
+	for _, source := range testData {
+		sanitized := SanitizeFileName(source)
+		assert.LessOrEqual(t, len(sanitized), MaxFileNameBytes)
+		assert.Greater(t, len(sanitized), MaxFileNameBytes-4)
+		assert.True(t, utf8.ValidString(sanitized))
+		assert.True(t, strings.HasPrefix(source, sanitized))
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	type testRow struct {
+		source   string
+		maxBytes int
+		expected string
+	}
+
+	testData := []testRow{
+		{"abc", 5, "abc"},
+		{"abc", 3, "abc"},
+		{"abc", 2, "ab"},
+		{"直播", 3, "直"},
+		{"直播", 5, "直"},
+		{"直播", 2, ""},
+		{"a直播", 4, "a直"},
+		{"", 0, ""},
+	}
+
+	for _, row := range testData {
+		assert.Equal(t, row.expected, TruncateUTF8(row.source, row.maxBytes), row.source)
+	}
+}
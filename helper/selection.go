@@ -0,0 +1,161 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParsePartSelection parses selection of parts, `total` is the number of parts available.
+// Selection is comma separated tokens, each of which can be:
+//   - `all`: all parts
+//   - `3`: a single part
+//   - `1-5`, `10-`, `-3`: a range of parts, open ends mean the first / last part
+//   - `first`, `last`, `first3`, `last2`: the first / last N parts (1 if omitted)
+//   - any of the above prefixed by `!`: exclude these parts
+//
+// If there are only exclusions, they are excluded from all parts.
+// The returned part numbers are sorted and unique, an error is returned for invalid tokens or part numbers out of range.
+func ParsePartSelection(selection string, total int) ([]int, error) {
+	if total <= 0 {
+		return nil, errors.New("没有可供选择的分段")
+	}
+
+	selected := make(map[int]bool)
+	excluded := make(map[int]bool)
+	var hasInclusion bool
+
+	for _, token := range strings.Split(strings.ToLower(selection), ",") {
+		token = strings.Join(strings.Fields(token), "")
+		if token == "" {
+			continue
+		}
+
+		target := selected
+		if strings.HasPrefix(token, "!") {
+			target = excluded
+			token = token[1:]
+		} else {
+			hasInclusion = true
+		}
+
+		from, to, err := parseSelectionToken(token, total)
+		if err != nil {
+			return nil, err
+		}
+		for i := from; i <= to; i++ {
+			target[i] = true
+		}
+	}
+
+	if !hasInclusion {
+		for i := 1; i <= total; i++ {
+			selected[i] = true
+		}
+	}
+
+	result := make([]int, 0, len(selected))
+	for i := range selected {
+		if !excluded[i] {
+			result = append(result, i)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("没有选择任何分段")
+	}
+
+	sort.Ints(result)
+	return result, nil
+}
+
+// parseSelectionToken parses a single selection token (without `!`) into an inclusive range of part numbers.
+func parseSelectionToken(token string, total int) (from, to int, err error) {
+	invalid := fmt.Errorf("无法识别的分段选择%q", token)
+
+	parsePartNumber := func(str string) (int, error) {
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return 0, invalid
+		}
+		if n < 1 || n > total {
+			return 0, fmt.Errorf("分段%d超出范围，共有%d个分段", n, total)
+		}
+		return n, nil
+	}
+	parseCount := func(str string) (int, error) {
+		if str == "" {
+			return 1, nil
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 {
+			return 0, invalid
+		}
+		if n > total {
+			return 0, fmt.Errorf("选择的分段数%d超出范围，共有%d个分段", n, total)
+		}
+		return n, nil
+	}
+
+	switch {
+	case token == "all":
+		return 1, total, nil
+	case strings.HasPrefix(token, "first"):
+		n, err := parseCount(strings.TrimPrefix(token, "first"))
+		return 1, n, err
+	case strings.HasPrefix(token, "last"):
+		n, err := parseCount(strings.TrimPrefix(token, "last"))
+		return total - n + 1, total, err
+	case strings.Contains(token, "-"):
+		fields := strings.SplitN(token, "-", 2)
+		from, to = 1, total
+		if fields[0] == "" && fields[1] == "" {
+			return 0, 0, invalid
+		}
+		if fields[0] != "" {
+			if from, err = parsePartNumber(fields[0]); err != nil {
+				return 0, 0, err
+			}
+		}
+		if fields[1] != "" {
+			if to, err = parsePartNumber(fields[1]); err != nil {
+				return 0, 0, err
+			}
+		}
+		if from > to {
+			return 0, 0, fmt.Errorf("分段范围%q的起始大于结束", token)
+		}
+		return from, to, nil
+	default:
+		n, err := parsePartNumber(token)
+		return n, n, err
+	}
+}
+
+// IsContiguous tells whether given sorted part numbers are contiguous, i.e. without gaps.
+func IsContiguous(sortedInts []int) bool {
+	for i := 1; i < len(sortedInts); i++ {
+		if sortedInts[i] != sortedInts[i-1]+1 {
+			return false
+		}
+	}
+	return len(sortedInts) > 0
+}
+
+// CheckPartList checks part numbers selected before, e.g. those saved in a job manifest, against `total` parts available.
+// Like ParsePartSelection, an error is returned if there's no part or part numbers are out of range, or not sorted and unique.
+func CheckPartList(list []int, total int) error {
+	if len(list) == 0 {
+		return errors.New("没有选择任何分段")
+	}
+	for i, n := range list {
+		if n < 1 || n > total {
+			return fmt.Errorf("分段%d超出范围，共有%d个分段", n, total)
+		}
+		if i > 0 && n <= list[i-1] {
+			return fmt.Errorf("分段列表%v未排序或有重复", list)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package helper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParsePartSelection(t *testing.T) {
+	type testRow struct {
+		selection string
+		total     int
+		expected  []int // nil for error
+	}
+
+	testData := []testRow{
+		{"all", 3, []int{1, 2, 3}},
+		{"ALL", 3, []int{1, 2, 3}},
+		{"", 3, []int{1, 2, 3}},
+		{"2", 3, []int{2}},
+		{"3, 1", 3, []int{1, 3}},
+		{"1,1,1", 3, []int{1}},
+		{"1-5,8,10-", 12, []int{1, 2, 3, 4, 5, 8, 10, 11, 12}},
+		{"-3", 5, []int{1, 2, 3}},
+		{"2 - 4", 5, []int{2, 3, 4}},
+		{"!3", 5, []int{1, 2, 4, 5}},
+		{"1-5,!3", 8, []int{1, 2, 4, 5}},
+		{"!1-2,!last", 5, []int{3, 4}},
+		{"last", 5, []int{5}},
+		{"first", 5, []int{1}},
+		{"first3", 5, []int{1, 2, 3}},
+		{"last2,first", 5, []int{1, 4, 5}},
+		{"first5", 5, []int{1, 2, 3, 4, 5}},
+		{"0", 3, nil},
+		{"4", 3, nil},
+		{"2-9", 3, nil},
+		{"3-1", 3, nil},
+		{"-", 3, nil},
+		{"abc", 3, nil},
+		{"1,x", 3, nil},
+		{"first0", 3, nil},
+		{"first4", 3, nil},
+		{"lastx", 3, nil},
+		{"!all", 3, nil},
+		{"1.5", 3, nil},
+		{"all", 0, nil},
+	}
+
+	for _, row := range testData {
+		selection, err := ParsePartSelection(row.selection, row.total)
+		if row.expected == nil {
+			assert.Error(t, err, row.selection)
+			continue
+		}
+
+		assert.NoError(t, err, row.selection)
+		assert.Equal(t, row.expected, selection, row.selection)
+	}
+}
+
+func TestIsContiguous(t *testing.T) {
+	testData := map[bool][][]int{
+		true:  {{1}, {1, 2, 3}, {4, 5}},
+		false: {{}, nil, {1, 3}, {1, 2, 4, 5}},
+	}
+
+	for expected, rows := range testData {
+		for _, row := range rows {
+			assert.Equal(t, expected, IsContiguous(row), row)
+		}
+	}
+}
+
+func TestCheckPartList(t *testing.T) {
+	assert.NoError(t, CheckPartList([]int{1}, 1))
+	assert.NoError(t, CheckPartList([]int{1, 3, 4}, 4))
+
+	errorData := [][]int{nil, {}, {0}, {6}, {1, 2, 6}, {2, 1}, {1, 1}}
+	for _, row := range errorData {
+		assert.Error(t, CheckPartList(row, 5), row)
+	}
+	assert.Error(t, CheckPartList([]int{1}, 0))
+}
@@ -0,0 +1,69 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TemplateTimeLayout is the layout of time values in templates, if no layout is specified. It's safe for filenames.
+const TemplateTimeLayout = "2006-01-02 15-04-05"
+
+// ExpandTemplate replaces placeholders in `tpl` with `values`.
+// A placeholder looks like `{name}`, or `{name:layout}` for time values, where `layout` is a Go time layout like `2006-01-02`.
+// Substituted values are passed through `escape` (if not nil), e.g. to remove characters illegal in filenames.
+// An error is returned for unknown placeholders or bad syntax.
+func ExpandTemplate(tpl string, values map[string]interface{}, escape func(string) string) (string, error) {
+	var result strings.Builder
+
+	rest := tpl
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			result.WriteString(rest)
+			break
+		}
+		if rest[open] == '}' {
+			return "", fmt.Errorf("模板%q中有多余的}", tpl)
+		}
+
+		length := strings.IndexAny(rest[open+1:], "{}")
+		if length < 0 || rest[open+1+length] == '{' {
+			return "", fmt.Errorf("模板%q中的{没有闭合", tpl)
+		}
+
+		result.WriteString(rest[:open])
+		placeholder := rest[open+1 : open+1+length]
+		rest = rest[open+1+length+1:]
+
+		name, layout := placeholder, ""
+		if idx := strings.Index(placeholder, ":"); idx >= 0 {
+			name, layout = placeholder[:idx], placeholder[idx+1:]
+		}
+		value, ok := values[name]
+		if !ok {
+			return "", fmt.Errorf("模板%q中有未知的占位符{%s}", tpl, name)
+		}
+
+		var str string
+		switch v := value.(type) {
+		case time.Time:
+			if layout == "" {
+				layout = TemplateTimeLayout
+			}
+			str = v.Format(layout)
+		default:
+			if layout != "" {
+				return "", fmt.Errorf("模板%q中的占位符{%s}不支持格式", tpl, name)
+			}
+			str = fmt.Sprint(v)
+		}
+
+		if escape != nil {
+			str = escape(str)
+		}
+		result.WriteString(str)
+	}
+
+	return result.String(), nil
+}
@@ -0,0 +1,49 @@
+package helper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	values := map[string]interface{}{
+		"uid":   int64(12345),
+		"title": "a/b",
+		"start": time.Date(2021, 03, 01, 02, 33, 45, 0, time.UTC),
+	}
+	escape := func(s string) string {
+		return strings.ReplaceAll(s, "/", "_")
+	}
+
+	testData := map[string]string{
+		"":                               "",
+		"plain":                          "plain",
+		"{uid}":                          "12345",
+		"{uid}-{title}":                  "12345-a_b",
+		"{uid}/{start}":                  "12345/2021-03-01 02-33-45",
+		"{start:2006-01-02}/{title}.mp4": "2021-03-01/a_b.mp4",
+		"{start:01/02}":                  "03_01", // Escaped as well
+		"{unknown}":                      "error", // placeholder for error
+		"{uid":                           "error",
+		"uid}":                           "error",
+		"{{uid}}":                        "error",
+		"{uid:2006}":                     "error",
+	}
+
+	for tpl, expected := range testData {
+		result, err := ExpandTemplate(tpl, values, escape)
+		if expected == "error" {
+			assert.Error(t, err, tpl)
+			continue
+		}
+
+		assert.NoError(t, err, tpl)
+		assert.Equal(t, expected, result, tpl)
+	}
+
+	result, err := ExpandTemplate("{title}", values, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b", result)
+}
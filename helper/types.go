@@ -25,6 +25,11 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes the duration in `ms`, same as what UnmarshalJSON accepts.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Milliseconds())
+}
+
 func (d Duration) String() string {
 	return d.Duration.Round(time.Millisecond * 100).String()
 }
@@ -44,6 +49,11 @@ func (s *Size) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes the size in bytes, same as what UnmarshalJSON accepts.
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Bytes())
+}
+
 func (s Size) String() string {
 	return s.HumanReadable()
 }
@@ -62,6 +72,11 @@ func (t *JSONTime) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes the time as integer timestamp, same as what UnmarshalJSON accepts.
+func (t JSONTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Unix())
+}
+
 // String implements the `Stringer` interface in std libs.
 // This is just for string representation. It might not work well for filenames or part of a file path.
 func (t JSONTime) String() string {
@@ -138,3 +138,26 @@ func TestJSONTime_UnmarshalJSON(t *testing.T) {
 		assert.True(t, expectedTime.Equal(tDestination.Time.Time))
 	}
 }
+
+func TestMarshalJSON(t *testing.T) {
+	type testWrapper struct {
+		Length Duration `json:"length"`
+		Size   Size     `json:"size"`
+		Time   JSONTime `json:"time"`
+	}
+
+	source := testWrapper{
+		Length: Duration{time.Millisecond * 7384001},
+		Size:   Size{datasize.ByteSize(12743862523)},
+		Time:   JSONTime{time.Date(2021, 03, 27, 14, 26, 59, 0, time.UTC)},
+	}
+	encoded, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"length": 7384001, "size": 12743862523, "time": 1616855219}`, string(encoded))
+
+	var decoded testWrapper
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, source.Length, decoded.Length)
+	assert.Equal(t, source.Size, decoded.Size)
+	assert.True(t, source.Time.Equal(decoded.Time.Time))
+}
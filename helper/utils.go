@@ -1,10 +1,30 @@
 package helper
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PartialFileSuffix is appended to names of files being written, they're renamed to their final names once complete.
+// This way a file under its final name is always a complete one.
+const PartialFileSuffix = ".part"
+
+// PartialFilePath returns where given file should be written before it's complete.
+func PartialFilePath(filePath string) string {
+	return filePath + PartialFileSuffix
+}
 
 func IsTTY() bool {
-	info, _ := os.Stdout.Stat()
-	return info.Mode()&os.ModeCharDevice != 0
+	return IsTerminal(os.Stdout)
+}
+
+// IsTerminal tells whether `f` is connected to a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 // ContainsInt performs simple `contain` operation on int slice.
@@ -16,3 +36,60 @@ func ContainsInt(ints []int, i int) bool {
 	}
 	return false
 }
+
+// ContainsString performs simple `contain` operation on string slice.
+func ContainsString(strs []string, s string) bool {
+	for _, v := range strs {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// FileFingerprint identifies content of given file by its size and modification time, without reading it.
+// It's a cheap way to tell whether a (possibly huge) file has been changed since last seen.
+func FileFingerprint(filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// ParseTimeOffset parses time offset like `01:23:00`, `23:00.5`, `90` (seconds) or `1h23m` into a `time.Duration`.
+func ParseTimeOffset(str string) (time.Duration, error) {
+	str = strings.TrimSpace(str)
+	if d, err := time.ParseDuration(str); err == nil && strings.ContainsAny(str, "hms") {
+		if d < 0 {
+			return 0, fmt.Errorf("时间偏移%s不能为负数", str)
+		}
+		return d, nil
+	}
+
+	fields := strings.Split(str, ":")
+	if len(fields) > 3 {
+		return 0, fmt.Errorf("无法识别的时间偏移%s", str)
+	}
+
+	var offset time.Duration
+	for i, field := range fields {
+		isSeconds := i == len(fields)-1
+		var value float64
+		var err error
+		if isSeconds {
+			value, err = strconv.ParseFloat(field, 64)
+		} else {
+			var v uint64
+			v, err = strconv.ParseUint(field, 10, 32)
+			value = float64(v)
+		}
+		if err != nil || value < 0 || (i > 0 && value >= 60) {
+			return 0, fmt.Errorf("无法识别的时间偏移%s", str)
+		}
+
+		// Each field is in unit of the next field, i.e. hours -> minutes -> seconds.
+		offset = offset*60 + time.Duration(value*float64(time.Second))
+	}
+	return offset, nil
+}
@@ -1,10 +1,29 @@
 package helper
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
+func TestPartialFilePath(t *testing.T) {
+	testData := map[string]string{
+		"1.flv":                "1.flv.part",
+		"/record/1.ts":         "/record/1.ts.part",
+		"complete.mp4":         "complete.mp4.part",
+		"播放列表.m3u8":            "播放列表.m3u8.part",
+		"already.part":         "already.part.part",
+		"dir.with.dots/no_ext": "dir.with.dots/no_ext.part",
+	}
+	for filePath, expected := range testData {
+		assert.Equal(t, expected, PartialFilePath(filePath), filePath)
+	}
+}
+
 func TestContainsInt(t *testing.T) {
 	type testRow struct {
 		set              []int
@@ -23,3 +42,83 @@ func TestContainsInt(t *testing.T) {
 		assert.Equal(t, row.expectedContains, ContainsInt(row.set, row.test))
 	}
 }
+
+func TestContainsString(t *testing.T) {
+	type testRow struct {
+		set              []string
+		test             string
+		expectedContains bool
+	}
+
+	testData := []testRow{
+		{[]string{"a", "b", "c"}, "a", true},
+		{[]string{}, "", false},
+		{nil, "a", false},
+		{[]string{"R1", "R2", "R2"}, "R3", false},
+	}
+
+	for _, row := range testData {
+		assert.Equal(t, row.expectedContains, ContainsString(row.set, row.test))
+	}
+}
+
+func TestFileFingerprint(t *testing.T) {
+	f, err := ioutil.TempFile("", "fingerprint")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("bililive")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	modTime := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(f.Name(), modTime, modTime))
+
+	fingerprint, err := FileFingerprint(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("8-%d", modTime.UnixNano()), fingerprint)
+
+	// Changes of content or modification time are both noticed.
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("bilibili"), 0644))
+	require.NoError(t, os.Chtimes(f.Name(), modTime, modTime))
+	changed, err := FileFingerprint(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprint, changed, "same size and modification time")
+	require.NoError(t, os.Chtimes(f.Name(), modTime, modTime.Add(time.Second)))
+	changed, err = FileFingerprint(f.Name())
+	assert.NoError(t, err)
+	assert.NotEqual(t, fingerprint, changed)
+
+	_, err = FileFingerprint(f.Name() + ".missing")
+	assert.Error(t, err)
+}
+
+func TestParseTimeOffset(t *testing.T) {
+	testData := map[string]time.Duration{
+		"01:23:00":   time.Hour + time.Minute*23,
+		"1:02:03.5":  time.Hour + time.Minute*2 + time.Millisecond*3500,
+		"23:00":      time.Minute * 23,
+		"90":         time.Second * 90,
+		"0":          0,
+		" 1h23m ":    time.Hour + time.Minute*23,
+		"45s":        time.Second * 45,
+		"1:60:00":    -1, // placeholder for error
+		"1:2:3:4":    -1,
+		"-1:00":      -1,
+		"-5m":        -1,
+		"abc":        -1,
+		"":           -1,
+		"12:xx":      -1,
+		"2021-03-01": -1,
+	}
+
+	for source, expected := range testData {
+		offset, err := ParseTimeOffset(source)
+		if expected < 0 {
+			assert.Error(t, err, source)
+			continue
+		}
+
+		assert.NoError(t, err, source)
+		assert.Equal(t, expected, offset, source)
+	}
+}
@@ -1,37 +1,98 @@
 package main
 
 import (
+	"bililive-downloader/helper"
 	"bililive-downloader/models"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
-// getApi performs GET request and returns `.data` field of the API response.
-func getApi(url string) (*json.RawMessage, error) {
-	timeout, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-	var buf bytes.Buffer
+const (
+	// defaultApiBaseURL is where bilibili live API lives.
+	defaultApiBaseURL = "https://api.live.bilibili.com"
+	// defaultApiTimeout limits how long a single API request attempt can take.
+	defaultApiTimeout = time.Second * 30
+)
+
+// BiliClient is a client of bilibili live API.
+type BiliClient struct {
+	BaseURL    string             // Base URL of the API, without trailing slash
+	HTTPClient *http.Client       // Client used to send requests
+	Header     http.Header        // Headers sent with every request
+	Cookies    helper.Cookies     // Cookies sent with every request, for authentication
+	Retry      helper.RetryPolicy // Retry policy of failed requests
+	Timeout    time.Duration      // Timeout of each request attempt, 0 means no timeout
+}
+
+// NewBiliClient creates a client of the real bilibili API with default settings.
+func NewBiliClient() *BiliClient {
+	return &BiliClient{
+		BaseURL:    defaultApiBaseURL,
+		HTTPClient: http.DefaultClient,
+		Header:     http.Header{UaKey: []string{UserAgent}},
+		Retry:      helper.RetryPolicy{Retries: defaultRetries, Backoff: defaultRetryBackoff},
+		Timeout:    defaultApiTimeout,
+	}
+}
+
+// requestCookies are sent with media downloads to make them authenticated.
+var requestCookies helper.Cookies
+
+// cdnClient is the HTTP client used for media downloads.
+var cdnClient = http.DefaultClient
 
-	riReq, err := http.NewRequestWithContext(timeout, http.MethodGet, url, &buf)
+// getApi performs GET request to `path` of the API and returns `.data` field of the API response.
+// Failed requests are retried according to `b.Retry`.
+func (b *BiliClient) getApi(ctx context.Context, path string, query url.Values) (*json.RawMessage, error) {
+	apiUrl := b.BaseURL + path
+	if len(query) > 0 {
+		apiUrl += "?" + query.Encode()
+	}
+
+	var data *json.RawMessage
+	err := b.Retry.DoContext(ctx, func() (err error) {
+		data, err = b.getApiOnce(ctx, apiUrl)
+		return
+	}, func(retry uint, delay time.Duration, err error) {
+		logger.Warn().Err(err).Str("URL", apiUrl).Uint("重试次数", retry).Dur("等待时间", delay).Msg("API请求出错，稍后重试")
+	})
+	return data, err
+}
+
+// getApiOnce performs a single GET request and returns `.data` field of the API response.
+func (b *BiliClient) getApiOnce(ctx context.Context, apiUrl string) (*json.RawMessage, error) {
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+
+	riReq, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
 	if err != nil {
-		return nil, err
+		return nil, helper.NoRetry(err)
 	}
 
-	riReq.Header = http.Header{
-		UaKey: []string{UserAgent},
+	riReq.Header = b.Header.Clone()
+	if riReq.Header == nil {
+		riReq.Header = http.Header{}
 	}
-	resp, err := http.DefaultClient.Do(riReq)
+	b.Cookies.AddTo(riReq)
+	resp, err := b.HTTPClient.Do(riReq)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP状态码=%d", resp.StatusCode)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -42,16 +103,17 @@ func getApi(url string) (*json.RawMessage, error) {
 		return nil, err
 	}
 
+	// The API is reachable but refuses our request, retrying won't help.
 	if apiResp.Code != 0 {
-		return nil, fmt.Errorf("响应码=%d，响应消息=%v\n", apiResp.Code, apiResp.Message)
+		return nil, helper.NoRetry(fmt.Errorf("响应码=%d，响应消息=%v\n", apiResp.Code, apiResp.Message))
 	}
 
 	return &apiResp.Data, nil
 }
 
 // fetchRecordInfo fetches info about given livestream recording (title & start / end time) from bilibili API.
-func fetchRecordInfo(recordId string) (*models.LiveRecordInfo, error) {
-	data, err := getApi(fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v1/record/getInfoByLiveRecord?rid=%s", recordId))
+func (b *BiliClient) fetchRecordInfo(ctx context.Context, recordId string) (*models.LiveRecordInfo, error) {
+	data, err := b.getApi(ctx, "/xlive/web-room/v1/record/getInfoByLiveRecord", url.Values{"rid": {recordId}})
 	if err != nil {
 		return nil, err
 	}
@@ -62,8 +124,13 @@ func fetchRecordInfo(recordId string) (*models.LiveRecordInfo, error) {
 }
 
 // fetchRecordParts fetches record parts list from bilibili API.
-func fetchRecordParts(recordId string) (*models.RecordParts, error) {
-	data, err := getApi(fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v1/record/getLiveRecordUrl?rid=%s&platform=html5", recordId))
+// `qn` is the number of requested quality, pass 0 to use default quality.
+func (b *BiliClient) fetchRecordParts(ctx context.Context, recordId string, qn uint64) (*models.RecordParts, error) {
+	query := url.Values{"rid": {recordId}, "platform": {"html5"}}
+	if qn != 0 {
+		query.Set("qn", strconv.FormatUint(qn, 10))
+	}
+	data, err := b.getApi(ctx, "/xlive/web-room/v1/record/getLiveRecordUrl", query)
 	if err != nil {
 		return nil, err
 	}
@@ -74,8 +141,8 @@ func fetchRecordParts(recordId string) (*models.RecordParts, error) {
 }
 
 // fetchLiverInfo fetches info of the liver (owner of given room).
-func fetchLiverInfo(roomId int64) (*models.LiverInfo, error) {
-	data, err := getApi(fmt.Sprintf("https://api.live.bilibili.com/live_user/v1/UserInfo/get_anchor_in_room?roomid=%d", roomId))
+func (b *BiliClient) fetchLiverInfo(ctx context.Context, roomId int64) (*models.LiverInfo, error) {
+	data, err := b.getApi(ctx, "/live_user/v1/UserInfo/get_anchor_in_room", url.Values{"roomid": {strconv.FormatInt(roomId, 10)}})
 	if err != nil {
 		return nil, err
 	}
@@ -84,3 +151,38 @@ func fetchLiverInfo(roomId int64) (*models.LiverInfo, error) {
 	err = json.Unmarshal(*data, &wrapper)
 	return &wrapper.Info, err
 }
+
+// fetchRecordList fetches a page of livestream records of given room, latest records come first.
+// `page` starts from 1.
+func (b *BiliClient) fetchRecordList(ctx context.Context, roomId int64, page, pageSize int) (*models.RecordList, error) {
+	query := url.Values{
+		"room_id":   {strconv.FormatInt(roomId, 10)},
+		"page":      {strconv.Itoa(page)},
+		"page_size": {strconv.Itoa(pageSize)},
+	}
+	data, err := b.getApi(ctx, "/xlive/web-room/v1/record/getList", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var list models.RecordList
+	err = json.Unmarshal(*data, &list)
+	return &list, err
+}
+
+// fetchRoomIDByUser fetches ID of the livestream room owned by given user.
+func (b *BiliClient) fetchRoomIDByUser(ctx context.Context, uid int64) (int64, error) {
+	data, err := b.getApi(ctx, "/room/v1/Room/getRoomInfoOld", url.Values{"mid": {strconv.FormatInt(uid, 10)}})
+	if err != nil {
+		return 0, err
+	}
+
+	var info models.UserRoomInfo
+	if err := json.Unmarshal(*data, &info); err != nil {
+		return 0, err
+	}
+	if info.RoomID == 0 {
+		return 0, fmt.Errorf("用户%d没有直播间", uid)
+	}
+	return info.RoomID, nil
+}
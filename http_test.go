@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeApiResponses are responses of the fake API server, keyed by request path.
+var fakeApiResponses = map[string]string{
+	"/xlive/web-room/v1/record/getInfoByLiveRecord": `{"live_record_info":{"rid":"R1","room_id":100,"uid":200,"title":"测试","start_timestamp":1614567600,"end_timestamp":1614571200}}`,
+	"/live_user/v1/UserInfo/get_anchor_in_room":     `{"info":{"uid":200,"uname":"主播","face":"https://example.com/face.jpg"}}`,
+	"/xlive/web-room/v1/record/getLiveRecordUrl":    `{"list":[{"url":"https://cdn.example.com/1.flv","size":1024,"length":600000}],"size":1024,"length":600000,"current_qn":250,"qn_desc":[{"qn":250,"desc":"超清"},{"qn":10000,"desc":"原画"}]}`,
+	"/xlive/web-room/v1/record/getList":             `{"count":1,"list":[{"rid":"R1","room_id":100,"uid":200,"title":"测试","start_timestamp":1614567600,"end_timestamp":1614571200}]}`,
+	"/room/v1/Room/getRoomInfoOld":                  `{"roomid":100}`,
+}
+
+// newFakeApiServer starts a fake bilibili API server, and returns a client talking to it.
+// `handler` can inspect the request and return true to take over the response.
+func newFakeApiServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request) bool) *BiliClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler != nil && handler(w, r) {
+			return
+		}
+		data, ok := fakeApiResponses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"code":0,"message":"0","ttl":1,"data":%s}`, data)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewBiliClient()
+	client.BaseURL = server.URL
+	client.HTTPClient = server.Client()
+	client.Retry = helper.RetryPolicy{Retries: 2, Backoff: time.Millisecond}
+	return client
+}
+
+func TestBiliClient_Fetch(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		assert.Equal(t, UserAgent, r.Header.Get(UaKey))
+		assert.Equal(t, "SESSDATA=secret", r.Header.Get("Cookie"))
+		return false
+	})
+	client.Cookies = helper.Cookies{{Name: "SESSDATA", Value: "secret"}}
+
+	info, err := client.fetchRecordInfo(ctx, "R1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "R1", info.ID)
+		assert.Equal(t, int64(100), info.RoomID)
+		assert.Equal(t, "测试", info.Title)
+		assert.Equal(t, int64(1614567600), info.Start.Unix())
+	}
+
+	liver, err := client.fetchLiverInfo(ctx, 100)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "主播", liver.UserName)
+	}
+
+	parts, err := client.fetchRecordParts(ctx, "R1", 0)
+	if assert.NoError(t, err) {
+		assert.Len(t, parts.List, 1)
+		assert.Equal(t, "超清", parts.Quality())
+	}
+
+	list, err := client.fetchRecordList(ctx, 100, 1, 20)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), list.Count)
+		assert.Equal(t, "R1", list.List[0].ID)
+	}
+
+	roomID, err := client.fetchRoomIDByUser(ctx, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), roomID)
+}
+
+func TestBiliClient_Query(t *testing.T) {
+	client := newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/xlive/web-room/v1/record/getLiveRecordUrl":
+			assert.Equal(t, "R1", query.Get("rid"))
+			assert.Equal(t, "html5", query.Get("platform"))
+			assert.Equal(t, "10000", query.Get("qn"))
+		case "/xlive/web-room/v1/record/getList":
+			assert.Equal(t, "100", query.Get("room_id"))
+			assert.Equal(t, "3", query.Get("page"))
+			assert.Equal(t, "20", query.Get("page_size"))
+		}
+		return false
+	})
+
+	_, err := client.fetchRecordParts(context.Background(), "R1", 10000)
+	assert.NoError(t, err)
+	_, err = client.fetchRecordList(context.Background(), 100, 3, 20)
+	assert.NoError(t, err)
+}
+
+func TestBiliClient_Retry(t *testing.T) {
+	var calls int32
+	client := newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		// Fail the first 2 attempts.
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return true
+		}
+		return false
+	})
+
+	_, err := client.fetchRecordInfo(context.Background(), "R1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// Never succeeds within retries.
+	atomic.StoreInt32(&calls, -10)
+	_, err = client.fetchRecordInfo(context.Background(), "R1")
+	assert.Error(t, err)
+	assert.Equal(t, int32(-7), atomic.LoadInt32(&calls))
+}
+
+func TestBiliClient_ApiError(t *testing.T) {
+	var calls int32
+	client := newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		atomic.AddInt32(&calls, 1)
+		_, _ = fmt.Fprint(w, `{"code":-404,"message":"啥都木有","ttl":1,"data":null}`)
+		return true
+	})
+
+	_, err := client.fetchRecordInfo(context.Background(), "R1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "-404")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "API errors should not be retried")
+}
+
+func TestBiliClient_ContextCancelled(t *testing.T) {
+	client := newFakeApiServer(t, nil)
+	client.Retry = helper.RetryPolicy{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.fetchRecordInfo(ctx, "R1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLoadRecordMeta(t *testing.T) {
+	client := newFakeApiServer(t, nil)
+
+	param := DownloadParam{RecordID: "R1", Quality: "原画"}
+	if !assert.NoError(t, loadRecordMeta(context.Background(), client, &param)) {
+		return
+	}
+	assert.Equal(t, "测试", param.Info.Title)
+	assert.Equal(t, "主播", param.Liver.UserName)
+	// The fake server ignores qn, so the default quality is kept.
+	assert.Equal(t, uint64(250), param.Parts.CurrentQualityNumber)
+
+	param = DownloadParam{RecordID: "R1"}
+	client.BaseURL += "/nowhere"
+	assert.Error(t, loadRecordMeta(context.Background(), client, &param))
+}
+
+func TestApiClientFromFlags(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(configFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// runCommand runs `args` with the real flags, and returns the client created for the action.
+	runCommand := func(args ...string) *BiliClient {
+		var captured *BiliClient
+		app := newCliApp()
+		for _, command := range app.Commands {
+			command.Action = wrapAction(func(c *cli.Context, client *BiliClient) error {
+				captured = client
+				return nil
+			})
+		}
+		app.Writer, app.ErrWriter = ioutil.Discard, ioutil.Discard
+		assert.NoError(t, app.Run(append([]string{"bililive-downloader", "--config", configFile}, args...)))
+		return captured
+	}
+
+	client := runCommand("download", "--retries", "1", "--retry-backoff", "5s", "--cookies", "abc")
+	if assert.NotNil(t, client) {
+		assert.Equal(t, helper.RetryPolicy{Retries: 1, Backoff: 5 * time.Second}, client.Retry)
+		assert.NotEmpty(t, client.Cookies)
+	}
+
+	// Commands without retry options keep the default policy.
+	client = runCommand("info", "--record", "R1")
+	if assert.NotNil(t, client) {
+		assert.Equal(t, NewBiliClient().Retry, client.Retry)
+		assert.Empty(t, client.Cookies)
+	}
+
+	// Each command gets a client of its own.
+	assert.NotSame(t, client, runCommand("info", "--record", "R1"))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// partTimeRange is the time range of a record part in the livestream.
+type partTimeRange struct {
+	Start helper.JSONTime
+	End   helper.JSONTime
+}
+
+// partTimeRanges computes time range of each part of given record.
+// Start of a part is parsed from its filename, if that fails, it's assumed to follow the previous part.
+func partTimeRanges(info *models.LiveRecordInfo, parts *models.RecordParts) []partTimeRange {
+	ranges := make([]partTimeRange, 0, len(parts.List))
+
+	partStart := info.Start
+	for _, v := range parts.List {
+		// Parse part start from filename
+		fields := strings.SplitN(strings.SplitN(v.FileName(), ".", 2)[0], "-", 2)
+		fileStartTimeStr := fields[len(fields)-1]
+		start, err := time.ParseInLocation("2006-01-02-15-04-05", fileStartTimeStr, timezone)
+		if err == nil {
+			partStart = helper.JSONTime{Time: start}
+		}
+		partEnd := helper.JSONTime{Time: partStart.Add(v.Length.Duration)}
+		ranges = append(ranges, partTimeRange{Start: partStart, End: partEnd})
+		partStart = partEnd
+	}
+
+	return ranges
+}
+
+type qualityDetail struct {
+	Number  uint64 `json:"qn" yaml:"qn"`
+	Name    string `json:"name" yaml:"name"`
+	Current bool   `json:"current" yaml:"current"`
+}
+
+type partDetail struct {
+	Number        int       `json:"number" yaml:"number"`
+	FileName      string    `json:"file_name" yaml:"file_name"`
+	Start         time.Time `json:"start" yaml:"start"`
+	End           time.Time `json:"end" yaml:"end"`
+	LengthSeconds float64   `json:"length_seconds" yaml:"length_seconds"`
+	SizeBytes     uint64    `json:"size_bytes" yaml:"size_bytes"`
+}
+
+// recordDetail is the output of `info` subcommand.
+type recordDetail struct {
+	RecordID      string          `json:"rid" yaml:"rid"`
+	Title         string          `json:"title" yaml:"title"`
+	RoomID        int64           `json:"room_id" yaml:"room_id"`
+	UserID        int64           `json:"uid" yaml:"uid"`
+	UserName      string          `json:"uname" yaml:"uname"`
+	Start         time.Time       `json:"start" yaml:"start"`
+	End           time.Time       `json:"end" yaml:"end"`
+	LengthSeconds float64         `json:"length_seconds" yaml:"length_seconds"`
+	SizeBytes     uint64          `json:"size_bytes" yaml:"size_bytes"`
+	Qualities     []qualityDetail `json:"qualities" yaml:"qualities"`
+	Parts         []partDetail    `json:"parts" yaml:"parts"`
+}
+
+// newRecordDetail combines metadata loaded into `p` together.
+func newRecordDetail(p *DownloadParam) recordDetail {
+	detail := recordDetail{
+		RecordID:      p.RecordID,
+		Title:         p.Info.Title,
+		RoomID:        p.Info.RoomID,
+		UserID:        p.Liver.UserID,
+		UserName:      p.Liver.UserName,
+		Start:         p.Info.Start.In(timezone),
+		End:           p.Info.End.In(timezone),
+		LengthSeconds: p.Parts.Length.Seconds(),
+		SizeBytes:     p.Parts.Size.Bytes(),
+		Qualities:     make([]qualityDetail, 0, len(p.Parts.Qualities)),
+		Parts:         make([]partDetail, 0, len(p.Parts.List)),
+	}
+
+	for _, q := range p.Parts.Qualities {
+		detail.Qualities = append(detail.Qualities, qualityDetail{Number: q.Number, Name: q.Name, Current: q.Number == p.Parts.CurrentQualityNumber})
+	}
+	ranges := partTimeRanges(p.Info, p.Parts)
+	for i, part := range p.Parts.List {
+		detail.Parts = append(detail.Parts, partDetail{
+			Number:        i + 1,
+			FileName:      part.FileName(),
+			Start:         ranges[i].Start.In(timezone),
+			End:           ranges[i].End.In(timezone),
+			LengthSeconds: part.Length.Seconds(),
+			SizeBytes:     part.Size.Bytes(),
+		})
+	}
+
+	return detail
+}
+
+// handleInfoAction handles `info` subcommand. The only error it might return is cli.Exit.
+func handleInfoAction(c *cli.Context, client *BiliClient) error {
+	format := c.String("format")
+	if format != "table" && format != "json" && format != "yaml" {
+		return cli.Exit(fmt.Sprintf("不支持的输出格式%s", format), returnCodeError)
+	}
+
+	var param DownloadParam
+	var err error
+	if param.RecordID, err = extractRecordID(c.String("record")); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if err := loadRecordMeta(c.Context, client, &param); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+
+	detail := newRecordDetail(&param)
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(c.App.Writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(detail)
+	case "yaml":
+		encoder := yaml.NewEncoder(c.App.Writer)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(detail); err == nil {
+			err = encoder.Close()
+		}
+	default:
+		qualities := make([]string, 0, len(detail.Qualities))
+		for _, q := range detail.Qualities {
+			if q.Current {
+				qualities = append(qualities, fmt.Sprintf("%s(%d，当前)", q.Name, q.Number))
+			} else {
+				qualities = append(qualities, fmt.Sprintf("%s(%d)", q.Name, q.Number))
+			}
+		}
+
+		w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "回放ID：\t%s\n", param.RecordID)
+		fmt.Fprintf(w, "标题：\t%s\n", param.Info.Title)
+		fmt.Fprintf(w, "直播间ID：\t%d\n", param.Info.RoomID)
+		fmt.Fprintf(w, "主播：\t%s(UID:%d)\n", param.Liver.UserName, param.Liver.UserID)
+		fmt.Fprintf(w, "直播时间：\t%s ~ %s\n", param.Info.Start, param.Info.End)
+		fmt.Fprintf(w, "时长：\t%s\n", param.Parts.Length)
+		fmt.Fprintf(w, "大小：\t%s\n", param.Parts.Size)
+		fmt.Fprintf(w, "画质：\t%s\n", strings.Join(qualities, "，"))
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "序号\t文件名\t时长\t大小\t开始于\t结束于")
+		ranges := partTimeRanges(param.Info, param.Parts)
+		for i, part := range param.Parts.List {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i+1, part.FileName(), part.Length, part.Size, ranges[i].Start, ranges[i].End)
+		}
+		err = w.Flush()
+	}
+
+	if err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+	"testing"
+	"time"
+)
+
+func TestNewRecordDetail(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, timezone)
+	// clock returns the time of given hour and minute on the day of the record.
+	clock := func(hour, minute int) time.Time {
+		return time.Date(2021, 3, 1, hour, minute, 0, 0, timezone)
+	}
+
+	testData := []struct {
+		name     string
+		files    []string
+		lengths  []time.Duration
+		expected [][2]time.Time
+	}{
+		{
+			name:     "no part",
+			expected: [][2]time.Time{},
+		},
+		{
+			name:     "parts follow each other",
+			files:    []string{"1.flv", "2.flv"},
+			lengths:  []time.Duration{time.Hour, 30 * time.Minute},
+			expected: [][2]time.Time{{clock(12, 0), clock(13, 0)}, {clock(13, 0), clock(13, 30)}},
+		},
+		{
+			name:     "start times in file names",
+			files:    []string{"R1-2021-03-01-12-10-00.flv", "R1-2021-03-01-14-00-00.flv"},
+			lengths:  []time.Duration{time.Hour, time.Hour},
+			expected: [][2]time.Time{{clock(12, 10), clock(13, 10)}, {clock(14, 0), clock(15, 0)}},
+		},
+		{
+			name:     "mixed",
+			files:    []string{"1.flv", "R1-2021-03-01-13-30-00.flv", "3.flv", "bad-2021-13-01-00-00-00.flv"},
+			lengths:  []time.Duration{time.Hour, time.Hour, 10 * time.Minute, 5 * time.Minute},
+			expected: [][2]time.Time{{clock(12, 0), clock(13, 0)}, {clock(13, 30), clock(14, 30)}, {clock(14, 30), clock(14, 40)}, {clock(14, 40), clock(14, 45)}},
+		},
+	}
+
+	for _, row := range testData {
+		param := DownloadParam{
+			RecordID: "R1",
+			Info:     &models.LiveRecordInfo{ID: "R1", Title: "测试", RoomID: 100, Start: helper.JSONTime{Time: start}, End: helper.JSONTime{Time: start.Add(3 * time.Hour)}},
+			Liver:    &models.LiverInfo{UserID: 200, UserName: "主播"},
+			Parts:    &models.RecordParts{CurrentQualityNumber: 250, Qualities: []models.Quality{{Number: 250, Name: "超清"}, {Number: 10000, Name: "原画"}}},
+		}
+		for i, file := range row.files {
+			param.Parts.List = append(param.Parts.List, models.RecordPart{Url: "https://cdn.example.com/" + file, Length: helper.Duration{Duration: row.lengths[i]}, Size: helper.Size{ByteSize: 1024}})
+		}
+
+		detail := newRecordDetail(&param)
+		assert.Equal(t, "R1", detail.RecordID, row.name)
+		assert.Equal(t, "主播", detail.UserName, row.name)
+		assert.Equal(t, []qualityDetail{{Number: 250, Name: "超清", Current: true}, {Number: 10000, Name: "原画"}}, detail.Qualities, row.name)
+		if !assert.Len(t, detail.Parts, len(row.expected), row.name) {
+			continue
+		}
+		for i, part := range detail.Parts {
+			assert.Equal(t, i+1, part.Number, row.name)
+			assert.Equal(t, row.files[i], part.FileName, row.name)
+			assert.True(t, row.expected[i][0].Equal(part.Start), "%s: part %d starts at %s", row.name, i+1, part.Start)
+			assert.True(t, row.expected[i][1].Equal(part.End), "%s: part %d ends at %s", row.name, i+1, part.End)
+			assert.Equal(t, timezone, part.Start.Location(), row.name)
+			assert.Equal(t, row.lengths[i].Seconds(), part.LengthSeconds, row.name)
+		}
+	}
+}
+
+func TestHandleInfoAction(t *testing.T) {
+	client := newFakeApiServer(t, nil)
+	// runInfo runs `info` subcommand with given arguments against the fake API server, and returns its output.
+	runInfo := func(args ...string) (string, error) {
+		osExiter := cli.OsExiter
+		cli.OsExiter = func(int) {}
+		defer func() { cli.OsExiter = osExiter }()
+
+		var output bytes.Buffer
+		app := newCliApp()
+		for _, command := range app.Commands {
+			command.Action = func(c *cli.Context) error {
+				return handleInfoAction(c, client)
+			}
+		}
+		app.Writer, app.ErrWriter = &output, &output
+		err := app.Run(append([]string{"bililive-downloader", "info"}, args...))
+		return output.String(), err
+	}
+	// The record in fake API responses starts at 11:00, its only part lasts 10 minutes.
+	start := time.Date(2021, 3, 1, 11, 0, 0, 0, timezone)
+
+	// checkDetail checks the decoded output against the fake API responses.
+	checkDetail := func(format string, detail recordDetail) {
+		assert.Equal(t, "R1", detail.RecordID, format)
+		assert.Equal(t, "测试", detail.Title, format)
+		assert.Equal(t, int64(100), detail.RoomID, format)
+		assert.Equal(t, "主播", detail.UserName, format)
+		assert.Equal(t, uint64(1024), detail.SizeBytes, format)
+		assert.Equal(t, []qualityDetail{{Number: 250, Name: "超清", Current: true}, {Number: 10000, Name: "原画"}}, detail.Qualities, format)
+		if assert.Len(t, detail.Parts, 1, format) {
+			assert.Equal(t, "1.flv", detail.Parts[0].FileName, format)
+			assert.True(t, start.Equal(detail.Parts[0].Start), format)
+			assert.True(t, start.Add(10*time.Minute).Equal(detail.Parts[0].End), format)
+			assert.Equal(t, float64(600), detail.Parts[0].LengthSeconds, format)
+		}
+	}
+
+	output, err := runInfo("--record", "https://live.bilibili.com/record/R1", "--format", "json")
+	if assert.NoError(t, err) {
+		var detail recordDetail
+		if assert.NoError(t, json.Unmarshal([]byte(output), &detail), output) {
+			checkDetail("json", detail)
+		}
+		assert.Contains(t, output, `"start": "2021-03-01T11:00:00+08:00"`)
+	}
+
+	output, err = runInfo("--record", "R1", "--format", "yaml")
+	if assert.NoError(t, err) {
+		var detail recordDetail
+		if assert.NoError(t, yaml.Unmarshal([]byte(output), &detail), output) {
+			checkDetail("yaml", detail)
+		}
+		assert.Contains(t, output, "\n  - number: 1\n")
+	}
+
+	output, err = runInfo("--record", "R1")
+	if assert.NoError(t, err) {
+		assert.Contains(t, output, "测试")
+		assert.Contains(t, output, "超清(250，当前)")
+		assert.Contains(t, output, "1.flv")
+	}
+
+	_, err = runInfo("--record", "R1", "--format", "xml")
+	assert.Error(t, err)
+	_, err = runInfo("--record", "")
+	assert.Error(t, err)
+	client.BaseURL += "/nowhere"
+	_, err = runInfo("--record", "R1", "--format", "json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"text/tabwriter"
+)
+
+// listPageSize is how many records are fetched in each request when listing records.
+const listPageSize = 20
+
+// recordSummary is a record in the output of `list` subcommand.
+type recordSummary struct {
+	RecordID string          `json:"rid"`
+	Title    string          `json:"title"`
+	Start    helper.JSONTime `json:"start_timestamp"`
+	End      helper.JSONTime `json:"end_timestamp"`
+	Length   helper.Duration `json:"length"`
+	Size     helper.Size     `json:"size"`
+}
+
+// fetchAllRecords pages through record history of given room with `client`, latest records come first.
+// At most `max` records are returned, 0 means no limitation.
+func fetchAllRecords(ctx context.Context, client *BiliClient, roomID int64, max int) ([]models.LiveRecordInfo, error) {
+	var records []models.LiveRecordInfo
+	for page := 1; ; page++ {
+		list, err := client.fetchRecordList(ctx, roomID, page, listPageSize)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug().Int("页码", page).Int("回放数量", len(list.List)).Int64("回放总数", list.Count).Msg("加载直播间回放列表")
+
+		records = append(records, list.List...)
+		if max > 0 && len(records) >= max {
+			return records[:max], nil
+		}
+		if len(list.List) == 0 || int64(len(records)) >= list.Count {
+			return records, nil
+		}
+	}
+}
+
+// handleListAction handles `list` subcommand. The only error it might return is cli.Exit.
+func handleListAction(c *cli.Context, client *BiliClient) error {
+	roomID := c.Int64("room")
+	if uid := c.Int64("uid"); roomID == 0 && uid != 0 {
+		var err error
+		if roomID, err = client.fetchRoomIDByUser(c.Context, uid); err != nil {
+			logger.Error().Err(err).Int64("UID", uid).Msg("加载用户直播间出错")
+			return cli.Exit("加载用户直播间出错", returnCodeError)
+		}
+		logger.Debug().Int64("UID", uid).Int64("直播间ID", roomID).Msg("找到用户直播间")
+	}
+	if roomID == 0 {
+		return cli.Exit("需要指定直播间ID或主播UID", returnCodeError)
+	}
+
+	format := c.String("format")
+	if format != "table" && format != "json" && format != "ids" {
+		return cli.Exit(fmt.Sprintf("不支持的输出格式%s", format), returnCodeError)
+	}
+
+	records, err := fetchAllRecords(c.Context, client, roomID, c.Int("max"))
+	if err != nil {
+		logger.Error().Err(err).Int64("直播间ID", roomID).Msg("加载直播间回放列表出错")
+		return cli.Exit("加载直播间回放列表出错", returnCodeError)
+	}
+
+	summaries := make([]recordSummary, 0, len(records))
+	for _, record := range records {
+		summary := recordSummary{RecordID: record.ID, Title: record.Title, Start: record.Start, End: record.End}
+		// Only parts list knows about size of the record.
+		if format != "ids" {
+			parts, err := client.fetchRecordParts(c.Context, record.ID, 0)
+			if err != nil {
+				logger.Warn().Err(err).Str("直播回放ID", record.ID).Msg("加载回放分段信息出错")
+			} else {
+				summary.Length = parts.Length
+				summary.Size = parts.Size
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(c.App.Writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summaries); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
+	case "ids":
+		for _, summary := range summaries {
+			fmt.Fprintln(c.App.Writer, summary.RecordID)
+		}
+	default:
+		w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "回放ID\t标题\t开始于\t结束于\t时长\t大小")
+		for _, summary := range summaries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", summary.RecordID, summary.Title, summary.Start, summary.End, summary.Length, summary.Size)
+		}
+		if err := w.Flush(); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
+	}
+
+	return nil
+}
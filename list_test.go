@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newFakeRecordListServer starts a fake API server with `count` records in room 100, named R1, R2...
+func newFakeRecordListServer(t *testing.T, count int) *BiliClient {
+	return newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/xlive/web-room/v1/record/getList" {
+			return false
+		}
+		query := r.URL.Query()
+		assert.Equal(t, "100", query.Get("room_id"))
+		page, _ := strconv.Atoi(query.Get("page"))
+		pageSize, _ := strconv.Atoi(query.Get("page_size"))
+
+		records := make([]string, 0, pageSize)
+		for i := (page-1)*pageSize + 1; i <= page*pageSize && i <= count; i++ {
+			records = append(records, fmt.Sprintf(`{"rid":"R%d","room_id":100,"uid":200,"title":"测试%d","start_timestamp":1614567600,"end_timestamp":1614571200}`, i, i))
+		}
+		_, _ = fmt.Fprintf(w, `{"code":0,"message":"0","ttl":1,"data":{"count":%d,"list":[%s]}}`, count, strings.Join(records, ","))
+		return true
+	})
+}
+
+func TestFetchAllRecords(t *testing.T) {
+	testCases := []struct {
+		name    string
+		count   int
+		max     int
+		wantLen int
+	}{
+		{name: "no record", count: 0, max: 0, wantLen: 0},
+		{name: "single page", count: 5, max: 0, wantLen: 5},
+		{name: "full page", count: listPageSize, max: 0, wantLen: listPageSize},
+		{name: "multiple pages", count: listPageSize*2 + 3, max: 0, wantLen: listPageSize*2 + 3},
+		{name: "max within a page", count: listPageSize*2 + 3, max: 3, wantLen: 3},
+		{name: "max across pages", count: listPageSize*2 + 3, max: listPageSize + 1, wantLen: listPageSize + 1},
+		{name: "max beyond count", count: 5, max: 10, wantLen: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeRecordListServer(t, tc.count)
+			records, err := fetchAllRecords(context.Background(), client, 100, tc.max)
+			if assert.NoError(t, err) && assert.Len(t, records, tc.wantLen) {
+				for i, record := range records {
+					assert.Equal(t, fmt.Sprintf("R%d", i+1), record.ID)
+				}
+			}
+		})
+	}
+
+	t.Run("empty page", func(t *testing.T) {
+		// The server claims more records than it has, paging must stop anyway.
+		client := newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Query().Get("page") == "1" {
+				return false
+			}
+			_, _ = fmt.Fprint(w, `{"code":0,"message":"0","ttl":1,"data":{"count":100,"list":[]}}`)
+			return true
+		})
+		records, err := fetchAllRecords(context.Background(), client, 100, 0)
+		if assert.NoError(t, err) && assert.Len(t, records, 1) {
+			assert.Equal(t, "R1", records[0].ID)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		client := newFakeApiServer(t, nil)
+		client.BaseURL += "/nowhere"
+		_, err := fetchAllRecords(context.Background(), client, 100, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestHandleListAction(t *testing.T) {
+	// runList runs `list` subcommand with given arguments against `client`, and returns its output.
+	runList := func(client *BiliClient, args ...string) (string, error) {
+		osExiter := cli.OsExiter
+		cli.OsExiter = func(int) {}
+		defer func() { cli.OsExiter = osExiter }()
+
+		var output bytes.Buffer
+		app := newCliApp()
+		for _, command := range app.Commands {
+			command.Action = func(c *cli.Context) error {
+				return handleListAction(c, client)
+			}
+		}
+		app.Writer, app.ErrWriter = &output, &output
+		err := app.Run(append([]string{"bililive-downloader", "list"}, args...))
+		return output.String(), err
+	}
+
+	t.Run("ids", func(t *testing.T) {
+		output, err := runList(newFakeRecordListServer(t, 3), "--room", "100", "--format", "ids")
+		assert.NoError(t, err)
+		assert.Equal(t, "R1\nR2\nR3\n", output)
+	})
+
+	t.Run("max", func(t *testing.T) {
+		output, err := runList(newFakeRecordListServer(t, 3), "--room", "100", "--format", "ids", "--max", "2")
+		assert.NoError(t, err)
+		assert.Equal(t, "R1\nR2\n", output)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		output, err := runList(newFakeRecordListServer(t, 2), "--room", "100", "--format", "json")
+		if !assert.NoError(t, err) {
+			return
+		}
+		var summaries []map[string]interface{}
+		if assert.NoError(t, json.Unmarshal([]byte(output), &summaries)) && assert.Len(t, summaries, 2) {
+			assert.Equal(t, "R1", summaries[0]["rid"])
+			assert.Equal(t, "测试2", summaries[1]["title"])
+			// Size and length come from parts list.
+			assert.NotEmpty(t, summaries[0]["size"])
+			assert.NotEmpty(t, summaries[0]["length"])
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		output, err := runList(newFakeRecordListServer(t, 2), "--room", "100")
+		assert.NoError(t, err)
+		assert.Contains(t, output, "回放ID")
+		assert.Contains(t, output, "测试1")
+		assert.Contains(t, output, "R2")
+	})
+
+	t.Run("uid", func(t *testing.T) {
+		output, err := runList(newFakeRecordListServer(t, 1), "--uid", "200", "--format", "ids")
+		assert.NoError(t, err)
+		assert.Equal(t, "R1\n", output)
+	})
+
+	t.Run("no room", func(t *testing.T) {
+		_, err := runList(newFakeRecordListServer(t, 1), "--format", "ids")
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := runList(newFakeRecordListServer(t, 1), "--room", "100", "--format", "xml")
+		assert.Error(t, err)
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		client := newFakeRecordListServer(t, 1)
+		client.BaseURL += "/nowhere"
+		_, err := runList(client, "--room", "100", "--format", "ids")
+		assert.Error(t, err)
+	})
+}
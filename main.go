@@ -3,6 +3,7 @@ package main
 import (
 	"bililive-downloader/ffmpeg"
 	"bililive-downloader/helper"
+	"context"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"os"
@@ -14,22 +15,19 @@ const timeFormat = "2006-01-02 15:04:05"
 var logger zerolog.Logger
 
 func main() {
+	// Logs go to stderr, so they never mix with output of commands like `list --format ids`, which may be piped.
 	logger = log.Output(zerolog.ConsoleWriter{
-		NoColor:    !helper.IsTTY(),
-		Out:        os.Stdout,
+		NoColor:    !helper.IsTerminal(os.Stderr),
+		Out:        os.Stderr,
 		TimeFormat: timeFormat,
 	}).Level(zerolog.InfoLevel)
 
-	// Setup ffmpeg tools
-	ffmpegBin, err := exec.LookPath("ffmpeg")
-	if err != nil {
-		logger.Fatal().Err(err).Msg("没有找到ffmpeg工具")
-	}
-	ffprobeBin, err := exec.LookPath("ffprobe")
-	if err != nil {
-		logger.Fatal().Err(err).Msg("没有找到ffprobe工具")
-	}
+	// Setup ffmpeg tools. They're optional, features relying on them check ffmpeg.Available before use.
+	ffmpegBin, _ := exec.LookPath("ffmpeg")
+	ffprobeBin, _ := exec.LookPath("ffprobe")
 	ffmpeg.Init(ffmpegBin, ffprobeBin)
 
-	newCliApp().Run(os.Args)
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	newCliApp().RunContext(ctx, os.Args)
 }
@@ -5,8 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -27,7 +29,7 @@ func (rp *RecordPart) FileName() string {
 		u, err := url.Parse(rp.Url)
 		if err == nil {
 			pp := strings.Split(u.Path, "/")
-			rp.filename = strings.ReplaceAll(pp[len(pp)-1], ":", "-")
+			rp.filename = helper.SanitizeFileName(pp[len(pp)-1])
 		} else {
 			urlHash := hex.EncodeToString(sha256.New().Sum([]byte(strings.Split(rp.Url, "?")[0])))
 			rp.filename = fmt.Sprintf("%s.flv", urlHash)
@@ -36,6 +38,17 @@ func (rp *RecordPart) FileName() string {
 	return rp.filename
 }
 
+// Mirrors returns all available URLs of this part, the primary one comes first.
+func (rp *RecordPart) Mirrors() []string {
+	mirrors := []string{rp.Url}
+	if rp.BackupUrl != nil {
+		if backupUrl := strings.TrimSpace(*rp.BackupUrl); backupUrl != "" && backupUrl != rp.Url {
+			mirrors = append(mirrors, backupUrl)
+		}
+	}
+	return mirrors
+}
+
 type Quality struct {
 	Number uint64 `json:"qn"`
 	Name   string `json:"desc"`
@@ -59,6 +72,33 @@ func (ri *RecordParts) Quality() string {
 	return "未知"
 }
 
+// ResolveQuality finds the quality described by `spec` in available qualities.
+// `spec` can be the name (e.g. `原画`) or number (qn) of a quality, or `best` / `worst`. Higher qn means better quality.
+func (ri *RecordParts) ResolveQuality(spec string) (Quality, error) {
+	spec = strings.TrimSpace(spec)
+	if len(ri.Qualities) == 0 {
+		return Quality{}, errors.New("没有可用的画质")
+	}
+
+	switch keyword := strings.ToLower(spec); keyword {
+	case "best", "worst":
+		chosen := ri.Qualities[0]
+		for _, q := range ri.Qualities[1:] {
+			if (keyword == "best") == (q.Number > chosen.Number) {
+				chosen = q
+			}
+		}
+		return chosen, nil
+	}
+
+	for _, q := range ri.Qualities {
+		if strings.EqualFold(q.Name, spec) || strconv.FormatUint(q.Number, 10) == spec {
+			return q, nil
+		}
+	}
+	return Quality{}, fmt.Errorf("画质%s不可用", spec)
+}
+
 // ApiResponse wraps general HTTP API response from bilibili.
 type ApiResponse struct {
 	Code    int             `json:"code"`
@@ -76,6 +116,12 @@ type LiveRecordInfo struct {
 	End    helper.JSONTime `json:"end_timestamp"`
 }
 
+// RecordList is a page of livestream records of a room.
+type RecordList struct {
+	Count int64            `json:"count"`
+	List  []LiveRecordInfo `json:"list"`
+}
+
 type LiveRecord struct {
 	Info LiveRecordInfo `json:"live_record_info"`
 }
@@ -90,3 +136,8 @@ type LiverInfo struct {
 	UserName string `json:"uname"`
 	UserID   int64  `json:"uid"`
 }
+
+// UserRoomInfo is the livestream room info of a user. We only need the room ID.
+type UserRoomInfo struct {
+	RoomID int64 `json:"roomid"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRecordParts_ResolveQuality(t *testing.T) {
+	parts := RecordParts{
+		CurrentQualityNumber: 250,
+		Qualities: []Quality{
+			{Number: 250, Name: "超清"},
+			{Number: 10000, Name: "原画"},
+			{Number: 150, Name: "高清"},
+			{Number: 400, Name: "蓝光"},
+		},
+	}
+
+	testData := map[string]uint64{
+		"best":   10000,
+		"BEST":   10000,
+		"worst":  150,
+		"原画":     10000,
+		" 蓝光 ":   400,
+		"150":    150,
+		"250":    250,
+		"80":     0, // placeholder for error
+		"4K":     0,
+		"":       0,
+		"best!!": 0,
+	}
+
+	for spec, expected := range testData {
+		q, err := parts.ResolveQuality(spec)
+		if expected == 0 {
+			assert.Error(t, err, spec)
+			continue
+		}
+
+		assert.NoError(t, err, spec)
+		assert.Equal(t, expected, q.Number, spec)
+	}
+
+	_, err := (&RecordParts{}).ResolveQuality("best")
+	assert.Error(t, err)
+}
+
+func TestRecordPart_Mirrors(t *testing.T) {
+	backup := "https://backup.example.com/a.flv"
+	empty := " "
+	same := "https://main.example.com/a.flv"
+
+	assert.Equal(t, []string{"https://main.example.com/a.flv"}, (&RecordPart{Url: "https://main.example.com/a.flv"}).Mirrors())
+	assert.Equal(t, []string{"https://main.example.com/a.flv"}, (&RecordPart{Url: "https://main.example.com/a.flv", BackupUrl: &empty}).Mirrors())
+	assert.Equal(t, []string{"https://main.example.com/a.flv"}, (&RecordPart{Url: "https://main.example.com/a.flv", BackupUrl: &same}).Mirrors())
+	assert.Equal(t, []string{"https://main.example.com/a.flv", backup}, (&RecordPart{Url: "https://main.example.com/a.flv", BackupUrl: &backup}).Mirrors())
+}
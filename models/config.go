@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ConfigFileName is the name of config file under the config directory.
+const ConfigFileName = "config.yaml"
+
+// Config is the content of the config file.
+// Each profile maps flag names (without leading dashes) to their values, e.g.
+//
+//	default_profile: nas
+//	defaults:
+//	  retries: 5
+//	profiles:
+//	  nas:
+//	    output-dir: /mnt/nas/bilibili
+//	    limit: 4
+//	    quality: best
+type Config struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Defaults       Profile            `yaml:"defaults"` // values shared by all profiles
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// Profile is a named set of flag values.
+type Profile map[string]interface{}
+
+// DefaultConfigPath returns the config file path following XDG base directory specification,
+// i.e. $XDG_CONFIG_HOME/bililive-downloader/config.yaml, falling back to ~/.config.
+// An empty string is returned if neither can be determined.
+func DefaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "bililive-downloader", ConfigFileName)
+}
+
+// LoadConfig reads and parses given config file.
+func LoadConfig(filePath string) (*Config, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("配置文件格式错误: %w", err)
+	}
+	return config, nil
+}
+
+// Profile returns values of given profile merged over the shared defaults.
+// When name is empty, `DefaultProfile` is used; if that is empty too, only the defaults are returned.
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+
+	merged := Profile{}
+	for key, value := range c.Defaults {
+		merged[key] = value
+	}
+	if name == "" {
+		return merged, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("配置文件中没有名为%s的配置", name)
+	}
+	for key, value := range profile {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// Keys returns flag names in the profile, sorted.
+func (p Profile) Keys() []string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FlagValues converts value of given key into strings which can be passed to flag parsing.
+// A list yields one string per item, so it can be applied to slice flags.
+func (p Profile) FlagValues(key string) ([]string, error) {
+	switch value := p[key].(type) {
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			str, err := flagValueString(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			values = append(values, str)
+		}
+		return values, nil
+	default:
+		str, err := flagValueString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		return []string{str}, nil
+	}
+}
+
+func flagValueString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("不支持的配置值: %v", value)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
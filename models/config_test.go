@@ -0,0 +1,94 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+default_profile: nas
+defaults:
+  retries: 5
+  limit: 1
+profiles:
+  nas:
+    output-dir: /mnt/nas/bilibili
+    limit: 4.5
+    no-merge: true
+  laptop:
+    quality: best
+    tags: [a, b]
+    nested: [[a]]
+`
+
+func loadTestConfig(t *testing.T) *Config {
+	dir, err := ioutil.TempDir("", "config")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, ConfigFileName)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testConfig), 0644))
+	config, err := LoadConfig(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return config
+}
+
+func TestConfig_Profile(t *testing.T) {
+	config := loadTestConfig(t)
+
+	testData := map[string]map[string][]string{
+		"": {
+			"retries":    {"5"},
+			"limit":      {"4.5"},
+			"output-dir": {"/mnt/nas/bilibili"},
+			"no-merge":   {"true"},
+		},
+		"laptop": {
+			"retries": {"5"},
+			"limit":   {"1"},
+			"quality": {"best"},
+			"tags":    {"a", "b"},
+		},
+	}
+
+	for name, expected := range testData {
+		profile, err := config.Profile(name)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		for key, values := range expected {
+			actual, err := profile.FlagValues(key)
+			assert.NoError(t, err, name, key)
+			assert.Equal(t, values, actual, name, key)
+		}
+	}
+
+	_, err := config.Profile("missing")
+	assert.Error(t, err)
+
+	laptop, _ := config.Profile("laptop")
+	_, err = laptop.FlagValues("nested")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"limit", "nested", "quality", "retries", "tags"}, laptop.Keys())
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	old, had := os.LookupEnv("XDG_CONFIG_HOME")
+	defer func() {
+		if had {
+			_ = os.Setenv("XDG_CONFIG_HOME", old)
+		} else {
+			_ = os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	}()
+
+	_ = os.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+	assert.Equal(t, filepath.Join("/tmp/xdg", "bililive-downloader", ConfigFileName), DefaultConfigPath())
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/c2h5oh/datasize"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JobManifestFileName is the name of job manifest file, which lives in the record directory.
+const JobManifestFileName = "下载任务.json"
+
+// PartState is the persistent state of a single part download.
+type PartState struct {
+	Step          string   `json:"step"`                    // Last step of the part, see `Step*` constants
+	FileName      string   `json:"file_name"`               // Name of the file currently being processed
+	RawFileName   string   `json:"raw_file_name,omitempty"` // Name of the raw FLV file, the de-capped TS file is named after it
+	Size          uint64   `json:"size"`                    // Expected size of the raw FLV file
+	BytesComplete int64    `json:"bytes_complete"`          // Downloaded bytes of the raw FLV file
+	Mirror        string   `json:"mirror,omitempty"`        // Host of the mirror which the raw FLV file is downloaded from
+	Fingerprint   string   `json:"fingerprint,omitempty"`   // Size and modification time of the de-capped TS media, only available once finished
+	Verdict       *Verdict `json:"verdict,omitempty"`       // Result of the last verification of the part
+}
+
+// Verdict is the result of verifying integrity of a downloaded file.
+type Verdict struct {
+	File       string    `json:"file"` // Name of the verified file
+	OK         bool      `json:"ok"`
+	Problems   []string  `json:"problems,omitempty"` // Why the file is considered corrupted
+	Decoded    bool      `json:"decoded"`            // Whether media streams were fully decoded while verifying
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// JobManifest is the persistent state of a record download job.
+// It's saved as JSON into the record directory, so an interrupted job can be resumed later.
+type JobManifest struct {
+	RecordID      string             `json:"record_id"`
+	DownloadList  []int              `json:"download_list"`
+	Concurrency   uint               `json:"concurrency"`
+	NoMerge       bool               `json:"no_merge"`
+	Merge         bool               `json:"merge,omitempty"`          // Whether a contiguous selection of some parts is merged
+	FixTimestamps bool               `json:"fix_timestamps,omitempty"` // Whether timestamps are regenerated when merging parts
+	Container     string             `json:"container,omitempty"`      // Container format of merged media, `mp4` if empty
+	RateLimit     datasize.ByteSize  `json:"rate_limit"`
+	Quality       string             `json:"quality,omitempty"`
+	NameTemplate  string             `json:"name_template,omitempty"`
+	ClipFrom      *time.Time         `json:"clip_from,omitempty"` // Start of the time range to keep, if only a clip is wanted
+	ClipTo        *time.Time         `json:"clip_to,omitempty"`   // End of the time range to keep, if only a clip is wanted
+	Finished      bool               `json:"finished"`
+	Parts         map[int]*PartState `json:"parts"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	path          string
+	guard         sync.Mutex
+}
+
+// NewJobManifest creates an empty job manifest to be saved into `directory`.
+func NewJobManifest(directory, recordID string) *JobManifest {
+	return &JobManifest{
+		RecordID: recordID,
+		Parts:    make(map[int]*PartState),
+		path:     filepath.Join(directory, JobManifestFileName),
+	}
+}
+
+// LoadJobManifest loads job manifest from `directory`. The returned error satisfies `os.IsNotExist` if there isn't one.
+func LoadJobManifest(directory string) (*JobManifest, error) {
+	manifestPath := filepath.Join(directory, JobManifestFileName)
+	content, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var m JobManifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, err
+	}
+	if m.Parts == nil {
+		m.Parts = make(map[int]*PartState)
+	}
+	m.path = manifestPath
+	return &m, nil
+}
+
+// Path returns location of the manifest file.
+func (m *JobManifest) Path() string {
+	return m.path
+}
+
+// Part returns a copy of state of given part, or nil if the part has no state recorded.
+func (m *JobManifest) Part(partNumber int) *PartState {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+
+	state, ok := m.Parts[partNumber]
+	if !ok {
+		return nil
+	}
+	stateCopy := *state
+	return &stateCopy
+}
+
+// UpdatePart modifies state of given part in place with `fn`. Changes are kept in memory until `.Save` is called.
+func (m *JobManifest) UpdatePart(partNumber int, fn func(state *PartState)) {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+
+	state, ok := m.Parts[partNumber]
+	if !ok {
+		state = &PartState{}
+		m.Parts[partNumber] = state
+	}
+	fn(state)
+}
+
+// Update modifies the manifest with `fn` while holding the lock. Changes are kept in memory until `.Save` is called.
+func (m *JobManifest) Update(fn func(m *JobManifest)) {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+
+	fn(m)
+}
+
+// Save writes the manifest into its file.
+// The content is written to a temporary file first, so an interruption never leaves a broken manifest.
+func (m *JobManifest) Save() error {
+	m.guard.Lock()
+	defer m.guard.Unlock()
+
+	m.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.path)
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobManifest_SaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	_, err := LoadJobManifest(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	clipFrom := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	clipTo := clipFrom.Add(time.Hour)
+	m := NewJobManifest(dir, "R1")
+	assert.Equal(t, filepath.Join(dir, JobManifestFileName), m.Path())
+	m.Update(func(m *JobManifest) {
+		m.DownloadList = []int{1, 3}
+		m.Concurrency = 2
+		m.Container = "mkv"
+		m.RateLimit = 4 * datasize.MB
+		m.ClipFrom, m.ClipTo = &clipFrom, &clipTo
+	})
+	m.UpdatePart(3, func(state *PartState) {
+		state.Step = StepDone
+		state.FileName = "3.ts"
+		state.RawFileName = "3.flv"
+		state.Size = 1024
+		state.Verdict = &Verdict{File: "3.ts", OK: true}
+	})
+	if !assert.NoError(t, m.Save()) {
+		return
+	}
+	assert.False(t, m.UpdatedAt.IsZero())
+	_, err = os.Stat(m.Path() + ".tmp")
+	assert.True(t, os.IsNotExist(err), "temporary file should be renamed")
+
+	loaded, err := LoadJobManifest(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, m.Path(), loaded.Path())
+		assert.Equal(t, "R1", loaded.RecordID)
+		assert.Equal(t, []int{1, 3}, loaded.DownloadList)
+		assert.Equal(t, uint(2), loaded.Concurrency)
+		assert.Equal(t, "mkv", loaded.Container)
+		assert.Equal(t, 4*datasize.MB, loaded.RateLimit)
+		assert.True(t, clipFrom.Equal(*loaded.ClipFrom))
+		assert.True(t, clipTo.Equal(*loaded.ClipTo))
+		assert.Equal(t, m.Part(3), loaded.Part(3))
+		assert.Nil(t, loaded.Part(1))
+	}
+}
+
+func TestLoadJobManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, JobManifestFileName)
+
+	// Parts may be missing from hand-written manifests.
+	assert.NoError(t, ioutil.WriteFile(manifestPath, []byte(`{"record_id": "R1", "download_list": [2]}`), 0644))
+	m, err := LoadJobManifest(dir)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, m.Parts)
+		m.UpdatePart(2, func(state *PartState) { state.Step = StepDownloading })
+		assert.Equal(t, StepDownloading, m.Part(2).Step)
+	}
+
+	assert.NoError(t, ioutil.WriteFile(manifestPath, []byte(`{"record_id": `), 0644))
+	_, err = LoadJobManifest(dir)
+	assert.Error(t, err)
+}
+
+func TestJobManifest_Part(t *testing.T) {
+	m := NewJobManifest(t.TempDir(), "R1")
+	assert.Nil(t, m.Part(1))
+
+	m.UpdatePart(1, func(state *PartState) {
+		state.Step = StepDownloading
+		state.Size = 1024
+	})
+	state := m.Part(1)
+	if !assert.NotNil(t, state) {
+		return
+	}
+	assert.Equal(t, StepDownloading, state.Step)
+	assert.Equal(t, uint64(1024), state.Size)
+
+	// Changes to the copy don't affect the manifest.
+	state.Step = StepFailed
+	assert.Equal(t, StepDownloading, m.Part(1).Step)
+
+	// Changes by UpdatePart are kept, without touching other fields.
+	m.UpdatePart(1, func(state *PartState) { state.BytesComplete = 512 })
+	assert.Equal(t, &PartState{Step: StepDownloading, Size: 1024, BytesComplete: 512}, m.Part(1))
+	assert.Len(t, m.Parts, 1)
+}
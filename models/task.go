@@ -1,20 +1,51 @@
 package models
 
 import (
+	"bililive-downloader/helper"
 	"bililive-downloader/progressbar"
 	"fmt"
 	"github.com/cavaliercoder/grab"
 	"github.com/gosuri/uiprogress"
 )
 
+// Steps of a part task. They are displayed in progress bar, and persisted in job manifest.
+const (
+	StepWaiting     = "等待中"
+	StepExists      = "已存在"
+	StepDownloading = "下载中"
+	StepDownloaded  = "已下载"
+	StepVerifying   = "校验中"
+	StepDecapping   = "解包中"
+	StepChecking    = "检查中"
+	StepDone        = "已完成"
+	StepFailed      = "已出错"
+	StepCancelled   = "已取消"
+)
+
+// SetCurrentStep sets current step of the task. The step is also recorded into job manifest, if there is one.
 func (t *PartTask) SetCurrentStep(name string) {
 	t.currentStep = name
+	t.retry = 0
+	if t.Manifest != nil {
+		t.Manifest.UpdatePart(t.PartNumber, func(state *PartState) {
+			state.Step = name
+		})
+		_ = t.Manifest.Save()
+	}
 }
 func (t *PartTask) SetFileName(name string) {
 	t.filename = name
 }
 
+// SetRetry sets how many times current step has been retried. It's only displayed in progress bar, until the step changes.
+func (t *PartTask) SetRetry(retry uint) {
+	t.retry = retry
+}
+
 func (t *PartTask) DecorStepName() string {
+	if t.retry > 0 {
+		return fmt.Sprintf("%s(重试%d/%d)", t.currentStep, t.retry, t.RetryPolicy.Retries)
+	}
 	return t.currentStep
 }
 
@@ -35,7 +66,12 @@ type PartTask struct {
 	Part              *RecordPart // Part is record part info
 	DownloadDirectory string
 	RateLimiter       grab.RateLimiter
+	RetryPolicy       helper.RetryPolicy
+	Remuxer           string       // How the downloaded FLV file is de-capped into TS, `native` or `ffmpeg`
+	Manifest          *JobManifest // Manifest of the job this task belongs to, optional
+	PreviousState     *PartState   // State of this part recorded by a previous run, nil if there isn't one
 	currentStep       string
+	retry             uint
 	filename          string
 }
 
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"bililive-downloader/helper"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPartTask_SetRetry(t *testing.T) {
+	task := &PartTask{PartNumber: 1, RetryPolicy: helper.RetryPolicy{Retries: 3}, Manifest: NewJobManifest(t.TempDir(), "R1")}
+	task.SetCurrentStep(StepDownloading)
+	task.SetRetry(2)
+
+	// The retry count is only displayed, the saved step stays the same.
+	assert.Equal(t, "下载中(重试2/3)", task.DecorStepName())
+	assert.Equal(t, StepDownloading, task.Manifest.Part(1).Step)
+
+	task.SetCurrentStep(StepDecapping)
+	assert.Equal(t, StepDecapping, task.DecorStepName())
+}
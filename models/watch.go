@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// WatchState is the persistent state of watching a room, it remembers which records were already downloaded.
+type WatchState struct {
+	RoomID    int64     `json:"room_id"`
+	Fetched   []string  `json:"fetched"` // IDs of downloaded records
+	UpdatedAt time.Time `json:"updated_at"`
+	path      string
+}
+
+// LoadWatchState loads watch state from given file, an empty state is returned if the file does not exist yet.
+func LoadWatchState(filePath string, roomID int64) (*WatchState, error) {
+	state := &WatchState{RoomID: roomID, path: filePath}
+
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// IsFetched tells whether given record was already downloaded.
+func (s *WatchState) IsFetched(recordID string) bool {
+	for _, id := range s.Fetched {
+		if id == recordID {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkFetched remembers given record as downloaded, and saves the state into its file.
+func (s *WatchState) MarkFetched(recordID string) error {
+	if !s.IsFetched(recordID) {
+		s.Fetched = append(s.Fetched, recordID)
+	}
+	return s.Save()
+}
+
+// Save writes the state into its file.
+func (s *WatchState) Save() error {
+	s.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
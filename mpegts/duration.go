@@ -0,0 +1,147 @@
+package mpegts
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// probeSize is how much data at the head and the tail of a TS file is scanned for timestamps.
+const probeSize = 2 << 20
+
+// ErrNoTimestamp means no timestamps are found in the TS file.
+var ErrNoTimestamp = errors.New("TS文件中没有找到时间戳")
+
+// timestampRange is the first and last timestamps (in 90kHz clock) of each PID.
+type timestampRange struct {
+	first map[uint16]int64
+	last  map[uint16]int64
+}
+
+func newTimestampRange() *timestampRange {
+	return &timestampRange{first: map[uint16]int64{}, last: map[uint16]int64{}}
+}
+
+func (t *timestampRange) add(pid uint16, ts int64) {
+	if _, ok := t.first[pid]; !ok {
+		t.first[pid] = ts
+	}
+	t.last[pid] = ts
+}
+
+// Duration reads duration of TS media from `r`, by scanning timestamps at the head and the tail of it.
+// The duration is the longest span between the first and the last PTS of a stream, PCR is used if there's no PTS.
+func Duration(r io.ReadSeeker) (time.Duration, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	headPTS, headPCR, err := scanTimestamps(r, 0, probeSize)
+	if err != nil {
+		return 0, err
+	}
+	tailStart := size - probeSize
+	if tailStart < 0 {
+		tailStart = 0
+	}
+	tailStart -= tailStart % PacketSize
+	tailPTS, tailPCR, err := scanTimestamps(r, tailStart, size-tailStart)
+	if err != nil {
+		return 0, err
+	}
+
+	span, ok := longestSpan(headPTS, tailPTS)
+	if !ok {
+		if span, ok = longestSpan(headPCR, tailPCR); !ok {
+			return 0, ErrNoTimestamp
+		}
+	}
+	return time.Duration(span) * time.Second / 90000, nil
+}
+
+// longestSpan finds the longest span between first timestamps in `head` and last timestamps in `tail` of the same PID.
+func longestSpan(head, tail *timestampRange) (span int64, found bool) {
+	for pid, first := range head.first {
+		last, ok := tail.last[pid]
+		if !ok {
+			continue
+		}
+		// Timestamps wrap around in 33 bits.
+		if s := (last - first) & timestampMask; !found || s > span {
+			span, found = s, true
+		}
+	}
+	return
+}
+
+// scanTimestamps reads `length` bytes from `offset` of `r`, and collects PTS of PES packets and PCR of each PID in it.
+func scanTimestamps(r io.ReadSeeker, offset, length int64) (pts, pcr *timestampRange, err error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, length)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, err
+	}
+	buf = buf[:n]
+
+	pts, pcr = newTimestampRange(), newTimestampRange()
+	for pos := 0; pos+PacketSize <= len(buf); {
+		// Resync if the packet is misaligned, by looking for consecutive sync bytes.
+		if buf[pos] != syncByte || (pos+2*PacketSize <= len(buf) && buf[pos+PacketSize] != syncByte) {
+			pos++
+			continue
+		}
+		ts := readPacketTimestamps(buf[pos : pos+PacketSize])
+		pos += PacketSize
+
+		if ts.hasPCR {
+			pcr.add(ts.pid, ts.pcr)
+		}
+		if ts.hasPTS {
+			pts.add(ts.pid, ts.pts)
+		}
+	}
+	return pts, pcr, nil
+}
+
+// packetTimestamps are timestamps (in 90kHz clock) carried by a TS packet.
+type packetTimestamps struct {
+	pid    uint16
+	pcr    int64
+	hasPCR bool
+	pts    int64 // PTS of the PES packet starting in the TS packet, only audio / video streams are considered
+	hasPTS bool
+}
+
+// readPacketTimestamps reads PCR and PTS from a TS packet `pkt`, which must start with the sync byte.
+func readPacketTimestamps(pkt []byte) (ts packetTimestamps) {
+	ts.pid = uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	payloadStart := 4
+	if pkt[3]&0x20 != 0 {
+		adaptationLength := int(pkt[4])
+		if adaptationLength >= 7 && pkt[5]&0x10 != 0 {
+			ts.pcr = int64(pkt[6])<<25 | int64(pkt[7])<<17 | int64(pkt[8])<<9 | int64(pkt[9])<<1 | int64(pkt[10])>>7
+			ts.hasPCR = true
+		}
+		payloadStart += 1 + adaptationLength
+	}
+	if pkt[1]&0x40 == 0 || pkt[3]&0x10 == 0 || payloadStart+14 > PacketSize {
+		return
+	}
+
+	// Only PES packets of audio / video streams with PTS.
+	pes := pkt[payloadStart:]
+	if pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || pes[3] < 0xc0 || pes[3] > 0xef || pes[7]&0x80 == 0 {
+		return
+	}
+	ts.pts, ts.hasPTS = readTimestamp(pes[9:14]), true
+	return
+}
+
+// readTimestamp decodes PTS / DTS encoded by putTimestamp.
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
@@ -0,0 +1,42 @@
+package mpegts
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	type testRow struct {
+		name     string
+		start    time.Duration
+		frames   int
+		frameGap time.Duration
+		expected time.Duration
+	}
+	rows := []testRow{
+		{"short", 0, 100, 40 * time.Millisecond, 99 * 40 * time.Millisecond},
+		{"longer than probe size", time.Hour, 3000, 40 * time.Millisecond, 2999 * 40 * time.Millisecond},
+		{"wrapped timestamps", 26*time.Hour + 30*time.Minute + 30*time.Second, 1000, 40 * time.Millisecond, 999 * 40 * time.Millisecond},
+	}
+	for _, row := range rows {
+		var buf bytes.Buffer
+		muxer := NewMuxer(&buf)
+		video, _ := muxer.AddStream(StreamTypeH264)
+		audio, _ := muxer.AddStream(StreamTypeAAC)
+		frame := make([]byte, 2000)
+		for i := 0; i < row.frames; i++ {
+			ts := row.start + time.Duration(i)*row.frameGap
+			assert.NoError(t, muxer.WritePacket(video, &Packet{PTS: ts + 80*time.Millisecond, DTS: ts, Keyframe: i%50 == 0, Data: frame}))
+			assert.NoError(t, muxer.WritePacket(audio, &Packet{PTS: ts, DTS: ts, Data: frame[:300]}))
+		}
+
+		duration, err := Duration(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err, row.name)
+		assert.InDelta(t, row.expected, duration, float64(time.Millisecond), row.name)
+	}
+
+	_, err := Duration(bytes.NewReader(bytes.Repeat([]byte{0x47, 0x1f, 0xff, 0x10}, 470)))
+	assert.Equal(t, ErrNoTimestamp, err)
+}
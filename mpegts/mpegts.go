@@ -0,0 +1,305 @@
+// Package mpegts writes elementary streams into MPEG transport stream for de-capping FLV records without ffmpeg, and reads durations of TS files and checks their integrity.
+package mpegts
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// PacketSize is the size of a TS packet.
+const PacketSize = 188
+
+// Stream types, as in the PMT.
+const (
+	StreamTypeAAC  = 0x0f // ADTS framed AAC
+	StreamTypeH264 = 0x1b // Annex-B framed H.264
+	StreamTypeHEVC = 0x24 // Annex-B framed HEVC
+)
+
+// isVideo tells whether streams of `streamType` carry video.
+func isVideo(streamType uint8) bool {
+	return streamType == StreamTypeH264 || streamType == StreamTypeHEVC
+}
+
+const (
+	syncByte       = 0x47
+	patPID         = 0x0000
+	pmtPID         = 0x1000
+	firstStreamPID = 0x0100
+	programNumber  = 1
+
+	// timestampOffset is added to all timestamps, and pcrDelay is how much PCR goes before DTS.
+	// These are the defaults of ffmpeg, so outputs of both remuxers are alike.
+	timestampOffset = 1400 * time.Millisecond
+	pcrDelay        = 700 * time.Millisecond
+
+	// timestampMask wraps timestamps into 33 bits.
+	timestampMask = 1<<33 - 1
+)
+
+// ErrStreamsFixed means streams are added after the muxer has started writing.
+var ErrStreamsFixed = errors.New("开始写入后不能再添加流")
+
+// Stream is an elementary stream in the transport stream.
+type Stream struct {
+	Type     uint8
+	PID      uint16
+	streamID uint8
+	cc       uint8 // Continuity counter
+}
+
+// Packet is an access unit of an elementary stream, i.e. a video frame or an audio frame.
+type Packet struct {
+	PTS      time.Duration
+	DTS      time.Duration
+	Keyframe bool
+	Data     []byte
+}
+
+// Muxer writes packets of elementary streams into a transport stream.
+// Streams must all be added before the first packet is written.
+type Muxer struct {
+	w       io.Writer
+	streams []*Stream
+	pcr     *Stream // Stream carrying PCR
+	started bool
+	patCC   uint8
+	pmtCC   uint8
+	buf     [PacketSize]byte
+}
+
+// NewMuxer creates a Muxer writing into `w`.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// AddStream adds an elementary stream of given type. The first video stream (or the first stream if there's no video) carries PCR.
+func (m *Muxer) AddStream(streamType uint8) (*Stream, error) {
+	if m.started {
+		return nil, ErrStreamsFixed
+	}
+	s := &Stream{Type: streamType, PID: firstStreamPID + uint16(len(m.streams))}
+	if isVideo(streamType) {
+		s.streamID = 0xe0
+		if m.pcr == nil || !isVideo(m.pcr.Type) {
+			m.pcr = s
+		}
+	} else {
+		s.streamID = 0xc0
+		if m.pcr == nil {
+			m.pcr = s
+		}
+	}
+	m.streams = append(m.streams, s)
+	return s, nil
+}
+
+// WritePacket writes a packet of stream `s`.
+func (m *Muxer) WritePacket(s *Stream, p *Packet) error {
+	// PAT & PMT are repeated before key frames, so that players can start from there.
+	if !m.started || (s == m.pcr && p.Keyframe) {
+		m.started = true
+		if err := m.writeTables(); err != nil {
+			return err
+		}
+	}
+
+	pts, dts := timestamp(p.PTS), timestamp(p.DTS)
+	var pesHeader []byte
+	if isVideo(s.Type) && pts != dts {
+		pesHeader = make([]byte, 19)
+		pesHeader[7] = 0xc0
+		pesHeader[8] = 10
+		putTimestamp(pesHeader[9:], 0x3, pts)
+		putTimestamp(pesHeader[14:], 0x1, dts)
+	} else {
+		pesHeader = make([]byte, 14)
+		pesHeader[7] = 0x80
+		pesHeader[8] = 5
+		putTimestamp(pesHeader[9:], 0x2, pts)
+	}
+	pesHeader[2] = 0x01
+	pesHeader[3] = s.streamID
+	pesHeader[6] = 0x80
+	// Video PES packets are left unbounded, like ffmpeg does, as they can easily exceed the limit.
+	if length := len(pesHeader) - 6 + len(p.Data); !isVideo(s.Type) && length <= 0xffff {
+		pesHeader[4], pesHeader[5] = byte(length>>8), byte(length)
+	}
+
+	pcr := int64(-1)
+	if s == m.pcr {
+		pcr = timestamp(p.DTS-pcrDelay) * 300
+	}
+	return m.writePES(s, append(pesHeader, p.Data...), pcr, p.Keyframe)
+}
+
+// writePES splits a PES packet into TS packets and writes them. `pcr` is omitted if it's negative.
+func (m *Muxer) writePES(s *Stream, pes []byte, pcr int64, randomAccess bool) error {
+	for first := true; len(pes) > 0; first = false {
+		var adaptation []byte // Adaptation field without the length byte
+		hasAdaptation := false
+		if first && (pcr >= 0 || randomAccess) {
+			hasAdaptation = true
+			var flags byte
+			if randomAccess {
+				flags |= 0x40
+			}
+			if pcr >= 0 {
+				flags |= 0x10
+			}
+			adaptation = append(adaptation, flags)
+			if pcr >= 0 {
+				adaptation = append(adaptation, encodePCR(pcr)...)
+			}
+		}
+
+		space := PacketSize - 4
+		if hasAdaptation {
+			space -= 1 + len(adaptation)
+		}
+		// Fill up the last packet with stuffing bytes in the adaptation field.
+		if stuffing := space - len(pes); stuffing > 0 {
+			if !hasAdaptation {
+				hasAdaptation = true
+				stuffing--
+				if stuffing > 0 {
+					adaptation = append(adaptation, 0x00)
+					stuffing--
+				}
+			}
+			for ; stuffing > 0; stuffing-- {
+				adaptation = append(adaptation, 0xff)
+			}
+		}
+
+		pkt := m.buf[:]
+		pkt[0] = syncByte
+		pkt[1] = byte(s.PID>>8) & 0x1f
+		if first {
+			pkt[1] |= 0x40 // Payload unit start indicator
+		}
+		pkt[2] = byte(s.PID)
+		pkt[3] = 0x10 | s.cc
+		s.cc = (s.cc + 1) & 0x0f
+		pos := 4
+		if hasAdaptation {
+			pkt[3] |= 0x20
+			pkt[4] = byte(len(adaptation))
+			copy(pkt[5:], adaptation)
+			pos += 1 + len(adaptation)
+		}
+		n := copy(pkt[pos:], pes)
+		pes = pes[n:]
+
+		if _, err := m.w.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTables writes PAT and PMT.
+func (m *Muxer) writeTables() error {
+	pat := []byte{
+		programNumber >> 8, programNumber & 0xff,
+		0xe0 | pmtPID>>8, pmtPID & 0xff,
+	}
+	if err := m.writeSection(patPID, &m.patCC, 0x00, pat); err != nil {
+		return err
+	}
+
+	var pcrPID uint16 = 0x1fff
+	if m.pcr != nil {
+		pcrPID = m.pcr.PID
+	}
+	pmt := []byte{
+		0xe0 | byte(pcrPID>>8), byte(pcrPID),
+		0xf0, 0x00, // No program info
+	}
+	for _, s := range m.streams {
+		pmt = append(pmt, s.Type, 0xe0|byte(s.PID>>8), byte(s.PID), 0xf0, 0x00)
+	}
+	return m.writeSection(pmtPID, &m.pmtCC, 0x02, pmt)
+}
+
+// writeSection writes a PSI section in a single TS packet.
+// Transport stream ID of PAT and program number of PMT are both `programNumber`, which is fine for a single program stream.
+func (m *Muxer) writeSection(pid uint16, cc *uint8, tableID byte, body []byte) error {
+	// Section length counts from the ID field to the end of CRC.
+	length := 5 + len(body) + 4
+	section := append([]byte{
+		tableID,
+		0xb0 | byte(length>>8), byte(length),
+		programNumber >> 8, programNumber & 0xff,
+		0xc1,       // Version 0, current
+		0x00, 0x00, // Section number & last section number
+	}, body...)
+	crc := crc32(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	pkt := m.buf[:]
+	pkt[0] = syncByte
+	pkt[1] = 0x40 | byte(pid>>8)&0x1f
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | *cc
+	*cc = (*cc + 1) & 0x0f
+	pkt[4] = 0x00 // Pointer field
+	n := copy(pkt[5:], section)
+	for i := 5 + n; i < PacketSize; i++ {
+		pkt[i] = 0xff
+	}
+	_, err := m.w.Write(pkt)
+	return err
+}
+
+// timestamp converts `d` into 90kHz clock, with timestampOffset added.
+func timestamp(d time.Duration) int64 {
+	return int64(d+timestampOffset) * 9 / 100000 & timestampMask
+}
+
+// putTimestamp encodes PTS / DTS into 5 bytes of `b`.
+func putTimestamp(b []byte, prefix byte, ts int64) {
+	b[0] = prefix<<4 | byte(ts>>29)&0x0e | 0x01
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14) | 0x01
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 0x01
+}
+
+// encodePCR encodes PCR in 27MHz clock, the extension part is always 0 as PCR is derived from a 90kHz timestamp.
+func encodePCR(pcr int64) []byte {
+	base := pcr / 300
+	return []byte{
+		byte(base >> 25),
+		byte(base >> 17),
+		byte(base >> 9),
+		byte(base >> 1),
+		byte(base<<7) | 0x7e,
+		0x00,
+	}
+}
+
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+// crc32 calculates the CRC-32/MPEG-2 checksum used by PSI sections.
+func crc32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
@@ -0,0 +1,162 @@
+package mpegts
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// tsPacket is a parsed TS packet.
+type tsPacket struct {
+	pid        uint16
+	start      bool
+	cc         uint8
+	adaptation []byte
+	payload    []byte
+}
+
+func parsePackets(t *testing.T, data []byte) []tsPacket {
+	if !assert.Zero(t, len(data)%PacketSize) {
+		return nil
+	}
+	var packets []tsPacket
+	for ; len(data) > 0; data = data[PacketSize:] {
+		pkt := data[:PacketSize]
+		assert.Equal(t, byte(syncByte), pkt[0])
+		p := tsPacket{
+			pid:   uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2]),
+			start: pkt[1]&0x40 != 0,
+			cc:    pkt[3] & 0x0f,
+		}
+		pos := 4
+		if pkt[3]&0x20 != 0 {
+			p.adaptation = pkt[5 : 5+int(pkt[4])]
+			pos += 1 + int(pkt[4])
+		}
+		p.payload = pkt[pos:]
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+func TestCrc32(t *testing.T) {
+	// Check value of CRC-32/MPEG-2.
+	assert.Equal(t, uint32(0x0376e6e7), crc32([]byte("123456789")))
+}
+
+func TestPutTimestamp(t *testing.T) {
+	type testRow struct {
+		prefix   byte
+		ts       int64
+		expected []byte
+	}
+	rows := []testRow{
+		{0x2, 0, []byte{0x21, 0x00, 0x01, 0x00, 0x01}},
+		{0x2, 126000, []byte{0x21, 0x00, 0x07, 0xd8, 0x61}},
+		{0x3, timestampMask, []byte{0x3f, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, row := range rows {
+		b := make([]byte, 5)
+		putTimestamp(b, row.prefix, row.ts)
+		assert.Equal(t, row.expected, b, "ts=%d", row.ts)
+	}
+}
+
+func TestMuxer_WritePacket(t *testing.T) {
+	var buf bytes.Buffer
+	muxer := NewMuxer(&buf)
+	video, err := muxer.AddStream(StreamTypeH264)
+	assert.NoError(t, err)
+	audio, err := muxer.AddStream(StreamTypeAAC)
+	assert.NoError(t, err)
+
+	videoData := bytes.Repeat([]byte{0xab}, 1000)
+	audioData := bytes.Repeat([]byte{0xcd}, 170) // Exactly fills a packet with PES header
+	assert.NoError(t, muxer.WritePacket(video, &Packet{PTS: 40 * time.Millisecond, DTS: 0, Keyframe: true, Data: videoData}))
+	assert.NoError(t, muxer.WritePacket(audio, &Packet{PTS: 10 * time.Millisecond, DTS: 10 * time.Millisecond, Data: audioData}))
+	assert.NoError(t, muxer.WritePacket(video, &Packet{PTS: 40 * time.Millisecond, DTS: 40 * time.Millisecond, Data: videoData[:1]}))
+
+	_, err = muxer.AddStream(StreamTypeAAC)
+	assert.Equal(t, ErrStreamsFixed, err)
+
+	packets := parsePackets(t, buf.Bytes())
+	if !assert.NotEmpty(t, packets) {
+		return
+	}
+
+	// PAT & PMT are written only once, as the second video frame is not a key frame.
+	var pmt []byte
+	payloads := map[uint16][]byte{}
+	lastCC := map[uint16]uint8{}
+	for i, p := range packets {
+		if prev, ok := lastCC[p.pid]; ok {
+			assert.Equal(t, (prev+1)&0x0f, p.cc, "packet %d", i)
+		}
+		lastCC[p.pid] = p.cc
+		switch p.pid {
+		case patPID:
+			assert.Equal(t, 0, i)
+		case pmtPID:
+			assert.Equal(t, 1, i)
+			pmt = p.payload
+		default:
+			payloads[p.pid] = append(payloads[p.pid], p.payload...)
+		}
+	}
+
+	// PMT declares both streams, and PCR on the video stream.
+	if assert.NotNil(t, pmt) {
+		sectionLength := int(pmt[2]&0x0f)<<8 | int(pmt[3])
+		section := pmt[1 : 4+sectionLength]
+		assert.Equal(t, uint32(0), crc32(section), "CRC of a section including its CRC is 0")
+		assert.Equal(t, video.PID, uint16(section[8]&0x1f)<<8|uint16(section[9]))
+		assert.Equal(t, []byte{StreamTypeH264, 0xe1, 0x00, 0xf0, 0x00, StreamTypeAAC, 0xe1, 0x01, 0xf0, 0x00}, section[12:22])
+	}
+
+	// The first video packet carries PCR and random access indicator.
+	first := packets[2]
+	assert.Equal(t, video.PID, first.pid)
+	assert.True(t, first.start)
+	assert.Equal(t, byte(0x50), first.adaptation[0])
+	assert.Equal(t, encodePCR(timestamp(-pcrDelay)*300), first.adaptation[1:7])
+
+	// PES packets are reassembled intact.
+	videoPayload := payloads[video.PID]
+	assert.Equal(t, []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0xc0, 10}, videoPayload[:9])
+	assert.Equal(t, videoData, videoPayload[19:19+len(videoData)])
+	assert.Equal(t, []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 5}, videoPayload[19+len(videoData):28+len(videoData)])
+
+	audioPayload := payloads[audio.PID]
+	if assert.Len(t, audioPayload, 14+len(audioData)) {
+		assert.Equal(t, []byte{0x00, 0x00, 0x01, 0xc0, 0x00, 8 + 170, 0x80, 0x80, 5}, audioPayload[:9])
+		assert.Equal(t, audioData, audioPayload[14:])
+	}
+}
+
+func TestMuxer_AddStream(t *testing.T) {
+	type testRow struct {
+		streamTypes []uint8
+		pcrIndex    int
+	}
+	rows := []testRow{
+		{[]uint8{StreamTypeH264, StreamTypeAAC}, 0},
+		{[]uint8{StreamTypeAAC, StreamTypeHEVC}, 1},
+		{[]uint8{StreamTypeAAC, StreamTypeAAC}, 0},
+		{[]uint8{StreamTypeHEVC, StreamTypeH264}, 0},
+	}
+	for _, row := range rows {
+		muxer := NewMuxer(ioutil.Discard)
+		var streams []*Stream
+		for _, streamType := range row.streamTypes {
+			s, err := muxer.AddStream(streamType)
+			assert.NoError(t, err)
+			streams = append(streams, s)
+		}
+		assert.Equal(t, streams[row.pcrIndex], muxer.pcr, "%v", row.streamTypes)
+		for _, s := range streams {
+			assert.Equal(t, isVideo(s.Type), s.streamID == 0xe0, "%v", row.streamTypes)
+		}
+	}
+}
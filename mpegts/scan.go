@@ -0,0 +1,74 @@
+package mpegts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxTimestampJump is how far PTS of a stream may go between adjacent PES packets, before it's considered broken.
+const maxTimestampJump = 10 * time.Second
+
+var (
+	// ErrTruncated means the TS file ends in the middle of a packet.
+	ErrTruncated = errors.New("TS文件不完整")
+	// ErrCorrupted means the TS file has invalid packets or broken timestamps.
+	ErrCorrupted = errors.New("TS文件已损坏")
+)
+
+// Summary is the overview of a TS file.
+type Summary struct {
+	Packets      int   // TS packets read
+	MediaPackets int   // PES packets of audio / video streams with PTS
+	Size         int64 // Bytes read
+	Interrupted  error // Why reading stopped before the end of file, nil if the file is intact
+}
+
+// Scan reads through all packets from `r`, checking their sync bytes and timestamps, and summarizes them.
+// Problems of the packets are reported in `Summary.Interrupted`, the returned error is for failing to read.
+func Scan(r io.Reader) (*Summary, error) {
+	summary := &Summary{}
+	lastPTS := map[uint16]int64{}
+	maxJump := int64(maxTimestampJump * 90000 / time.Second)
+	pkt := make([]byte, PacketSize)
+	for {
+		n, err := io.ReadFull(r, pkt)
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			summary.Interrupted = fmt.Errorf("%w：位置%d的TS包只有%d字节", ErrTruncated, summary.Size, n)
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		offset := summary.Size
+		summary.Size += int64(n)
+		if pkt[0] != syncByte {
+			summary.Interrupted = fmt.Errorf("%w：位置%d的TS包缺少同步字节", ErrCorrupted, offset)
+			return summary, nil
+		}
+		summary.Packets++
+
+		ts := readPacketTimestamps(pkt)
+		if !ts.hasPTS {
+			continue
+		}
+		summary.MediaPackets++
+		if last, ok := lastPTS[ts.pid]; ok {
+			// Timestamps wrap around in 33 bits, so the jump is taken as the shorter way around.
+			jump := (ts.pts - last) & timestampMask
+			if jump > timestampMask/2 {
+				jump -= timestampMask + 1
+			}
+			if jump > maxJump || jump < -maxJump {
+				summary.Interrupted = fmt.Errorf("%w：位置%d的时间戳跳变%v", ErrCorrupted, offset, time.Duration(jump)*time.Second/90000)
+				return summary, nil
+			}
+		}
+		lastPTS[ts.pid] = ts.pts
+	}
+}
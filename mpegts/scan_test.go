@@ -0,0 +1,64 @@
+package mpegts
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// muxFrames muxes a video and an audio frame at each of `timestamps` into TS media.
+func muxFrames(t *testing.T, timestamps ...time.Duration) []byte {
+	var buf bytes.Buffer
+	muxer := NewMuxer(&buf)
+	video, _ := muxer.AddStream(StreamTypeH264)
+	audio, _ := muxer.AddStream(StreamTypeAAC)
+	frame := make([]byte, 500)
+	for i, ts := range timestamps {
+		assert.NoError(t, muxer.WritePacket(video, &Packet{PTS: ts + 80*time.Millisecond, DTS: ts, Keyframe: i == 0, Data: frame}))
+		assert.NoError(t, muxer.WritePacket(audio, &Packet{PTS: ts, DTS: ts, Data: frame[:100]}))
+	}
+	return buf.Bytes()
+}
+
+func TestScan(t *testing.T) {
+	var steady []time.Duration
+	for i := 0; i < 100; i++ {
+		steady = append(steady, time.Duration(i)*40*time.Millisecond)
+	}
+	intact := muxFrames(t, steady...)
+	corrupted := append([]byte{}, intact...)
+	corrupted[PacketSize*3] = 0
+
+	type testRow struct {
+		name        string
+		data        []byte
+		media       int
+		interrupted error // nil if the media is intact
+	}
+	rows := []testRow{
+		{"empty", nil, 0, nil},
+		{"intact", intact, 200, nil},
+		{"wrapped timestamps", muxFrames(t, 26*time.Hour+30*time.Minute+42*time.Second, 26*time.Hour+30*time.Minute+44*time.Second), 4, nil},
+		{"truncated", intact[:len(intact)-10], 200, ErrTruncated},
+		{"missing sync byte", corrupted, 0, ErrCorrupted},
+		{"timestamps jump forward", muxFrames(t, 0, 40*time.Millisecond, time.Minute), 4, ErrCorrupted},
+		{"timestamps jump backward", muxFrames(t, time.Minute, time.Minute+40*time.Millisecond, 0), 4, ErrCorrupted},
+		{"not TS media", []byte("not really TS media"), 0, ErrTruncated},
+	}
+	for _, row := range rows {
+		summary, err := Scan(bytes.NewReader(row.data))
+		if !assert.NoError(t, err, row.name) {
+			continue
+		}
+		if row.interrupted == nil {
+			assert.NoError(t, summary.Interrupted, row.name)
+			assert.Equal(t, row.media, summary.MediaPackets, row.name)
+			assert.Equal(t, int64(len(row.data)), summary.Size, row.name)
+			assert.Equal(t, len(row.data)/PacketSize, summary.Packets, row.name)
+		} else {
+			assert.True(t, errors.Is(summary.Interrupted, row.interrupted), "%s: %v", row.name, summary.Interrupted)
+		}
+	}
+}
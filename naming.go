@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultDirTemplate is the default layout of record directories, relative to the output directory.
+const defaultDirTemplate = "{uid}-{uname}/{start}-{title}-{rid}"
+
+// defaultNameTemplate is the default name of merged recording files, without extension.
+const defaultNameTemplate = "{start}-{rid}-{title}-{quality}-{part}"
+
+// namingValues returns values of placeholders available in naming templates.
+// `part` describes which parts are merged into the file, e.g. `complete`.
+func namingValues(p *DownloadParam, part string) map[string]interface{} {
+	return map[string]interface{}{
+		"uid":     p.Liver.UserID,
+		"uname":   p.Liver.UserName,
+		"room":    p.Info.RoomID,
+		"title":   p.Info.Title,
+		"rid":     p.RecordID,
+		"start":   p.Info.Start.Local(),
+		"end":     p.Info.End.Local(),
+		"quality": p.Parts.Quality(),
+		"part":    part,
+	}
+}
+
+// validateTemplates checks naming templates for bad syntax or unknown placeholders, before any record is processed.
+func validateTemplates(dirTemplate, nameTemplate string) error {
+	values := namingValues(&DownloadParam{Info: &models.LiveRecordInfo{}, Liver: &models.LiverInfo{}, Parts: &models.RecordParts{}}, "")
+	if _, err := helper.ExpandTemplate(dirTemplate, values, nil); err != nil {
+		return err
+	}
+	if _, err := helper.ExpandTemplate(nameTemplate, values, nil); err != nil {
+		return err
+	}
+	if strings.ContainsAny(nameTemplate, "/\\") {
+		return errors.New("文件名模板中不能包含目录分隔符")
+	}
+	return nil
+}
+
+// recordDirectory generates the directory to download record described by `p` into.
+func recordDirectory(p *DownloadParam) (string, error) {
+	outputDir := p.OutputDir
+	if outputDir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		outputDir = cwd
+	}
+
+	dirTemplate := p.DirTemplate
+	if dirTemplate == "" {
+		dirTemplate = defaultDirTemplate
+	}
+	relDir, err := helper.ExpandTemplate(filepath.ToSlash(dirTemplate), namingValues(p, ""), helper.SanitizeFileName)
+	if err != nil {
+		return "", err
+	}
+
+	// Values are sanitized already, but a component might still be too long or end with dots after expansion.
+	components := []string{outputDir}
+	for _, component := range strings.Split(relDir, "/") {
+		if component != "" {
+			components = append(components, helper.SanitizeFileName(component))
+		}
+	}
+	return filepath.Join(components...), nil
+}
+
+// mergedFileName generates name of the merged recording file (without extension), `part` describes which parts are merged.
+func mergedFileName(p *DownloadParam, part string) (string, error) {
+	nameTemplate := p.NameTemplate
+	if nameTemplate == "" {
+		nameTemplate = defaultNameTemplate
+	}
+	name, err := helper.ExpandTemplate(nameTemplate, namingValues(p, part), helper.SanitizeFileName)
+	if err != nil {
+		return "", err
+	}
+	return helper.SanitizeFileName(name), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNamingParam returns download parameters of a record titled `title`, saved into `outputDir` with `dirTemplate`.
+func testNamingParam(title, outputDir, dirTemplate string) *DownloadParam {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, timezone)
+	return &DownloadParam{
+		RecordID:    "R1",
+		OutputDir:   outputDir,
+		DirTemplate: dirTemplate,
+		Info:        &models.LiveRecordInfo{RoomID: 100, Title: title, Start: helper.JSONTime{Time: start}, End: helper.JSONTime{Time: start.Add(time.Hour)}},
+		Liver:       &models.LiverInfo{UserID: 123, UserName: "主播"},
+		Parts:       &models.RecordParts{CurrentQualityNumber: 10000, Qualities: []models.Quality{{Number: 10000, Name: "原画"}}},
+	}
+}
+
+func TestRecordDirectory(t *testing.T) {
+	outputDir := filepath.FromSlash("/mnt/bilibili")
+	startStr := strings.ReplaceAll(testNamingParam("", "", "").Info.Start.String(), ":", "-")
+
+	type testRow struct {
+		title       string
+		dirTemplate string
+		expected    []string // Components relative to the output directory
+	}
+	testData := []testRow{
+		// Same layout as before templates were introduced.
+		{"标题", "", []string{"123-主播", fmt.Sprintf("%s-标题-R1", startStr)}},
+		{"标题", defaultDirTemplate, []string{"123-主播", fmt.Sprintf("%s-标题-R1", startStr)}},
+		// Separators in values never create directories.
+		{"a/b\\c", "", []string{"123-主播", fmt.Sprintf("%s-a_b_c-R1", startStr)}},
+		{"a/b", "{room}/{title}", []string{"100", "a_b"}},
+		{"..", "{title}", []string{"_"}},
+		// Nor do `..` components escape the output directory.
+		{"标题", "../{title}", []string{"_", "标题"}},
+		{"标题", "{uname}/../../{rid}", []string{"主播", "_", "_", "R1"}},
+		{"标题", "/{start:2006}//{rid}/", []string{"2021", "R1"}},
+	}
+
+	for _, row := range testData {
+		dir, err := recordDirectory(testNamingParam(row.title, outputDir, row.dirTemplate))
+		if assert.NoError(t, err, row.dirTemplate) {
+			assert.Equal(t, filepath.Join(append([]string{outputDir}, row.expected...)...), dir, row.dirTemplate)
+		}
+	}
+
+	// The current directory is used if there's no output directory.
+	cwd, _ := os.Getwd()
+	dir, err := recordDirectory(testNamingParam("标题", "", "{rid}"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(cwd, "R1"), dir)
+
+	_, err = recordDirectory(testNamingParam("标题", outputDir, "{unknown}"))
+	assert.Error(t, err)
+}
+
+func TestMergedFileName(t *testing.T) {
+	p := testNamingParam("标题", "", "")
+	startStr := strings.ReplaceAll(p.Info.Start.String(), ":", "-")
+
+	// Same name as before templates were introduced.
+	name, err := mergedFileName(p, "complete")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s-R1-标题-原画-complete", startStr), name)
+
+	p = testNamingParam("a/b:c", "", "")
+	name, err = mergedFileName(p, "parts-3-5")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s-R1-a_b-c-原画-parts-3-5", startStr), name)
+
+	p.NameTemplate = "{title}-{start:2006-01-02}-{part}"
+	name, err = mergedFileName(p, "..")
+	assert.NoError(t, err)
+	assert.Equal(t, "a_b-c-2021-03-01-_", name)
+}
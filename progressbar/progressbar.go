@@ -25,8 +25,13 @@ const (
 var defaultManager *uiprogress.Progress
 var initGuard sync.Once
 
+// Init sets up the progress bar manager rendering into `output`.
+// Pass nil to disable progress bars, bars added later still work, but they're neither kept nor rendered.
 func Init(output io.Writer) {
 	initGuard.Do(func() {
+		if output == nil {
+			return
+		}
 		defaultManager = uiprogress.New()
 		defaultManager.RefreshInterval = defaultFreshRate
 		defaultManager.SetOut(output)
@@ -121,8 +126,8 @@ func (b *ProgressBar) appendDecorator(bar *uiprogress.Bar) string {
 		break
 	case UnitTypeDuration:
 		// 1024h60m59.09s
-		values[0] = helper.Duration{time.Duration(int64(b.Current()))}.String()
-		values[1] = helper.Duration{time.Duration(int64(b.Total))}.String()
+		values[0] = helper.Duration{Duration: time.Duration(int64(b.Current()))}.String()
+		values[1] = helper.Duration{Duration: time.Duration(int64(b.Total))}.String()
 		break
 	default:
 		return ""
@@ -140,7 +145,13 @@ func AddProgressBar(total int64) *ProgressBar {
 		barTotal = TotalPlaceholder
 	}
 
-	bar := defaultManager.AddBar(int(barTotal))
+	var bar *uiprogress.Bar
+	if defaultManager != nil {
+		bar = defaultManager.AddBar(int(barTotal))
+	} else {
+		// Progress bars are disabled, don't keep track of it, or bars of a long-running process would pile up.
+		bar = uiprogress.NewBar(int(barTotal))
+	}
 	pbar := &ProgressBar{
 		Bar:       bar,
 		totalSet:  total != -1,
@@ -0,0 +1,347 @@
+// Package remux converts FLV records into MPEG-TS without ffmpeg,
+// doing the same as `ffmpeg -i input.flv -c copy -bsf:v h264_mp4toannexb -f mpegts output.ts` (or `hevc_mp4toannexb` for HEVC).
+package remux
+
+import (
+	"bililive-downloader/flv"
+	"bililive-downloader/mpegts"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// probeTagLimit limits how many media tags are read to find out which streams the record has.
+	probeTagLimit = 200
+	// progressInterval is how often progress is reported, in media time.
+	progressInterval = time.Second
+)
+
+// nalTypes of H.264.
+const (
+	nalTypeIDR = 5
+	nalTypeSPS = 7
+	nalTypeAUD = 9
+)
+
+// nalTypes of HEVC.
+const (
+	hevcNalTypeIRAPFirst = 16 // BLA, IDR & CRA pictures are 16 ~ 23
+	hevcNalTypeIRAPLast  = 23
+	hevcNalTypeVPS       = 32
+	hevcNalTypeSPS       = 33
+	hevcNalTypePPS       = 34
+	hevcNalTypeAUD       = 35
+)
+
+var (
+	startCode       = []byte{0x00, 0x00, 0x00, 0x01}
+	accessDelim     = []byte{0x00, 0x00, 0x00, 0x01, nalTypeAUD, 0xf0}
+	hevcAccessDelim = []byte{0x00, 0x00, 0x00, 0x01, hevcNalTypeAUD << 1, 0x01, 0x50}
+)
+
+// ErrNoMedia means there are no audio or video streams in the record.
+var ErrNoMedia = errors.New("没有找到音视频数据")
+
+// Stats is the overview of remuxed media.
+type Stats struct {
+	VideoFrames int
+	AudioFrames int
+	FirstTime   time.Duration // Timestamp of the first frame
+	LastTime    time.Duration // Timestamp of the last frame
+}
+
+// Duration is the time span between first and last frames.
+func (s *Stats) Duration() time.Duration {
+	return s.LastTime - s.FirstTime
+}
+
+// ProgressFunc is called with duration of the media remuxed so far.
+type ProgressFunc func(current time.Duration)
+
+// remuxer holds state of remuxing a single record.
+type remuxer struct {
+	muxer    *mpegts.Muxer
+	video    *mpegts.Stream
+	audio    *mpegts.Stream
+	config   *flv.DecoderConfig // Of the video stream
+	aac      *flv.AACConfig
+	stats    Stats
+	progress ProgressFunc
+	reported time.Duration
+}
+
+// FlvToTs reads FLV from `r` and writes MPEG-TS into `w`.
+// Errors wrapping flv.ErrUnsupportedCodec are returned if the record is not H.264 / HEVC and AAC encoded.
+func FlvToTs(ctx context.Context, r io.Reader, w io.Writer, progress ProgressFunc) (*Stats, error) {
+	reader, err := flv.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	rm := &remuxer{muxer: mpegts.NewMuxer(w), progress: progress}
+
+	// Streams of a TS file are declared up-front, so look for sequence headers before writing anything.
+	var pending []*flv.Tag
+	eof := false
+	for mediaTags := 0; mediaTags < probeTagLimit && (rm.config == nil || rm.aac == nil); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tag, err := reader.ReadTag()
+		if err == io.EOF {
+			eof = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tag.Type != flv.TagTypeVideo && tag.Type != flv.TagTypeAudio {
+			continue
+		}
+		mediaTags++
+		pending = append(pending, tag)
+		if err := rm.probe(tag); err != nil {
+			return nil, err
+		}
+	}
+	if err := rm.addStreams(); err != nil {
+		return nil, err
+	}
+
+	for _, tag := range pending {
+		if err := rm.writeTag(tag); err != nil {
+			return nil, err
+		}
+	}
+	for !eof {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tag, err := reader.ReadTag()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := rm.writeTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	if rm.stats.VideoFrames == 0 && rm.stats.AudioFrames == 0 {
+		return nil, ErrNoMedia
+	}
+	return &rm.stats, nil
+}
+
+// FlvFileToTs remuxes FLV file `input` into TS file `output`, `output` is removed if remuxing fails.
+func FlvFileToTs(ctx context.Context, input, output string, progress ProgressFunc) (*Stats, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	stats, err := FlvToTs(ctx, bufio.NewReaderSize(in, 1<<20), w, progress)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(output)
+		return nil, err
+	}
+	return stats, nil
+}
+
+// probe finds out codec configs from sequence headers.
+func (rm *remuxer) probe(tag *flv.Tag) error {
+	switch tag.Type {
+	case flv.TagTypeVideo:
+		packet, err := flv.ParseVideoTag(tag.Data)
+		if err != nil {
+			return err
+		}
+		if packet.PacketType == flv.PacketTypeSequenceHeader && rm.config == nil {
+			rm.config, err = flv.ParseDecoderConfig(packet.CodecID, packet.Data)
+		}
+		return err
+	case flv.TagTypeAudio:
+		packet, err := flv.ParseAudioTag(tag.Data)
+		if err != nil {
+			return err
+		}
+		if packet.PacketType == flv.PacketTypeSequenceHeader && rm.aac == nil {
+			rm.aac, err = flv.ParseAACConfig(packet.Data)
+		}
+		return err
+	}
+	return nil
+}
+
+// addStreams adds streams found while probing into the muxer.
+func (rm *remuxer) addStreams() (err error) {
+	if rm.config != nil {
+		streamType := uint8(mpegts.StreamTypeH264)
+		if rm.config.CodecID == flv.VideoCodecHEVC {
+			streamType = mpegts.StreamTypeHEVC
+		}
+		if rm.video, err = rm.muxer.AddStream(streamType); err != nil {
+			return
+		}
+	}
+	if rm.aac != nil {
+		if rm.audio, err = rm.muxer.AddStream(mpegts.StreamTypeAAC); err != nil {
+			return
+		}
+	}
+	if rm.video == nil && rm.audio == nil {
+		return ErrNoMedia
+	}
+	return
+}
+
+// writeTag writes frames in the tag. Frames of streams not found while probing, or before their sequence headers, are dropped.
+func (rm *remuxer) writeTag(tag *flv.Tag) error {
+	var packet *mpegts.Packet
+	var stream *mpegts.Stream
+	switch tag.Type {
+	case flv.TagTypeVideo:
+		video, err := flv.ParseVideoTag(tag.Data)
+		if err != nil {
+			return err
+		}
+		switch video.PacketType {
+		case flv.PacketTypeSequenceHeader:
+			// Resolution might change in the middle of a record, but not the codec, as streams of TS are fixed.
+			config, err := flv.ParseDecoderConfig(video.CodecID, video.Data)
+			if err != nil {
+				return err
+			}
+			if rm.config != nil && config.CodecID != rm.config.CodecID {
+				return fmt.Errorf("%w：位置%d的视频编码发生变化", flv.ErrUnsupportedCodec, tag.Offset)
+			}
+			rm.config = config
+			return nil
+		case flv.PacketTypeData:
+			if rm.video == nil || rm.config == nil || video.CodecID != rm.config.CodecID {
+				return nil
+			}
+			data, err := rm.annexB(video.Data)
+			if err != nil {
+				return fmt.Errorf("位置%d的视频帧：%w", tag.Offset, err)
+			}
+			stream = rm.video
+			packet = &mpegts.Packet{PTS: tag.Timestamp + video.CompositionTime, DTS: tag.Timestamp, Keyframe: video.Keyframe, Data: data}
+			rm.stats.VideoFrames++
+		default:
+			return nil
+		}
+	case flv.TagTypeAudio:
+		audio, err := flv.ParseAudioTag(tag.Data)
+		if err != nil {
+			return err
+		}
+		switch audio.PacketType {
+		case flv.PacketTypeSequenceHeader:
+			if rm.aac, err = flv.ParseAACConfig(audio.Data); err != nil {
+				return err
+			}
+			return nil
+		case flv.PacketTypeData:
+			if rm.audio == nil || rm.aac == nil {
+				return nil
+			}
+			data := append(rm.aac.ADTSHeader(len(audio.Data)), audio.Data...)
+			stream = rm.audio
+			packet = &mpegts.Packet{PTS: tag.Timestamp, DTS: tag.Timestamp, Data: data}
+			rm.stats.AudioFrames++
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if rm.stats.VideoFrames+rm.stats.AudioFrames == 1 {
+		rm.stats.FirstTime = tag.Timestamp
+	}
+	if tag.Timestamp > rm.stats.LastTime {
+		rm.stats.LastTime = tag.Timestamp
+	}
+	if current := rm.stats.Duration(); rm.progress != nil && current-rm.reported >= progressInterval {
+		rm.progress(current)
+		rm.reported = current
+	}
+	return rm.muxer.WritePacket(stream, packet)
+}
+
+// annexB converts length-prefixed NAL units into an Annex-B access unit.
+// Like h264_mp4toannexb / hevc_mp4toannexb, parameter sets are inserted before random access pictures if they're not in the stream,
+// and like ffmpeg's mpegts muxer, AUD is added if missing.
+func (rm *remuxer) annexB(data []byte) ([]byte, error) {
+	units, err := flv.SplitNALUnits(data, rm.config.LengthSize)
+	if err != nil {
+		return nil, err
+	}
+
+	hevc := rm.config.CodecID == flv.VideoCodecHEVC
+	var hasAUD, hasParameterSets, isRandomAccess bool
+	for _, unit := range units {
+		if len(unit) == 0 {
+			continue
+		}
+		if hevc {
+			switch nalType := (unit[0] >> 1) & 0x3f; {
+			case nalType == hevcNalTypeAUD:
+				hasAUD = true
+			case nalType == hevcNalTypeVPS || nalType == hevcNalTypeSPS || nalType == hevcNalTypePPS:
+				hasParameterSets = true
+			case nalType >= hevcNalTypeIRAPFirst && nalType <= hevcNalTypeIRAPLast:
+				isRandomAccess = true
+			}
+		} else {
+			switch unit[0] & 0x1f {
+			case nalTypeAUD:
+				hasAUD = true
+			case nalTypeSPS:
+				hasParameterSets = true
+			case nalTypeIDR:
+				isRandomAccess = true
+			}
+		}
+	}
+
+	out := make([]byte, 0, len(data)+len(hevcAccessDelim)+64)
+	if !hasAUD {
+		if hevc {
+			out = append(out, hevcAccessDelim...)
+		} else {
+			out = append(out, accessDelim...)
+		}
+	}
+	if isRandomAccess && !hasParameterSets {
+		for _, set := range rm.config.ParameterSets {
+			out = append(append(out, startCode...), set...)
+		}
+	}
+	for _, unit := range units {
+		if len(unit) > 0 {
+			out = append(append(out, startCode...), unit...)
+		}
+	}
+	return out, nil
+}
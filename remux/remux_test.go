@@ -0,0 +1,238 @@
+package remux
+
+import (
+	"bililive-downloader/flv"
+	"bililive-downloader/mpegts"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x28, 0xac}
+	testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00}
+	testP   = []byte{0x41, 0x9a, 0x02}
+	testAAC = []byte{0x21, 0x10, 0x04, 0x60}
+
+	testVPS      = []byte{0x40, 0x01, 0x0c}
+	testHEVCSPS  = []byte{0x42, 0x01, 0x01}
+	testHEVCPPS  = []byte{0x44, 0x01, 0xc1}
+	testHEVCIDR  = []byte{0x26, 0x01, 0xaf}
+	testHEVCTail = []byte{0x02, 0x01, 0xd0}
+)
+
+type testTag struct {
+	tagType   uint8
+	timestamp uint32
+	data      []byte
+}
+
+func buildFlv(tags ...testTag) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0})
+	for _, tag := range tags {
+		size := uint32(len(tag.data))
+		buf.Write([]byte{
+			tag.tagType,
+			byte(size >> 16), byte(size >> 8), byte(size),
+			byte(tag.timestamp >> 16), byte(tag.timestamp >> 8), byte(tag.timestamp), byte(tag.timestamp >> 24),
+			0, 0, 0,
+		})
+		buf.Write(tag.data)
+		_ = binary.Write(&buf, binary.BigEndian, 11+size)
+	}
+	return buf.Bytes()
+}
+
+func avcSequenceHeader() testTag {
+	data := []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0x00, 0x28, 0xff, 0xe1, 0, byte(len(testSPS))}
+	data = append(data, testSPS...)
+	data = append(data, 1, 0, byte(len(testPPS)))
+	data = append(data, testPPS...)
+	return testTag{flv.TagTypeVideo, 0, data}
+}
+
+func avcFrame(timestamp uint32, keyframe bool, cts int32, units ...[]byte) testTag {
+	header := byte(0x27)
+	if keyframe {
+		header = 0x17
+	}
+	data := []byte{header, 1, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	for _, unit := range units {
+		data = append(data, 0, 0, 0, byte(len(unit)))
+		data = append(data, unit...)
+	}
+	return testTag{flv.TagTypeVideo, timestamp, data}
+}
+
+// hevcSequenceHeader builds an enhanced RTMP sequence start tag.
+func hevcSequenceHeader() testTag {
+	data := append([]byte{0x90, 'h', 'v', 'c', '1'}, make([]byte, 21)...)
+	data = append(data, 0xff, 3)
+	for _, set := range [][]byte{testVPS, testHEVCSPS, testHEVCPPS} {
+		data = append(data, (set[0]>>1)&0x3f, 0, 1, 0, byte(len(set)))
+		data = append(data, set...)
+	}
+	return testTag{flv.TagTypeVideo, 0, data}
+}
+
+// hevcFrame builds an enhanced RTMP coded frames tag.
+func hevcFrame(timestamp uint32, keyframe bool, cts int32, units ...[]byte) testTag {
+	header := byte(0xa1)
+	if keyframe {
+		header = 0x91
+	}
+	data := []byte{header, 'h', 'v', 'c', '1', byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	for _, unit := range units {
+		data = append(data, 0, 0, 0, byte(len(unit)))
+		data = append(data, unit...)
+	}
+	return testTag{flv.TagTypeVideo, timestamp, data}
+}
+
+func aacSequenceHeader() testTag {
+	// AAC-LC, 48kHz, stereo
+	return testTag{flv.TagTypeAudio, 0, []byte{0xaf, 0, 0x11, 0x90}}
+}
+
+func aacFrame(timestamp uint32) testTag {
+	return testTag{flv.TagTypeAudio, timestamp, append([]byte{0xaf, 1}, testAAC...)}
+}
+
+// demuxPES extracts PES payloads of each PID from TS data, `pid -> []payload`.
+func demuxPES(t *testing.T, data []byte) map[uint16][][]byte {
+	result := map[uint16][][]byte{}
+	for ; len(data) >= 188; data = data[188:] {
+		pkt := data[:188]
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		if pid < 0x100 || pid == 0x1000 {
+			continue
+		}
+		pos := 4
+		if pkt[3]&0x20 != 0 {
+			pos += 1 + int(pkt[4])
+		}
+		if pkt[1]&0x40 != 0 {
+			result[pid] = append(result[pid], nil)
+		}
+		last := len(result[pid]) - 1
+		result[pid][last] = append(result[pid][last], pkt[pos:]...)
+	}
+	assert.Empty(t, data)
+
+	// Strip PES headers.
+	for _, payloads := range result {
+		for i, pes := range payloads {
+			payloads[i] = pes[9+int(pes[8]):]
+		}
+	}
+	return result
+}
+
+func annexB(units ...[]byte) []byte {
+	return annexBWithAUD([]byte{9, 0xf0}, units...)
+}
+
+func annexBWithAUD(aud []byte, units ...[]byte) []byte {
+	out := append([]byte{0, 0, 0, 1}, aud...)
+	for _, unit := range units {
+		out = append(append(out, 0, 0, 0, 1), unit...)
+	}
+	return out
+}
+
+func TestFlvToTs(t *testing.T) {
+	input := buildFlv(
+		testTag{flv.TagTypeScript, 0, []byte{2, 0, 10}},
+		avcSequenceHeader(),
+		aacSequenceHeader(),
+		avcFrame(0, true, 40, testIDR),
+		aacFrame(10),
+		avcFrame(40, false, 0, testP),
+		aacFrame(2010),
+	)
+
+	var output bytes.Buffer
+	var progress []time.Duration
+	stats, err := FlvToTs(context.Background(), bytes.NewReader(input), &output, func(current time.Duration) {
+		progress = append(progress, current)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &Stats{VideoFrames: 2, AudioFrames: 2, FirstTime: 0, LastTime: 2010 * time.Millisecond}, stats)
+	assert.Equal(t, []time.Duration{2010 * time.Millisecond}, progress)
+
+	streams := demuxPES(t, output.Bytes())
+	assert.Equal(t, [][]byte{
+		annexB(testSPS, testPPS, testIDR), // Parameter sets are inserted before IDR frames
+		annexB(testP),
+	}, streams[0x100])
+
+	adts := []byte{0xff, 0xf1, 0x4c, 0x80, 0x01, 0x7f, 0xfc}
+	assert.Equal(t, [][]byte{append(adts, testAAC...), append(adts, testAAC...)}, streams[0x101])
+}
+
+func TestFlvToTs_HEVC(t *testing.T) {
+	input := buildFlv(
+		hevcSequenceHeader(),
+		aacSequenceHeader(),
+		hevcFrame(0, true, 40, testHEVCIDR),
+		aacFrame(10),
+		hevcFrame(40, false, 0, testHEVCTail),
+		// Frames with parameter sets in the stream are kept as they are.
+		hevcFrame(80, true, 0, testVPS, testHEVCSPS, testHEVCPPS, testHEVCIDR),
+	)
+
+	var output bytes.Buffer
+	stats, err := FlvToTs(context.Background(), bytes.NewReader(input), &output, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, stats.VideoFrames)
+	assert.Equal(t, 1, stats.AudioFrames)
+
+	// Stream type of the video in PMT.
+	pmt := output.Bytes()[188:376]
+	assert.Equal(t, byte(mpegts.StreamTypeHEVC), pmt[5+12])
+
+	aud := []byte{0x46, 0x01, 0x50}
+	streams := demuxPES(t, output.Bytes())
+	assert.Equal(t, [][]byte{
+		annexBWithAUD(aud, testVPS, testHEVCSPS, testHEVCPPS, testHEVCIDR),
+		annexBWithAUD(aud, testHEVCTail),
+		annexBWithAUD(aud, testVPS, testHEVCSPS, testHEVCPPS, testHEVCIDR),
+	}, streams[0x100])
+}
+
+func TestFlvToTs_Errors(t *testing.T) {
+	type testRow struct {
+		name     string
+		input    []byte
+		expected error
+	}
+	rows := []testRow{
+		{"AV1", buildFlv(testTag{flv.TagTypeVideo, 0, []byte{0x90, 'a', 'v', '0', '1'}}), flv.ErrUnsupportedCodec},
+		{"codec changed", buildFlv(avcSequenceHeader(), hevcSequenceHeader()), flv.ErrUnsupportedCodec},
+		{"MP3", buildFlv(testTag{flv.TagTypeAudio, 0, []byte{0x2f, 0xff}}), flv.ErrUnsupportedCodec},
+		{"no media", buildFlv(testTag{flv.TagTypeScript, 0, []byte{2, 0, 10}}), ErrNoMedia},
+		{"no sequence header", buildFlv(avcFrame(0, true, 0, testIDR)), ErrNoMedia},
+		{"truncated", buildFlv(avcSequenceHeader(), avcFrame(0, true, 0, testIDR))[:60], flv.ErrTruncated},
+		{"bad NAL unit length", buildFlv(avcSequenceHeader(), testTag{flv.TagTypeVideo, 0, []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 9, 0x65}}), flv.ErrCorrupted},
+		{"not FLV", []byte("not an FLV file"), flv.ErrInvalidHeader},
+	}
+	for _, row := range rows {
+		_, err := FlvToTs(context.Background(), bytes.NewReader(row.input), &bytes.Buffer{}, nil)
+		assert.True(t, errors.Is(err, row.expected), "%s: %v", row.name, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := FlvToTs(ctx, bytes.NewReader(buildFlv(avcSequenceHeader(), avcFrame(0, true, 0, testIDR))), &bytes.Buffer{}, nil)
+	assert.Equal(t, context.Canceled, err)
+}
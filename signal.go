@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// withInterrupt returns a context which is cancelled on the first SIGINT or SIGTERM,
+// so that running tasks can stop gracefully and save their state. A second signal exits immediately.
+func withInterrupt(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			logger.Warn().Msg("收到中断信号，正在停止并保存下载状态，再次按Ctrl-C强制退出")
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+			return
+		}
+
+		<-signals
+		logger.Error().Msg("强制退出")
+		os.Exit(returnCodeInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
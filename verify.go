@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bililive-downloader/ffmpeg"
+	"bililive-downloader/flv"
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"bililive-downloader/mpegts"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// verifyFlvFile checks integrity of a raw FLV file: its size (ignored if `expectedSize` is 0), tag structure, and errors reported by ffmpeg.
+// Problems found are reported in the verdict, the returned error is for failing to verify at all.
+func verifyFlvFile(ctx context.Context, filePath string, expectedSize int64, decode bool) (*models.Verdict, error) {
+	verdict := &models.Verdict{File: filepath.Base(filePath), Decoded: decode}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if expectedSize > 0 && info.Size() != expectedSize {
+		verdict.Problems = append(verdict.Problems, fmt.Sprintf("文件大小不正确：期望%d字节，实际%d字节", expectedSize, info.Size()))
+	}
+
+	summary, err := flv.Scan(f)
+	switch {
+	case err != nil:
+		verdict.Problems = append(verdict.Problems, err.Error())
+	case summary.Interrupted != nil:
+		verdict.Problems = append(verdict.Problems, summary.Interrupted.Error())
+	case summary.VideoTags == 0:
+		verdict.Problems = append(verdict.Problems, "没有视频数据")
+	}
+
+	return verdict, scanMediaErrors(ctx, filePath, verdict)
+}
+
+// verifyTsFile checks integrity of a de-capped TS file: its fingerprint (ignored if `fingerprint` is empty), and errors reported by ffmpeg.
+// Without ffmpeg, its packet structure and timestamps are checked instead.
+func verifyTsFile(ctx context.Context, filePath, fingerprint string, decode bool) (*models.Verdict, error) {
+	verdict := &models.Verdict{File: filepath.Base(filePath), Decoded: decode}
+
+	if fingerprint != "" {
+		actual, err := helper.FileFingerprint(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if actual != fingerprint {
+			verdict.Problems = append(verdict.Problems, "文件大小或修改时间与下载时记录的不一致")
+		}
+	}
+
+	if !ffmpeg.Available() {
+		if err := scanTsStructure(filePath, verdict); err != nil {
+			return nil, err
+		}
+	}
+	return verdict, scanMediaErrors(ctx, filePath, verdict)
+}
+
+// scanTsStructure adds problems of packets and timestamps in the TS file into `verdict`.
+func scanTsStructure(filePath string, verdict *models.Verdict) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	summary, err := mpegts.Scan(bufio.NewReader(f))
+	switch {
+	case err != nil:
+		return err
+	case summary.Interrupted != nil:
+		verdict.Problems = append(verdict.Problems, summary.Interrupted.Error())
+	case summary.MediaPackets == 0:
+		verdict.Problems = append(verdict.Problems, "没有音视频数据")
+	}
+	return nil
+}
+
+// scanMediaErrors adds errors ffmpeg finds in the media file into `verdict`, and concludes the verdict.
+// The scan is skipped if ffmpeg is not available.
+func scanMediaErrors(ctx context.Context, filePath string, verdict *models.Verdict) error {
+	if ffmpeg.Available() {
+		messages, err := ffmpeg.ScanErrors(ctx, filePath, verdict.Decoded)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			verdict.Problems = append(verdict.Problems, "ffmpeg: "+message)
+		}
+	} else {
+		verdict.Decoded = false
+	}
+
+	verdict.OK = len(verdict.Problems) == 0
+	verdict.VerifiedAt = time.Now()
+	return nil
+}
+
+// partVerification is the verification result of a part, as displayed by `verify` subcommand.
+type partVerification struct {
+	PartNumber int             `json:"part"`
+	Skipped    string          `json:"skipped,omitempty"` // Why the part is not verified
+	Verdict    *models.Verdict `json:"verdict,omitempty"`
+}
+
+// verifyRecordPart verifies files of given part in record directory `dir`.
+// The raw FLV file is verified if it's still there, otherwise the de-capped TS file.
+func verifyRecordPart(ctx context.Context, dir string, finished bool, state *models.PartState, decode bool) (result partVerification, err error) {
+	var rawFilePath, tsFilePath string
+	rawFileName := state.RawFileName
+	if rawFileName == "" && filepath.Ext(state.FileName) != ".ts" {
+		// Manifests saved by older versions only have the name of the file being processed.
+		rawFileName = state.FileName
+	}
+	if rawFileName != "" {
+		rawFilePath = filepath.Join(dir, rawFileName)
+		tsFilePath = strings.TrimSuffix(rawFilePath, filepath.Ext(rawFilePath)) + ".ts"
+	} else {
+		tsFilePath = filepath.Join(dir, state.FileName)
+	}
+
+	switch {
+	case rawFilePath != "" && fileExists(rawFilePath):
+		result.Verdict, err = verifyFlvFile(ctx, rawFilePath, int64(state.Size), decode)
+	case fileExists(tsFilePath):
+		result.Verdict, err = verifyTsFile(ctx, tsFilePath, state.Fingerprint, decode)
+	case finished:
+		result.Skipped = "已合并"
+	default:
+		// Not downloaded yet, `resume` will take care of it.
+		result.Skipped = "文件不存在"
+	}
+	return
+}
+
+func fileExists(filePath string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// handleVerifyAction handles `verify` subcommand. The only error it might return is cli.Exit.
+// It verifies downloaded parts in the record directory, and records verdicts into the job manifest.
+func handleVerifyAction(c *cli.Context, _ *BiliClient) error {
+	dir := c.String("dir")
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		return cli.Exit(fmt.Sprintf("不支持的输出格式%s", format), returnCodeError)
+	}
+
+	manifest, err := models.LoadJobManifest(dir)
+	if err != nil {
+		logger.Error().Err(err).Str("下载目录", dir).Msg("读取下载任务状态出错")
+		return cli.Exit("没有找到可以校验的下载任务", returnCodeError)
+	}
+
+	if !ffmpeg.Available() {
+		logger.Warn().Msg("没有找到ffmpeg工具，只检查文件结构")
+	}
+
+	partNumbers := make([]int, 0, len(manifest.Parts))
+	for i := range manifest.Parts {
+		partNumbers = append(partNumbers, i)
+	}
+	sort.Ints(partNumbers)
+
+	var results []partVerification
+	var corrupted []int
+	for _, i := range partNumbers {
+		state := manifest.Part(i)
+		logger.Info().Int("编号", i).Str("文件", state.FileName).Msg("校验分段")
+		result, err := verifyRecordPart(c.Context, dir, manifest.Finished, state, c.Bool("decode"))
+		if c.Context.Err() != nil {
+			return cli.Exit("校验已中断", returnCodeInterrupted)
+		}
+		if err != nil {
+			logger.Error().Err(err).Int("编号", i).Msg("校验出错")
+			return cli.Exit(fmt.Sprintf("校验第%d部分出错", i), returnCodeError)
+		}
+		result.PartNumber = i
+		results = append(results, result)
+		if result.Verdict == nil {
+			continue
+		}
+
+		manifest.UpdatePart(i, func(state *models.PartState) {
+			state.Verdict = result.Verdict
+		})
+		if !result.Verdict.OK {
+			corrupted = append(corrupted, i)
+			if c.Bool("delete-corrupted") {
+				deleteCorruptedPart(manifest, dir, i, result.Verdict.File)
+			}
+		}
+	}
+	if err := manifest.Save(); err != nil {
+		logger.Error().Err(err).Str("文件", manifest.Path()).Msg("保存下载任务状态出错")
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(c.App.Writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return cli.Exit(err.Error(), returnCodeError)
+		}
+	default:
+		w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "编号\t文件\t结果\t问题")
+		for _, result := range results {
+			switch {
+			case result.Verdict == nil:
+				fmt.Fprintf(w, "%d\t-\t跳过\t%s\n", result.PartNumber, result.Skipped)
+			case result.Verdict.OK:
+				fmt.Fprintf(w, "%d\t%s\t正常\t\n", result.PartNumber, result.Verdict.File)
+			default:
+				fmt.Fprintf(w, "%d\t%s\t损坏\t%s\n", result.PartNumber, result.Verdict.File, strings.Join(result.Verdict.Problems, "；"))
+			}
+		}
+		w.Flush()
+	}
+
+	if len(corrupted) > 0 {
+		if c.Bool("delete-corrupted") {
+			logger.Info().Ints("损坏的分段", corrupted).Msg("已删除损坏的分段，可以使用resume命令重新下载")
+		} else {
+			logger.Info().Ints("损坏的分段", corrupted).Msg("使用--delete-corrupted删除损坏的分段后，可以使用resume命令重新下载")
+		}
+		return cli.Exit(fmt.Sprintf("有%d个分段校验未通过", len(corrupted)), returnCodeError)
+	}
+	return nil
+}
+
+// deleteCorruptedPart deletes corrupted file of given part, and resets its state, so it will be downloaded again when resumed.
+func deleteCorruptedPart(manifest *models.JobManifest, dir string, partNumber int, fileName string) {
+	filePath := filepath.Join(dir, fileName)
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		logger.Error().Err(err).Str("文件", filePath).Msg("删除损坏的文件出错")
+		return
+	}
+
+	manifest.UpdatePart(partNumber, func(state *models.PartState) {
+		state.Step = models.StepFailed
+		state.BytesComplete = 0
+		state.Fingerprint = ""
+		state.Mirror = ""
+	})
+	manifest.Update(func(m *models.JobManifest) {
+		m.Finished = false
+	})
+	logger.Info().Int("编号", partNumber).Str("文件", fileName).Msg("已删除损坏的文件")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runApp runs the command line app with given arguments, using the config file with `config` as content.
+// Exit codes are returned as errors, instead of exiting the test.
+func runApp(t *testing.T, config string, args ...string) (string, error) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	osExiter := cli.OsExiter
+	cli.OsExiter = func(int) {}
+	defer func() { cli.OsExiter = osExiter }()
+
+	var output bytes.Buffer
+	app := newCliApp()
+	app.Writer, app.ErrWriter = &output, &output
+	err := app.Run(append([]string{"bililive-downloader", "--config", configFile}, args...))
+	return output.String(), err
+}
+
+func TestVerifyRecordPart(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "1.ts"), testTsMedia(t, time.Second), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "4.ts"), []byte("not really TS media"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "2.flv"), []byte("FLV"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := helper.FileFingerprint(filepath.Join(dir, "1.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testRow struct {
+		name     string
+		state    models.PartState
+		file     string
+		ok       bool
+		skipped  string
+		finished bool
+	}
+	rows := []testRow{
+		{"finished", models.PartState{FileName: "1.ts", RawFileName: "1.flv", Size: 4096, Fingerprint: fingerprint}, "1.ts", true, "", false},
+		{"finished, saved by older versions", models.PartState{FileName: "1.ts", Size: 4096, Fingerprint: fingerprint}, "1.ts", true, "", false},
+		{"de-capped, but not finished yet", models.PartState{FileName: "1.flv", Size: 4096}, "1.ts", true, "", false},
+		{"fingerprint mismatch", models.PartState{FileName: "1.ts", RawFileName: "1.flv", Fingerprint: "bad"}, "1.ts", false, "", false},
+		{"corrupted TS", models.PartState{FileName: "4.ts", RawFileName: "4.flv"}, "4.ts", false, "", false},
+		{"raw FLV", models.PartState{FileName: "2.flv", RawFileName: "2.flv", Size: 4096}, "2.flv", false, "", false},
+		{"merged", models.PartState{FileName: "3.ts", RawFileName: "3.flv"}, "", false, "已合并", true},
+		{"not downloaded", models.PartState{FileName: "3.flv", RawFileName: "3.flv"}, "", false, "文件不存在", false},
+	}
+	for _, row := range rows {
+		result, err := verifyRecordPart(context.Background(), dir, row.finished, &row.state, false)
+		if !assert.NoError(t, err, row.name) {
+			continue
+		}
+		assert.Equal(t, row.skipped, result.Skipped, row.name)
+		if row.file == "" {
+			assert.Nil(t, result.Verdict, row.name)
+			continue
+		}
+		if assert.NotNil(t, result.Verdict, row.name) {
+			assert.Equal(t, row.file, result.Verdict.File, row.name)
+			assert.Equal(t, row.ok, result.Verdict.OK, "%s: %v", row.name, result.Verdict.Problems)
+		}
+	}
+}
+
+func TestHandleVerifyAction(t *testing.T) {
+	dir := t.TempDir()
+	tsFile := filepath.Join(dir, "1.ts")
+	if err := ioutil.WriteFile(tsFile, testTsMedia(t, time.Second), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := helper.FileFingerprint(tsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptedFile := filepath.Join(dir, "2.ts")
+	if err := ioutil.WriteFile(corruptedFile, []byte("not really TS media"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Finished parts, as recorded after de-capping, the second one is corrupted since.
+	manifest := models.NewJobManifest(dir, "R1")
+	manifest.UpdatePart(1, func(state *models.PartState) {
+		state.Step = models.StepDone
+		state.FileName = "1.ts"
+		state.RawFileName = "1.flv"
+		state.Size = 4096
+		state.Fingerprint = fingerprint
+	})
+	manifest.UpdatePart(2, func(state *models.PartState) {
+		state.Step = models.StepDone
+		state.FileName = "2.ts"
+		state.RawFileName = "2.flv"
+	})
+	if err := manifest.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := runApp(t, "", "verify", "--dir", dir, "--delete-corrupted", "--format", "json")
+	assert.Error(t, err)
+	var results []partVerification
+	if assert.NoError(t, json.Unmarshal([]byte(output), &results), output) && assert.Len(t, results, 2) {
+		assert.True(t, results[0].Verdict.OK)
+		assert.False(t, results[1].Verdict.OK)
+	}
+	assert.FileExists(t, tsFile)
+	assert.NoFileExists(t, corruptedFile)
+
+	saved, err := models.LoadJobManifest(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, fingerprint, saved.Part(1).Fingerprint)
+		assert.True(t, saved.Part(1).Verdict.OK)
+		assert.Equal(t, models.StepFailed, saved.Part(2).Step)
+		assert.False(t, saved.Part(2).Verdict.OK)
+	}
+}
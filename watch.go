@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bililive-downloader/models"
+	"bililive-downloader/progressbar"
+	"context"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"time"
+)
+
+const defaultWatchInterval = time.Minute * 10
+
+// watchPageSize is how many latest records are checked for each round. New records always come first.
+const watchPageSize = 20
+
+// handleWatchAction handles `watch` subcommand.
+// It checks records of given room periodically, and downloads those not downloaded yet. It runs until interrupted.
+func handleWatchAction(c *cli.Context, client *BiliClient) error {
+	roomID := c.Int64("room")
+	interval := c.Duration("interval")
+	if interval < time.Minute {
+		interval = time.Minute
+		logger.Warn().Dur("时间间隔", interval).Msg("检查间隔过短，自动调整")
+	}
+
+	statePath := c.String("state")
+	if statePath == "" {
+		statePath = fmt.Sprintf("watch-%d.json", roomID)
+	}
+	state, err := models.LoadWatchState(statePath, roomID)
+	if err != nil {
+		logger.Error().Err(err).Str("状态文件", statePath).Msg("读取监视状态出错")
+		return cli.Exit("读取监视状态出错", returnCodeError)
+	}
+
+	template := DownloadParam{
+		Concurrency:   c.Uint("concurrency"),
+		NoMerge:       c.Bool("no-merge"),
+		Merge:         c.Bool("merge"),
+		FixTimestamps: c.Bool("fix-timestamps"),
+		RateLimit:     rateLimitFromFlags(c),
+		Retry:         retryPolicyFromFlags(c),
+		Quality:       c.String("quality"),
+	}
+	if template.Remuxer, err = remuxerFromFlags(c); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if template.Container, err = containerFromFlags(c); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if template.OutputDir, template.DirTemplate, template.NameTemplate, err = namingFromFlags(c); err != nil {
+		return cli.Exit(err.Error(), returnCodeError)
+	}
+	if template.Concurrency == 0 {
+		template.Concurrency = defaultConcurrency
+	}
+
+	// Skip all current records on first run, if asked to.
+	if c.Bool("skip-existing") && len(state.Fetched) == 0 {
+		list, err := client.fetchRecordList(c.Context, roomID, 1, watchPageSize)
+		if err != nil {
+			logger.Error().Err(err).Int64("直播间ID", roomID).Msg("加载直播间回放列表出错")
+			return cli.Exit("加载直播间回放列表出错", returnCodeError)
+		}
+		for _, record := range list.List {
+			state.Fetched = append(state.Fetched, record.ID)
+		}
+		if err := state.Save(); err != nil {
+			logger.Error().Err(err).Str("状态文件", statePath).Msg("保存监视状态出错")
+			return cli.Exit("保存监视状态出错", returnCodeError)
+		}
+		logger.Info().Int("回放数量", len(state.Fetched)).Msg("跳过直播间已有的回放")
+	}
+
+	// We're running as a daemon, progress bars make no sense.
+	// They're disabled rather than discarded, so bars of each record don't pile up.
+	progressbar.Init(nil)
+	pool := newDownloadPool(template.Concurrency, template.RateLimit, template.Retry, template.Remuxer)
+	defer pool.close()
+
+	logger.Info().Int64("直播间ID", roomID).Dur("时间间隔", interval).Str("状态文件", statePath).Msg("开始监视直播间")
+	for {
+		watchRoundOnce(c.Context, client, pool, state, template)
+
+		select {
+		case <-time.After(interval):
+		case <-c.Context.Done():
+			logger.Info().Int64("直播间ID", roomID).Msg("停止监视直播间")
+			return nil
+		}
+	}
+}
+
+// watchRoundOnce checks latest records of the room being watched with `client`, and downloads new ones with `pool`.
+// Successfully downloaded records are remembered in `state`, failed ones will be tried again in next round.
+func watchRoundOnce(ctx context.Context, client *BiliClient, pool *downloadPool, state *models.WatchState, template DownloadParam) {
+	list, err := client.fetchRecordList(ctx, state.RoomID, 1, watchPageSize)
+	if err != nil {
+		logger.Error().Err(err).Int64("直播间ID", state.RoomID).Msg("加载直播间回放列表出错")
+		return
+	}
+
+	// Download older records first.
+	for i := len(list.List) - 1; i >= 0 && ctx.Err() == nil; i-- {
+		record := list.List[i]
+		if state.IsFetched(record.ID) {
+			continue
+		}
+
+		logger.Info().Str("直播回放ID", record.ID).Str("标题", record.Title).Str("开始于", record.Start.String()).Msg("发现新的直播回放")
+		param := template
+		param.RecordID = record.ID
+		if err := loadRecordMeta(ctx, client, &param); err != nil {
+			continue
+		}
+		for i := range param.Parts.List {
+			param.DownloadList = append(param.DownloadList, i+1)
+		}
+
+		if err := cliDownload(ctx, pool, param); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error().Err(err).Str("直播回放ID", record.ID).Msg("下载失败，将在下次检查时重试")
+			continue
+		}
+		if err := state.MarkFetched(record.ID); err != nil {
+			logger.Error().Err(err).Str("直播回放ID", record.ID).Msg("保存监视状态出错")
+		}
+		logger.Info().Str("直播回放ID", record.ID).Msg("下载成功")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bililive-downloader/helper"
+	"bililive-downloader/models"
+	"bililive-downloader/mpegts"
+	"bililive-downloader/progressbar"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTsMedia returns valid MPEG TS media with a single video stream lasting `duration`.
+func testTsMedia(t *testing.T, duration time.Duration) []byte {
+	var buf bytes.Buffer
+	muxer := mpegts.NewMuxer(&buf)
+	video, err := muxer.AddStream(mpegts.StreamTypeH264)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 200)
+	for ts := time.Duration(0); ts <= duration; ts += 40 * time.Millisecond {
+		if err := muxer.WritePacket(video, &mpegts.Packet{PTS: ts, DTS: ts, Keyframe: ts%time.Second == 0, Data: frame}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestWatchRoundOnce(t *testing.T) {
+	progressbar.Init(ioutil.Discard)
+	outputDir := t.TempDir()
+
+	// Records R1~R3 with a single part each, latest first. Media is never served, parts are de-capped in advance instead.
+	// Parts list of records in `unavailable` can't be loaded.
+	var guard sync.Mutex
+	requested := make(map[string]int)
+	unavailable := map[string]bool{"R2": true}
+	client := newFakeApiServer(t, func(w http.ResponseWriter, r *http.Request) bool {
+		rid := r.URL.Query().Get("rid")
+		switch r.URL.Path {
+		case "/xlive/web-room/v1/record/getList":
+			list := `{"count":3,"list":[{"rid":"R3","title":"3"},{"rid":"R2","title":"2"},{"rid":"R1","title":"1"}]}`
+			_, _ = fmt.Fprintf(w, `{"code":0,"message":"0","ttl":1,"data":%s}`, list)
+			return true
+		case "/xlive/web-room/v1/record/getLiveRecordUrl":
+			guard.Lock()
+			requested[rid]++
+			failing := unavailable[rid]
+			guard.Unlock()
+			if failing {
+				_, _ = fmt.Fprint(w, `{"code":-404,"message":"啥都木有","ttl":1,"data":null}`)
+				return true
+			}
+			parts := fmt.Sprintf(`{"list":[{"url":"http://%s/media/%s.flv","size":1024,"length":1000}],"size":1024,"length":1000,"current_qn":10000,"qn_desc":[{"qn":10000,"desc":"原画"}]}`, r.Host, rid)
+			_, _ = fmt.Fprintf(w, `{"code":0,"message":"0","ttl":1,"data":%s}`, parts)
+			return true
+		}
+		return false
+	})
+	// prepareRecord de-caps the part of given record in advance, so downloading it succeeds without any media.
+	prepareRecord := func(recordID string) {
+		dir := filepath.Join(outputDir, recordID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, recordID+".ts"), testTsMedia(t, time.Second), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	statePath := filepath.Join(outputDir, "watch.json")
+	state, err := models.LoadWatchState(statePath, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Fetched = []string{"R1"}
+	template := DownloadParam{
+		Concurrency: 1,
+		NoMerge:     true,
+		Retry:       helper.RetryPolicy{Backoff: time.Millisecond},
+		Remuxer:     remuxerNative,
+		OutputDir:   outputDir,
+		DirTemplate: "{rid}",
+	}
+	pool := newDownloadPool(template.Concurrency, template.RateLimit, template.Retry, template.Remuxer)
+	defer pool.close()
+
+	// R1 was fetched before, R2 fails, R3 succeeds.
+	prepareRecord("R2")
+	prepareRecord("R3")
+	watchRoundOnce(context.Background(), client, pool, state, template)
+	assert.Equal(t, []string{"R1", "R3"}, state.Fetched)
+	assert.Equal(t, map[string]int{"R2": 1, "R3": 1}, requested)
+	saved, err := models.LoadWatchState(statePath, 100)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"R1", "R3"}, saved.Fetched)
+	}
+	assert.FileExists(t, filepath.Join(outputDir, "R3", "播放列表.m3u8"))
+
+	// R2 is tried again in next round, and succeeds this time.
+	guard.Lock()
+	unavailable["R2"] = false
+	guard.Unlock()
+	watchRoundOnce(context.Background(), client, pool, state, template)
+	assert.Equal(t, []string{"R1", "R3", "R2"}, state.Fetched)
+	assert.Equal(t, map[string]int{"R2": 2, "R3": 1}, requested)
+	saved, err = models.LoadWatchState(statePath, 100)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"R1", "R3", "R2"}, saved.Fetched)
+	}
+
+	// Nothing new, nothing is downloaded.
+	watchRoundOnce(context.Background(), client, pool, state, template)
+	assert.Equal(t, map[string]int{"R2": 2, "R3": 1}, requested)
+
+	// Failing to list records changes nothing.
+	client.BaseURL += "/nowhere"
+	watchRoundOnce(context.Background(), client, pool, state, template)
+	assert.Equal(t, []string{"R1", "R3", "R2"}, state.Fetched)
+}